@@ -0,0 +1,252 @@
+package comfy
+
+import "sort"
+
+// JSONSchemaDialect is the JSON Schema version of the generated schemas, the one of OpenAPI 3.1
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of JSON Schema used to describe the values of properties.  Steps and multiline
+// strings have no JSON Schema keyword, they are hints for form generators in the x-step and x-multiline
+// extensions.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentMediaType     string                 `json:"contentMediaType,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Minimum              interface{}            `json:"minimum,omitempty"`
+	Maximum              interface{}            `json:"maximum,omitempty"`
+	MultipleOf           interface{}            `json:"multipleOf,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Step                 interface{}            `json:"x-step,omitempty"`
+	Multiline            bool                   `json:"x-multiline,omitempty"`
+}
+
+// PropertySchema returns the JSON Schema of the values of the property, with its current value as the
+// default and its tooltip as the description.  An IMAGEUPLOAD property is a binary file.  Nil is returned
+// for properties that cannot be set, such as CASCADE and UNKNOWN properties and inputs without a widget.
+func PropertySchema(p Property) *JSONSchema {
+	if p.ForceInput() {
+		return nil
+	}
+	retv := &JSONSchema{Title: p.Name(), Description: p.Tooltip()}
+	value := p.GetValue()
+	switch v := p.(type) {
+	case *BoolProperty:
+		retv.Type = "boolean"
+		retv.Default = v.Default
+		if b, ok := value.(bool); ok {
+			retv.Default = b
+		}
+	case *IntProperty:
+		retv.Type = "integer"
+		retv.Default = v.Default
+		if i, err := intValue(value); err == nil {
+			retv.Default = i
+		}
+		if v.HasRange() {
+			retv.Minimum = v.Min
+			retv.Maximum = v.Max
+		}
+		if v.HasStep() && v.Step > 1 {
+			retv.Step = v.Step
+			// steps are counted from the minimum, which JSON Schema cannot express unless it is on a step
+			if !v.HasRange() || v.Min%v.Step == 0 {
+				retv.MultipleOf = v.Step
+			}
+		}
+	case *FloatProperty:
+		retv.Type = "number"
+		retv.Default = v.Default
+		if f, err := floatValue(value); err == nil {
+			retv.Default = f
+		}
+		if v.HasRange() {
+			retv.Minimum = v.Min
+			retv.Maximum = v.Max
+		}
+		if v.HasStep() && v.Step > 0 {
+			retv.Step = v.Step
+		}
+	case *StringProperty:
+		retv.Type = "string"
+		retv.Default = v.Default
+		if s, ok := value.(string); ok {
+			retv.Default = s
+		}
+		retv.Multiline = v.Multiline
+	case *ComboProperty:
+		if v.IsBool {
+			retv.Type = "boolean"
+			if b, ok := value.(bool); ok {
+				retv.Default = b
+			}
+			break
+		}
+		values := &JSONSchema{Type: "string"}
+		// the values of a remote combo are not known
+		if v.Remote == "" {
+			values.Enum = make([]interface{}, 0, len(v.Values))
+			for _, s := range v.Values {
+				values.Enum = append(values.Enum, s)
+			}
+		}
+		if v.MultiSelect {
+			retv.Type = "array"
+			retv.Items = values
+			if list, ok := value.([]interface{}); ok {
+				retv.Default = list
+			}
+			break
+		}
+		retv.Type = values.Type
+		retv.Enum = values.Enum
+		if s, ok := value.(string); ok {
+			retv.Default = s
+		}
+	case *ImageUploadProperty:
+		retv.Type = "string"
+		retv.Format = "binary"
+		retv.ContentMediaType = "image/*"
+		if v.TargetProperty != nil && v.TargetProperty.Upload != "" {
+			retv.ContentMediaType = v.TargetProperty.Upload + "/*"
+		}
+	default:
+		return nil
+	}
+	return retv
+}
+
+// PropertiesSchema returns the JSON Schema of an object with a member for each of the properties that
+// can be set, named by the keys of props
+func PropertiesSchema(props map[string]Property) *JSONSchema {
+	retv := &JSONSchema{
+		Schema:     JSONSchemaDialect,
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+	for name, p := range props {
+		if s := PropertySchema(p); s != nil {
+			retv.Properties[name] = s
+		}
+	}
+	return retv
+}
+
+// JSONSchema returns the JSON Schema of the parameters of the SimpleAPI, by the titles of their nodes
+func (s *SimpleAPI) JSONSchema() *JSONSchema {
+	return PropertiesSchema(s.Properties)
+}
+
+// OpenAPIDocument is the subset of an OpenAPI 3.1 document used to describe running a workflow
+type OpenAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *JSONSchema `json:"schema"`
+}
+
+// WorkflowOpenAPI returns an OpenAPI document with a "runWorkflow" POST operation on path, that takes the
+// properties as the members of its request body.  The body is multipart/form-data when a file is
+// uploaded, JSON otherwise.  The response lists the DataOutputs of each of the outputs.
+func WorkflowOpenAPI(title string, path string, props map[string]Property, outputs []string) *OpenAPIDocument {
+	params := PropertiesSchema(props)
+	// the dialect is the one of the document
+	params.Schema = ""
+	contentType := "application/json"
+	for _, s := range params.Properties {
+		if s.Format == "binary" {
+			contentType = "multipart/form-data"
+			break
+		}
+	}
+
+	dataOutput := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"filename":  {Type: "string"},
+			"subfolder": {Type: "string"},
+			"type":      {Type: "string"},
+		},
+	}
+	result := &JSONSchema{
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+	for _, o := range outputs {
+		result.Properties[o] = &JSONSchema{Type: "array", Items: dataOutput}
+	}
+	if len(outputs) == 0 {
+		result.AdditionalProperties = &JSONSchema{Type: "array", Items: dataOutput}
+	}
+
+	return &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    OpenAPIInfo{Title: title, Version: "1.0.0"},
+		Paths: map[string]map[string]*OpenAPIOperation{
+			path: {
+				"post": {
+					OperationID: "runWorkflow",
+					Summary:     "Run the " + title + " workflow",
+					RequestBody: &OpenAPIRequestBody{
+						Required: true,
+						Content:  map[string]OpenAPIMediaType{contentType: {Schema: params}},
+					},
+					Responses: map[string]*OpenAPIResponse{
+						"200": {
+							Description: "The outputs of the workflow",
+							Content: map[string]OpenAPIMediaType{"application/json": {Schema: &JSONSchema{
+								Type:       "object",
+								Properties: map[string]*JSONSchema{"outputs": result},
+							}}},
+						},
+						"400": {Description: "Invalid parameters"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// OpenAPI returns an OpenAPI document with an operation on path running the workflow of the SimpleAPI,
+// see WorkflowOpenAPI.  The outputs are named as by OutputNames.
+func (s *SimpleAPI) OpenAPI(title string, path string) *OpenAPIDocument {
+	names := s.OutputNames()
+	outputs := make([]string, 0, len(names))
+	for name := range names {
+		outputs = append(outputs, name)
+	}
+	sort.Strings(outputs)
+	return WorkflowOpenAPI(title, path, s.Properties, outputs)
+}
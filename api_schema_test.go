@@ -0,0 +1,100 @@
+package comfy
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+func TestPropertySchema(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	ks, latent := graph.GetNodeById(3), graph.GetNodeById(5)
+
+	steps := PropertySchema(ks.GetPropertyWithName("steps"))
+	if steps.Type != "integer" || steps.Minimum != int64(1) || steps.Maximum != int64(10000) || steps.Default != int64(20) {
+		t.Errorf("unexpected schema of steps %+v", steps)
+	}
+	width := PropertySchema(latent.GetPropertyWithName("width"))
+	if width.Step != int64(8) || width.MultipleOf != int64(8) {
+		t.Errorf("unexpected step of width %+v", width)
+	}
+	cfg := PropertySchema(ks.GetPropertyWithName("cfg"))
+	if cfg.Type != "number" || cfg.Maximum != 100.0 || cfg.Step != 0.1 {
+		t.Errorf("unexpected schema of cfg %+v", cfg)
+	}
+	sampler := PropertySchema(ks.GetPropertyWithName("sampler_name"))
+	if sampler.Type != "string" || len(sampler.Enum) != 4 || sampler.Default != "euler" {
+		t.Errorf("unexpected schema of sampler_name %+v", sampler)
+	}
+	text := PropertySchema(graph.GetNodeById(6).GetPropertyWithName("text"))
+	if text.Type != "string" || !text.Multiline || text.Default != "a cat" {
+		t.Errorf("unexpected schema of text %+v", text)
+	}
+
+	// the input as decoded from the object info of a V3 node
+	var input interface{} = []interface{}{"INT", map[string]interface{}{"forceInput": true}}
+	if s := PropertySchema(*NewPropertyFromInput("count", false, &input, 0)); s != nil {
+		t.Errorf("an input without a widget has the schema %+v", s)
+	}
+}
+
+func TestWorkflowOpenAPI(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	api := graph.GetSimpleAPI(nil)
+	if api == nil || len(api.Properties) != 2 {
+		t.Fatalf("the API group has the properties %v, want the two prompts", api)
+	}
+
+	doc := api.OpenAPI("txt2img", "/txt2img")
+	body := doc.Paths["/txt2img"]["post"].RequestBody
+	params := body.Content["application/json"].Schema
+	if params == nil || params.Properties["Positive"] == nil || params.Properties["Negative"] == nil || params.Schema != "" {
+		t.Errorf("unexpected parameters %+v", body.Content)
+	}
+
+	loader, err := graph.AddNode("LoadImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Properties["Image"] = loader.GetPropertyWithName("choose file to upload")
+	doc = api.OpenAPI("img2img", "/img2img")
+	params = doc.Paths["/img2img"]["post"].RequestBody.Content["multipart/form-data"].Schema
+	if params == nil || params.Properties["Image"].Format != "binary" || params.Properties["Image"].ContentMediaType != "image/*" {
+		t.Error("an uploaded image is not a binary part of a multipart body")
+	}
+}
+
+func TestOutputNames(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	api := &SimpleAPI{Properties: make(map[string]Property), OutputNodes: []*GraphNode{graph.GetNodeById(9)}}
+	for _, title := range []string{"Preview", "Preview", "Final"} {
+		n, err := graph.AddNode("PreviewImage")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Title = title
+		api.OutputNodes = append(api.OutputNodes, n)
+	}
+
+	names := api.OutputNames()
+	got := make([]string, 0, len(names))
+	for name := range names {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{"Final", "PreviewImage_10", "PreviewImage_11", "SaveImage_9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("output names %v, want %v", got, want)
+	}
+	if names["SaveImage_9"] != graph.GetNodeById(9) {
+		t.Error("the name of the SaveImage is not the one of its node")
+	}
+
+	result := api.OpenAPI("txt2img", "/txt2img").Paths["/txt2img"]["post"].Responses["200"]
+	outputs := result.Content["application/json"].Schema.Properties["outputs"]
+	if len(outputs.Properties) != len(want) || outputs.AdditionalProperties != nil {
+		t.Errorf("the response has the outputs %v, want %v", outputs.Properties, want)
+	}
+}
@@ -1,11 +1,13 @@
 package comfy
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/er1cw00/comfy.go/base"
@@ -22,9 +24,9 @@ const (
 )
 
 type ComfyClientCallbacks struct {
-	WebsocketConnected    func(*ComfyClient)
-	WebsocketDisconnected func(*ComfyClient)
-	// ClientQueueCountChanged func(*ComfyClient, int)
+	WebsocketConnected      func(*ComfyClient)
+	WebsocketDisconnected   func(*ComfyClient)
+	ClientQueueCountChanged func(*ComfyClient, int)
 	// QueuedItemStarted       func(*ComfyClient, *QueueItem)
 	// QueuedItemStopped       func(*ComfyClient, *QueueItem, QueuedItemStoppedReason)
 	// QueuedItemDataAvailable func(*ComfyClient, *QueueItem, *PromptMessageData)
@@ -38,9 +40,12 @@ type ComfyClient struct {
 	nodeObjects           *NodeObjects
 	queuecount            int
 	callbacks             *ComfyClientCallbacks
+	httpClient            *http.Client
 	lastProcessedPromptID string
-	messages              chan PromptMessage
-	//queueditems           map[string]*QueueItem
+	queueditems           map[string]*QueueItem
+	queueMutex            sync.Mutex // guards queueditems, queuecount and lastProcessedPromptID
+	seedControlDisabled   bool
+	seedSource            SeedSource
 }
 
 // NewComfyClientWithTimeout creates a new instance of a Comfy2go client with a connection timeout
@@ -55,12 +60,11 @@ func NewComfyClientWithTimeout(baseAddr string, callbacks *ComfyClientCallbacks,
 			isRunning:   false,
 			maxDelay:    time.Duration(timeout) * time.Second,
 		},
-		queuecount: 0,
-		callbacks:  callbacks,
-		messages:   nil,
-		//queueditems: make(map[string]*QueueItem),
+		queuecount:  0,
+		callbacks:   callbacks,
+		httpClient:  &http.Client{},
+		queueditems: make(map[string]*QueueItem),
 	}
-	retv.messages = make(chan PromptMessage)
 	// golang uses mark-sweep GC, so this circular reference should be fine
 	retv.websocket.callback = retv
 	return retv
@@ -78,20 +82,16 @@ func NewComfyClient(baseAddr string, callbacks *ComfyClientCallbacks) *ComfyClie
 			isRunning:   false,
 			maxDelay:    60 * time.Second,
 		},
-		queuecount: 0,
-		callbacks:  callbacks,
-		messages:   nil,
-		//queueditems: make(map[string]*QueueItem),
+		queuecount:  0,
+		callbacks:   callbacks,
+		httpClient:  &http.Client{},
+		queueditems: make(map[string]*QueueItem),
 	}
-	retv.messages = make(chan PromptMessage)
 	// golang uses mark-sweep GC, so this circular reference should be fine
 	retv.websocket.callback = retv
 	return retv
 }
 
-func (cc *ComfyClient) GetMessages() chan PromptMessage {
-	return cc.messages
-}
 func (cc *ComfyClient) OnMessage(message string) {
 	cc.OnWindowSocketMessage(message)
 }
@@ -116,6 +116,65 @@ func (cc *ComfyClient) Start() {
 	return
 }
 
+// Close stops the websocket connection and its reconnect loop, waiting until the loop has exited or ctx is done.
+// The message channels of prompts still queued with the client are closed without a "stopped" message, and
+// the messages that were not read are dropped.
+func (cc *ComfyClient) Close(ctx context.Context) error {
+	if err := cc.websocket.Stop(ctx); err != nil {
+		return err
+	}
+
+	cc.queueMutex.Lock()
+	items := cc.queueditems
+	cc.queueditems = make(map[string]*QueueItem)
+	cc.queueMutex.Unlock()
+	for _, qi := range items {
+		qi.queue.discard()
+	}
+	return nil
+}
+
+// SetHTTPClient replaces the http.Client used for requests to the ComfyUI server
+func (cc *ComfyClient) SetHTTPClient(client *http.Client) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	cc.httpClient = client
+}
+
+// SetSeedControl enables or disables changing the seeds of a graph according to their control_after_generate
+// once it was queued, see Graph.ApplySeedControl.  It is enabled by default.
+func (cc *ComfyClient) SetSeedControl(enabled bool) {
+	cc.seedControlDisabled = !enabled
+}
+
+// SetSeedSource sets the source of the random seeds for control_after_generate "randomize", nil uses math/rand
+func (cc *ComfyClient) SetSeedSource(source SeedSource) {
+	cc.seedSource = source
+}
+
+// IsConnected returns true while the websocket connection to the ComfyUI server is established
+func (cc *ComfyClient) IsConnected() bool {
+	return cc.websocket.isConnected
+}
+
+// QueueCount returns the number of prompts remaining in the server queue, as last reported over the websocket
+func (cc *ComfyClient) QueueCount() int {
+	cc.queueMutex.Lock()
+	defer cc.queueMutex.Unlock()
+	return cc.queuecount
+}
+
+// NodeObjects returns the node objects queried from the server, or nil if they have not been queried yet
+func (cc *ComfyClient) NodeObjects() *NodeObjects {
+	return cc.nodeObjects
+}
+
+// BaseAddr returns the address of the ComfyUI server
+func (cc *ComfyClient) BaseAddr() string {
+	return cc.baseAddr
+}
+
 func (cc *ComfyClient) IsInitialized() bool {
 	if cc.websocket.isConnected && cc.nodeObjects != nil {
 		return true
@@ -123,12 +182,16 @@ func (cc *ComfyClient) IsInitialized() bool {
 	return false
 }
 func (cc *ComfyClient) QueryNodeObjects() error {
+	return cc.QueryNodeObjectsContext(context.Background())
+}
+
+func (cc *ComfyClient) QueryNodeObjectsContext(ctx context.Context) error {
 	if !cc.websocket.isConnected {
 		return ErrComfyDisconnected
 	}
 	// Get the object infos for the Comfy Server
 	if cc.nodeObjects == nil {
-		object_infos, err := cc.GetObjectInfos()
+		object_infos, err := cc.GetObjectInfosContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -142,6 +205,14 @@ func (c *ComfyClient) ClientID() string {
 	return c.clientId
 }
 
+// NewGraph creates an empty graph that nodes can be added to
+func (cc *ComfyClient) NewGraph() (*Graph, error) {
+	if cc.nodeObjects == nil {
+		return nil, ErrNotNodeObjects
+	}
+	return NewGraph(cc.nodeObjects), nil
+}
+
 // NewGraphFromJsonReader creates a new graph from the data read from an io.Reader
 func (cc *ComfyClient) NewGraphFromJsonReader(r io.Reader) (*Graph, *[]string, error) {
 	if cc.nodeObjects == nil {
@@ -195,15 +266,80 @@ func (c *ComfyClient) NewGraphFromPNGFile(path string) (*Graph, *[]string, error
 	return c.NewGraphFromPNGReader(file)
 }
 
+// NewPromptFromJsonReader creates a new prompt from "API format" JSON read from an io.Reader
+func (cc *ComfyClient) NewPromptFromJsonReader(r io.Reader) (*Prompt, *[]string, error) {
+	if cc.nodeObjects == nil {
+		return nil, nil, ErrNotNodeObjects
+	}
+	return NewPromptFromJsonReader(r, cc.nodeObjects)
+}
+
+// NewPromptFromJsonFile creates a new prompt from an "API format" JSON file
+func (cc *ComfyClient) NewPromptFromJsonFile(path string) (*Prompt, *[]string, error) {
+	if cc.nodeObjects == nil {
+		return nil, nil, ErrNotNodeObjects
+	}
+	return NewPromptFromJsonFile(path, cc.nodeObjects)
+}
+
+// NewPromptFromJsonString creates a new prompt from an "API format" JSON string
+func (cc *ComfyClient) NewPromptFromJsonString(data string) (*Prompt, *[]string, error) {
+	if cc.nodeObjects == nil {
+		return nil, nil, ErrNotNodeObjects
+	}
+	return NewPromptFromJsonString(data, cc.nodeObjects)
+}
+
 // GetQueuedItem returns a QueueItem that was queued with the ComfyClient, that has not been processed yet
 // or is currently being processed.  Once a QueueItem has been processed, it will not be available with this method.
-// func (c *ComfyClient) GetQueuedItem(promptId string) *QueueItem {
-// 	val, ok := c.queueditems[promptId]
-// 	if ok {
-// 		return val
-// 	}
-// 	return nil
-// }
+func (c *ComfyClient) GetQueuedItem(promptId string) *QueueItem {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	val, ok := c.queueditems[promptId]
+	if ok {
+		return val
+	}
+	return nil
+}
+
+func (c *ComfyClient) addQueuedItem(item *QueueItem) {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	c.queueditems[item.PromptID] = item
+}
+
+// removeQueuedItem removes the QueueItem from the collection of queued items and returns it,
+// or nil if it was not queued with this client
+func (c *ComfyClient) removeQueuedItem(promptId string) *QueueItem {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	val, ok := c.queueditems[promptId]
+	if !ok {
+		return nil
+	}
+	delete(c.queueditems, promptId)
+	return val
+}
+
+func (c *ComfyClient) setLastProcessedPromptID(promptId string) {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	c.lastProcessedPromptID = promptId
+}
+
+// stopQueuedItem removes the QueueItem for the prompt, sends it the final "stopped" message
+// and closes its message channel
+func (c *ComfyClient) stopQueuedItem(m *PromptMessageStopped) {
+	qi := c.removeQueuedItem(m.PromptID)
+	if qi == nil {
+		return
+	}
+	qi.queue.post(PromptMessage{
+		Type:    "stopped",
+		Message: m,
+	})
+	qi.queue.close()
+}
 
 // OnWindowSocketMessage processes each message received from the websocket connection to ComfyUI.
 // The messages are parsed, and translated into PromptMessage structs and placed into the correct QueuedItem's message channel.
@@ -212,26 +348,54 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 	err := json.Unmarshal([]byte(msg), &message)
 	if err != nil {
 		logger.Errorf("Deserializing Status Message: %v", err)
+		return
 	}
 
 	switch message.Type {
 	case "status":
+		s := message.Data.(*MessageDataStatus)
+		c.queueMutex.Lock()
+		changed := c.queuecount != s.Status.ExecInfo.QueueRemaining
+		c.queuecount = s.Status.ExecInfo.QueueRemaining
+		c.queueMutex.Unlock()
+		if changed && c.callbacks != nil && c.callbacks.ClientQueueCountChanged != nil {
+			c.callbacks.ClientQueueCountChanged(c, s.Status.ExecInfo.QueueRemaining)
+		}
 	case "execution_start":
 		s := message.Data.(*MessageDataExecutionStart)
 
 		// update lastProcessedPromptID to indicate we are processing a new prompt
-		c.lastProcessedPromptID = s.PromptID
-		m := PromptMessage{
-			Type: "started",
-			Message: &PromptMessageStarted{
-				PromptID: s.PromptID,
-			},
-		}
-		if c.messages != nil {
-			c.messages <- m
+		c.setLastProcessedPromptID(s.PromptID)
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			qi.queue.post(PromptMessage{
+				Type: "started",
+				Message: &PromptMessageStarted{
+					PromptID: s.PromptID,
+				},
+			})
 		}
 	case "execution_cached":
-		// this is probably not usefull for us
+		s := message.Data.(*MessageDataExecutionCached)
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			m := &PromptMessageCached{
+				PromptID: s.PromptID,
+				Nodes:    make([]int, 0, len(s.Nodes)),
+			}
+			for _, n := range s.Nodes {
+				// node ids are serialized as strings
+				if ns, ok := n.(string); ok {
+					if nid, err := parseNodeID(ns); err == nil {
+						m.Nodes = append(m.Nodes, nid)
+					}
+				}
+			}
+			qi.queue.post(PromptMessage{
+				Type:    "cached",
+				Message: m,
+			})
+		}
 	case "executing":
 		s := message.Data.(*MessageDataExecuting)
 
@@ -243,100 +407,104 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 			} else {
 				stop = true
 			}
-			m := PromptMessage{
-				Type: "stopped",
-				Message: &PromptMessageStopped{
-					PromptID:  s.PromptID, //qi,
-					Exception: nil,
-					Stop:      stop,
-				},
-			}
 			// remove the Item from our Queue before sending the message
 			// no other messages will be sent to the channel after this
-			if c.messages != nil {
-				c.messages <- m
-			}
+			c.stopQueuedItem(&PromptMessageStopped{
+				PromptID:  s.PromptID,
+				Exception: nil,
+				Stop:      stop,
+			})
 		} else {
-			//node := qi.Workflow.GetNodeById(*s.Node)
-			m := PromptMessage{
-				Type: "executing",
-				Message: &PromptMessageExecuting{
-					NodeID: *s.Node,
-					Title:  "unknown", //node.DisplayName,
-				},
-			}
-			if c.messages != nil {
-				c.messages <- m
+			qi := c.GetQueuedItem(s.PromptID)
+			if qi != nil {
+				qi.queue.post(PromptMessage{
+					Type: "executing",
+					Message: &PromptMessageExecuting{
+						PromptID: s.PromptID,
+						NodeID:   *s.Node,
+						Title:    qi.nodeTitle(*s.Node),
+					},
+				})
 			}
 		}
 	case "progress":
 		s := message.Data.(*MessageDataProgress)
-		m := PromptMessage{
-			Type: "progress",
-			Message: &PromptMessageProgress{
-				Value: s.Value,
-				Max:   s.Max,
-			},
-		}
-		if c.messages != nil {
-			c.messages <- m
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			m := &PromptMessageProgress{
+				PromptID: s.PromptID,
+				Value:    s.Value,
+				Max:      s.Max,
+			}
+			if s.Node != nil {
+				m.NodeID = *s.Node
+			}
+			qi.queue.post(PromptMessage{
+				Type:    "progress",
+				Message: m,
+			})
 		}
 	case "executed":
 		s := message.Data.(*MessageDataExecuted)
-		// collect the data from the output
-		mdata := &PromptMessageData{
-			NodeID: s.Node,
-			Data:   make(map[string][]DataOutput),
-		}
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			// collect the data from the output
+			mdata := &PromptMessageData{
+				PromptID: s.PromptID,
+				NodeID:   s.Node,
+				Data:     make(map[string][]DataOutput),
+			}
 
-		for k, v := range s.Output {
-			mdata.Data[k] = *v
-		}
-		m := PromptMessage{
-			Type:    "data",
-			Message: mdata,
-		}
-		if c.messages != nil {
-			c.messages <- m
+			for k, v := range s.Output {
+				mdata.Data[k] = *v
+			}
+			qi.queue.post(PromptMessage{
+				Type:    "data",
+				Message: mdata,
+			})
 		}
 	case "execution_interrupted":
 		s := message.Data.(*MessageExecutionInterrupted)
-		m := PromptMessage{
-			Type: "stopped",
-			Message: &PromptMessageStopped{
-				PromptID:  s.PromptID, //qi,
-				Exception: nil,
-				Stop:      true,
-			},
-		}
 		// remove the Item from our Queue before sending the message
 		// no other messages will be sent to the channel after this
-		if c.messages != nil {
-			c.messages <- m
-		}
+		c.stopQueuedItem(&PromptMessageStopped{
+			PromptID:    s.PromptID,
+			Exception:   nil,
+			Interrupted: true,
+			Stop:        true,
+		})
 	case "execution_error":
 		s := message.Data.(*MessageExecutionError)
-		nindex, _ := strconv.Atoi(s.Node) // the node id is serialized as a string
-		m := PromptMessage{
-			Type: "stopped",
-			Message: &PromptMessageStopped{
-				PromptID: s.PromptID,
-				Exception: &PromptMessageStoppedException{
-					NodeID:           nindex,
-					NodeType:         s.NodeType,
-					NodeName:         "unknonw",
-					ExceptionMessage: s.ExceptionMessage,
-					ExceptionType:    s.ExceptionType,
-					Traceback:        s.Traceback,
-				},
-				Stop: true,
-			},
+		nindex, _ := parseNodeID(s.Node) // the node id is serialized as a string
+		executed := make([]int, 0, len(s.Executed))
+		for _, e := range s.Executed {
+			if eid, err := parseNodeID(e); err == nil {
+				executed = append(executed, eid)
+			}
 		}
-		if c.messages != nil {
-			c.messages <- m
+		nodeName := "unknown"
+		if qi := c.GetQueuedItem(s.PromptID); qi != nil {
+			if title := qi.nodeTitle(nindex); title != "" {
+				nodeName = title
+			}
 		}
+		c.stopQueuedItem(&PromptMessageStopped{
+			PromptID: s.PromptID,
+			Exception: &PromptMessageStoppedException{
+				NodeID:           nindex,
+				NodeType:         s.NodeType,
+				NodeName:         nodeName,
+				ExceptionMessage: s.ExceptionMessage,
+				ExceptionType:    s.ExceptionType,
+				Traceback:        s.Traceback,
+				Executed:         executed,
+				CurrentInputs:    s.CurrentInputs,
+				CurrentOutputs:   s.CurrentOutputs,
+			},
+			Stop: true,
+		})
 	default:
 		// Handle unknown data types or return a dedicated error here
-		logger.Warn("Unhandled message type: %s", message.Type)
+		logger.Warnf("Unhandled message type: %s", message.Type)
 	}
 }
@@ -0,0 +1,476 @@
+package comfy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/er1cw00/comfy.go/base/logger"
+)
+
+// ClientPool owns a ComfyClient for each of several ComfyUI servers and dispatches prompts
+// to the least loaded server that has every node type the graph requires.  When a server
+// disconnects while executing a prompt, the prompt is queued again on another server.
+type ClientPool struct {
+	members       []*poolMember
+	mutex         sync.Mutex // guards the state of the members
+	statsInterval time.Duration
+	ctx           context.Context
+	cancel        context.CancelFunc
+	finished      chan struct{}
+}
+
+type poolMember struct {
+	client       *ComfyClient
+	connected    bool
+	nodeObjects  *NodeObjects
+	vramFree     int64
+	active       int           // prompts dispatched to this member that have not stopped
+	disconnected chan struct{} // closed when the websocket disconnects, replaced on connect
+	abandoned    []string      // prompts queued again elsewhere, cancelled when the member reconnects
+}
+
+// PoolItem is a prompt dispatched by a ClientPool.  Messages for the prompt are delivered on the
+// Messages channel regardless of which server executes it, and the channel is closed once the prompt
+// has stopped, when it could not be dispatched again after a server failed, or when it was released.
+type PoolItem struct {
+	Graph    *Graph
+	Messages chan PromptMessage
+	mutex    sync.Mutex
+	client   *ComfyClient
+	item     *QueueItem
+	err      error
+	queue    *messageQueue
+	released bool
+}
+
+// NewClientPool creates a pool with a ComfyClient for each of the server addresses
+func NewClientPool(baseAddrs ...string) *ClientPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &ClientPool{
+		members:       make([]*poolMember, 0, len(baseAddrs)),
+		statsInterval: 10 * time.Second,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	for _, addr := range baseAddrs {
+		m := &poolMember{
+			disconnected: make(chan struct{}),
+		}
+		// a member starts out disconnected
+		close(m.disconnected)
+		callbacks := &ComfyClientCallbacks{
+			WebsocketConnected: func(*ComfyClient) {
+				p.onConnected(m)
+			},
+			WebsocketDisconnected: func(*ComfyClient) {
+				p.onDisconnected(m)
+			},
+		}
+		m.client = NewComfyClient(addr, callbacks)
+		p.members = append(p.members, m)
+	}
+	return p
+}
+
+// SetStatsInterval sets how often the system stats of the servers are refreshed. Call before Start.
+func (p *ClientPool) SetStatsInterval(d time.Duration) {
+	p.statsInterval = d
+}
+
+// Clients returns the clients owned by the pool
+func (p *ClientPool) Clients() []*ComfyClient {
+	retv := make([]*ComfyClient, 0, len(p.members))
+	for _, m := range p.members {
+		retv = append(retv, m.client)
+	}
+	return retv
+}
+
+// Start connects every client of the pool and starts monitoring the servers
+func (p *ClientPool) Start() {
+	if p.finished != nil {
+		return
+	}
+	for _, m := range p.members {
+		m.client.Start()
+	}
+	p.finished = make(chan struct{})
+	go p.statsLoop()
+}
+
+// Close stops monitoring the servers and closes every client of the pool
+func (p *ClientPool) Close(ctx context.Context) error {
+	p.cancel()
+	if p.finished != nil {
+		select {
+		case <-p.finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	var retv error
+	for _, m := range p.members {
+		if err := m.client.Close(ctx); err != nil {
+			retv = err
+		}
+	}
+	return retv
+}
+
+// IsInitialized returns true when at least one client of the pool is ready to queue prompts
+func (p *ClientPool) IsInitialized() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, m := range p.members {
+		if m.connected && m.nodeObjects != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeObjects returns the node objects of the first ready client, for loading graphs
+func (p *ClientPool) NodeObjects() *NodeObjects {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, m := range p.members {
+		if m.connected && m.nodeObjects != nil {
+			return m.nodeObjects
+		}
+	}
+	return nil
+}
+
+func (p *ClientPool) onConnected(m *poolMember) {
+	if err := m.client.QueryNodeObjects(); err != nil {
+		logger.Errorf("query node objects of %s fail, err: %v", m.client.BaseAddr(), err)
+	}
+	p.mutex.Lock()
+	m.nodeObjects = m.client.NodeObjects()
+	if !m.connected {
+		m.connected = true
+		m.disconnected = make(chan struct{})
+	}
+	abandoned := m.abandoned
+	m.abandoned = nil
+	p.mutex.Unlock()
+	go p.refreshStats(m)
+
+	// the prompts that were queued again elsewhere must not be executed twice
+	for _, id := range abandoned {
+		if _, err := m.client.CancelPrompt(p.ctx, id); err != nil {
+			logger.Warnf("cancel prompt %s on %s fail, err: %v", id, m.client.BaseAddr(), err)
+		}
+	}
+}
+
+func (p *ClientPool) onDisconnected(m *poolMember) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if m.connected {
+		m.connected = false
+		close(m.disconnected)
+	}
+}
+
+func (p *ClientPool) statsLoop() {
+	defer close(p.finished)
+	ticker := time.NewTicker(p.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, m := range p.members {
+				p.refreshStats(m)
+			}
+		}
+	}
+}
+
+func (p *ClientPool) refreshStats(m *poolMember) {
+	if !m.client.IsConnected() {
+		return
+	}
+	stats, err := m.client.GetSystemStatsContext(p.ctx)
+	if err != nil {
+		logger.Warnf("get system stats of %s fail, err: %v", m.client.BaseAddr(), err)
+		return
+	}
+	// use the device with the most free memory
+	var free int64
+	for _, d := range stats.Devices {
+		if d.VRAM_Free > free {
+			free = d.VRAM_Free
+		}
+	}
+	p.mutex.Lock()
+	m.vramFree = free
+	p.mutex.Unlock()
+}
+
+// requiredNodeTypes returns the node types a server needs to execute the graph
+func requiredNodeTypes(graph *Graph) []string {
+	retv := make([]string, 0)
+	for _, n := range graph.Nodes {
+		if n.IsVirtual() || n.IsMuted() || n.IsBypassed() {
+			continue
+		}
+		if n.IsComposite() {
+			// group nodes and subgraphs are replaced by their inner nodes
+			for _, inner := range requiredNodeTypes(n.InnerGraph) {
+				if !containsString(&retv, inner) {
+					retv = append(retv, inner)
+				}
+			}
+			continue
+		}
+		if !containsString(&retv, n.Type) {
+			retv = append(retv, n.Type)
+		}
+	}
+	return retv
+}
+
+// selectMember picks the connected member with the lowest load that supports every required node type.
+// Must be called with the mutex held.
+func (p *ClientPool) selectMember(required []string, exclude map[*poolMember]bool) (*poolMember, error) {
+	var best *poolMember
+	bestLoad := 0
+	capable := false
+	for _, m := range p.members {
+		if !m.connected || m.nodeObjects == nil || exclude[m] {
+			continue
+		}
+		supported := true
+		for _, t := range required {
+			if m.nodeObjects.GetNodeObjectByName(t) == nil {
+				supported = false
+				break
+			}
+		}
+		if !supported {
+			continue
+		}
+		capable = true
+
+		// the queue count reported by the server lags behind our own dispatches
+		load := m.client.QueueCount()
+		if m.active > load {
+			load = m.active
+		}
+		if best == nil || load < bestLoad || (load == bestLoad && m.vramFree > best.vramFree) {
+			best = m
+			bestLoad = load
+		}
+	}
+	if !capable {
+		for _, m := range p.members {
+			if m.connected && m.nodeObjects != nil && !exclude[m] {
+				return nil, ErrNoCapableClient
+			}
+		}
+		return nil, ErrNoClientAvailable
+	}
+	return best, nil
+}
+
+// dispatch queues a prompt requiring the node types on the best member not in exclude, trying the
+// next best member when queueing fails for reasons other than the prompt being rejected
+func (p *ClientPool) dispatch(ctx context.Context, required []string, exclude map[*poolMember]bool,
+	queue func(c *ComfyClient) (*QueueItem, error)) (*poolMember, *QueueItem, error) {
+	for {
+		p.mutex.Lock()
+		m, err := p.selectMember(required, exclude)
+		if err != nil {
+			p.mutex.Unlock()
+			return nil, nil, err
+		}
+		m.active++
+		p.mutex.Unlock()
+
+		item, err := queue(m.client)
+		if item != nil {
+			// the graph may have been queued with some outputs failing validation
+			return m, item, err
+		}
+		p.release(m)
+
+		var verr *PromptValidationError
+		if errors.As(err, &verr) || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		logger.Warnf("queue prompt on %s fail, err: %v", m.client.BaseAddr(), err)
+		exclude[m] = true
+	}
+}
+
+func (p *ClientPool) release(m *poolMember) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	m.active--
+}
+
+// QueuePrompt queues the graph on the least loaded server that supports it
+func (p *ClientPool) QueuePrompt(graph *Graph) (*PoolItem, error) {
+	return p.QueuePromptContext(context.Background(), graph)
+}
+
+func (p *ClientPool) QueuePromptContext(ctx context.Context, graph *Graph) (*PoolItem, error) {
+	m, item, err := p.dispatch(ctx, requiredNodeTypes(graph), make(map[*poolMember]bool), func(c *ComfyClient) (*QueueItem, error) {
+		return c.QueuePromptContext(ctx, graph)
+	})
+	if item == nil {
+		return nil, err
+	}
+
+	pi := &PoolItem{
+		Graph:    graph,
+		Messages: make(chan PromptMessage, queueItemMessageBuffer),
+		client:   m.client,
+		item:     item,
+	}
+	pi.queue = newMessageQueue(pi.Messages)
+	go p.forward(pi, m, item)
+	return pi, err
+}
+
+// Run queues the graph on the pool and waits for it to finish, see ComfyClient.Run
+func (p *ClientPool) Run(ctx context.Context, graph *Graph) (*RunResult, error) {
+	pi, err := p.QueuePromptContext(ctx, graph)
+	if pi == nil {
+		return nil, err
+	}
+	retv, werr := pi.Wait(ctx)
+	if werr != nil {
+		return retv, werr
+	}
+	return retv, err
+}
+
+// forward relays the messages of the queued item to the PoolItem, queueing the prompt again
+// on another server when the member executing it disconnects
+func (p *ClientPool) forward(pi *PoolItem, m *poolMember, item *QueueItem) {
+	exclude := make(map[*poolMember]bool)
+	for {
+		p.mutex.Lock()
+		disconnected := m.disconnected
+		p.mutex.Unlock()
+
+		failed := false
+		select {
+		case msg, ok := <-item.Messages:
+			if !ok {
+				if pi.isReleased() {
+					p.release(m)
+					return
+				}
+				// the client was closed
+				failed = true
+				break
+			}
+			pi.queue.post(msg)
+			if msg.Type == "stopped" {
+				p.release(m)
+				pi.queue.close()
+				return
+			}
+		case <-disconnected:
+			failed = true
+		}
+		if !failed {
+			continue
+		}
+
+		// stop listening to the failed server, the prompt will be executed elsewhere and
+		// is cancelled on the failed server once it is back
+		item.Release()
+		p.mutex.Lock()
+		m.active--
+		m.abandoned = append(m.abandoned, item.PromptID)
+		p.mutex.Unlock()
+		exclude[m] = true
+		logger.Warnf("%s disconnected while executing prompt %s, queueing on another server", m.client.BaseAddr(), item.PromptID)
+
+		// the graph may have changed since, e.g. its seeds, the prompt is queued as it was sent
+		old := item
+		newm, newitem, err := p.dispatch(p.ctx, requiredNodeTypes(pi.Graph), exclude, func(c *ComfyClient) (*QueueItem, error) {
+			return c.requeuePrompt(p.ctx, old, pi.Graph)
+		})
+		if newitem == nil {
+			pi.mutex.Lock()
+			pi.err = err
+			pi.mutex.Unlock()
+			pi.queue.close()
+			return
+		}
+		pi.mutex.Lock()
+		released := pi.released
+		if !released {
+			pi.client = newm.client
+			pi.item = newitem
+		}
+		pi.mutex.Unlock()
+		if released {
+			newitem.Release()
+			p.release(newm)
+			return
+		}
+		m, item = newm, newitem
+	}
+}
+
+// Client returns the client of the server the prompt is currently queued on
+func (pi *PoolItem) Client() *ComfyClient {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	return pi.client
+}
+
+// PromptID returns the id of the prompt on the server it is currently queued on
+func (pi *PoolItem) PromptID() string {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	return pi.item.PromptID
+}
+
+// Err returns the error that prevented the prompt from being queued again after a server failed
+func (pi *PoolItem) Err() error {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	return pi.err
+}
+
+// Wait consumes the messages of the PoolItem until the prompt stops, see ComfyClient.Run.  When Wait
+// returns before the prompt stopped, the PoolItem is released.
+func (pi *PoolItem) Wait(ctx context.Context) (*RunResult, error) {
+	retv, err := waitForPrompt(ctx, pi.PromptID(), pi.Messages, pi.Graph)
+	if err == ErrComfyClosed {
+		if perr := pi.Err(); perr != nil {
+			err = perr
+		}
+	}
+	if err != nil {
+		pi.Release()
+	}
+	return retv, err
+}
+
+// Release stops delivering the messages of the prompt and closes the Messages channel, see QueueItem.Release
+func (pi *PoolItem) Release() {
+	pi.mutex.Lock()
+	pi.released = true
+	item := pi.item
+	pi.mutex.Unlock()
+	pi.queue.discard()
+	item.Release()
+}
+
+func (pi *PoolItem) isReleased() bool {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	return pi.released
+}
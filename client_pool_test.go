@@ -0,0 +1,207 @@
+package comfy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// newTestPool returns a started pool of clients of the servers, once every client is ready
+// and its system stats are known
+func newTestPool(t *testing.T, servers ...*comfytest.Server) *ClientPool {
+	t.Helper()
+	addrs := make([]string, 0, len(servers))
+	for _, s := range servers {
+		addrs = append(addrs, s.Addr)
+	}
+	p := NewClientPool(addrs...)
+	p.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Close(ctx)
+	})
+	waitFor(t, "pool members to be ready", func() bool {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		for _, m := range p.members {
+			if !m.connected || m.nodeObjects == nil || m.vramFree == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	return p
+}
+
+// waitFor polls the condition until it holds, failing the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// objectInfoWithout returns the default object info of comfytest without the node types
+func objectInfoWithout(t *testing.T, nodeTypes ...string) string {
+	t.Helper()
+	var info map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(comfytest.DefaultObjectInfo), &info); err != nil {
+		t.Fatal(err)
+	}
+	for _, nt := range nodeTypes {
+		delete(info, nt)
+	}
+	data, _ := json.Marshal(info)
+	return string(data)
+}
+
+func TestClientPoolRun(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+	p := newTestPool(t, a, b)
+	graph := loadTestGraph(t, p.NodeObjects(), "txt2img.json")
+
+	for i := 0; i < 2; i++ {
+		result, err := p.Run(testContext(t), graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.GetOutputs(9, "images")) != 1 {
+			t.Errorf("outputs %v, want one image", result.Outputs)
+		}
+	}
+	if n := len(a.Prompts()) + len(b.Prompts()); n != 2 {
+		t.Errorf("servers received %d prompts, want 2", n)
+	}
+}
+
+func TestClientPoolRoutesByNodeTypes(t *testing.T) {
+	a := newTestServer(t)
+	a.SetVRAMFree(int64(40) << 30)
+	if err := a.SetObjectInfo(objectInfoWithout(t, "SaveImage")); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestServer(t)
+	p := newTestPool(t, a, b)
+	graph := loadTestGraph(t, p.Clients()[1].NodeObjects(), "txt2img.json")
+
+	pi, err := p.QueuePromptContext(testContext(t), graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Client() != p.Clients()[1] {
+		t.Error("the prompt was not queued on the only server with every node type")
+	}
+	if _, err := pi.Wait(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	only := newTestPool(t, a)
+	if _, err := only.Run(testContext(t), graph); err != ErrNoCapableClient {
+		t.Errorf("err = %v, want %v", err, ErrNoCapableClient)
+	}
+}
+
+func TestClientPoolFailover(t *testing.T) {
+	a := newTestServer(t)
+	a.SetVRAMFree(int64(40) << 30)
+	a.SetScript(slowScript(2 * time.Second))
+	b := newTestServer(t)
+	p := newTestPool(t, a, b)
+	graph := loadTestGraph(t, p.NodeObjects(), "txt2img.json")
+	seed := graph.GetNodeById(3).GetPropertyWithName("seed")
+
+	pi, err := p.QueuePromptContext(testContext(t), graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Client() != p.Clients()[0] {
+		t.Fatal("the prompt was not queued on the server with the most free VRAM")
+	}
+	// the seed of the graph is randomized once queued
+	randomized := seed.GetValue()
+	waitFor(t, "the prompt to run", func() bool {
+		return len(a.Prompts()) == 1
+	})
+	a.DisconnectClients()
+
+	result, err := pi.Wait(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Client() != p.Clients()[1] || len(b.Prompts()) != 1 {
+		t.Fatal("the prompt was not queued on the other server")
+	}
+	if result.PromptID != b.Prompts()[0].ID || len(result.GetOutputs(9, "images")) != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	sent, resent := a.Prompts()[0].Nodes["3"].Inputs["seed"], b.Prompts()[0].Nodes["3"].Inputs["seed"]
+	if sent != resent {
+		t.Errorf("the prompt was queued again with seed %v, it was sent with %v", resent, sent)
+	}
+	if seed.GetValue() != randomized {
+		t.Error("the seed of the graph changed when the prompt was queued again")
+	}
+
+	// the prompt still running on the first server is cancelled once it is back
+	waitFor(t, "the abandoned prompt to be interrupted", func() bool {
+		return a.Interrupts() == 1
+	})
+}
+
+func TestClientPoolUnreadItemDoesNotBlock(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(func(p *comfytest.Prompt) []comfytest.Event {
+		events := make([]comfytest.Event, 0)
+		for i := 0; i < 4*queueItemMessageBuffer; i++ {
+			events = append(events, comfytest.Executing("3"))
+		}
+		return append(events, comfytest.Executing("9"), comfytest.ExecutedImages("9", "out.png"))
+	})
+	p := newTestPool(t, s)
+	graph := loadTestGraph(t, p.NodeObjects(), "txt2img.json")
+
+	unread, err := p.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := p.Run(ctx, graph); err != nil {
+		t.Fatalf("running a prompt after an unread one: %v", err)
+	}
+	messages := drain(t, unread.Messages)
+	if n := len(messages); n < 4*queueItemMessageBuffer || messages[n-1].Type != "stopped" {
+		t.Errorf("the unread item received %d messages, the last one not stopped", n)
+	}
+}
+
+func TestPoolItemRelease(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(slowScript(200 * time.Millisecond))
+	p := newTestPool(t, s)
+	graph := loadTestGraph(t, p.NodeObjects(), "txt2img.json")
+
+	pi, err := p.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi.Release()
+	drain(t, pi.Messages)
+	if p.Clients()[0].GetQueuedItem(pi.PromptID()) != nil {
+		t.Error("the released item is still queued on the client")
+	}
+	waitFor(t, "the member to be released", func() bool {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		return p.members[0].active == 0
+	})
+}
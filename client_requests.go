@@ -1,10 +1,13 @@
 package comfy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"sort"
@@ -35,17 +38,68 @@ import (
 @routes.post("/upload/mask")
 */
 
+// doRequest performs an HTTP request against the ComfyUI server and returns the body of the response
+// along with its status code.  The response body is always fully read and closed.
+func (c *ComfyClient) doRequest(ctx context.Context, method string, path string, contentType string, body io.Reader) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s%s", c.baseAddr, path), body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return data, resp.StatusCode, nil
+}
+
+// httpGet performs a GET request and fails on any non 2xx status
+func (c *ComfyClient) httpGet(ctx context.Context, path string) ([]byte, error) {
+	body, status, err := c.doRequest(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		return nil, fmt.Errorf("GET %s failed with status %d", path, status)
+	}
+	return body, nil
+}
+
+// httpPostJSON performs a POST request with a JSON body and fails on any non 2xx status
+func (c *ComfyClient) httpPostJSON(ctx context.Context, path string, data string) ([]byte, error) {
+	body, status, err := c.doRequest(ctx, http.MethodPost, path, "application/json", strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		return nil, fmt.Errorf("POST %s failed with status %d", path, status)
+	}
+	return body, nil
+}
+
 func (c *ComfyClient) GetSystemStats() (*SystemStats, error) {
+	return c.GetSystemStatsContext(context.Background())
+}
+
+func (c *ComfyClient) GetSystemStatsContext(ctx context.Context) (*SystemStats, error) {
 	if !c.websocket.isConnected {
 		return nil, ErrComfyDisconnected
 	}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s/system_stats", c.baseAddr))
+	body, err := c.httpGet(ctx, "/system_stats")
 	if err != nil {
 		return nil, err
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	retv := &SystemStats{}
 	err = json.Unmarshal(body, &retv)
 	if err != nil {
@@ -56,7 +110,11 @@ func (c *ComfyClient) GetSystemStats() (*SystemStats, error) {
 }
 
 func (c *ComfyClient) GetPromptHistoryByIndex() ([]PromptHistoryItem, error) {
-	history, err := c.GetPromptHistoryByID()
+	return c.GetPromptHistoryByIndexContext(context.Background())
+}
+
+func (c *ComfyClient) GetPromptHistoryByIndexContext(ctx context.Context) ([]PromptHistoryItem, error) {
+	history, err := c.GetPromptHistoryByIDContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +137,11 @@ func (c *ComfyClient) GetPromptHistoryByIndex() ([]PromptHistoryItem, error) {
 }
 
 func (c *ComfyClient) GetPromptHistoryByID() (map[string]PromptHistoryItem, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/history", c.baseAddr))
+	return c.GetPromptHistoryByIDContext(context.Background())
+}
+
+func (c *ComfyClient) GetPromptHistoryByIDContext(ctx context.Context) (map[string]PromptHistoryItem, error) {
+	body, err := c.httpGet(ctx, "/history")
 	if err != nil {
 		return nil, err
 	}
@@ -103,8 +165,7 @@ func (c *ComfyClient) GetPromptHistoryByID() (map[string]PromptHistoryItem, erro
 		Outputs map[string]internalOutputs `json:"outputs"`
 	}
 
-	// read in the body, and deserialize to our temp internalPromptHistoryItem type
-	body, _ := io.ReadAll(resp.Body)
+	// deserialize the body to our temp internalPromptHistoryItem type
 	history := make(map[string]internalPromptHistoryItem)
 	err = json.Unmarshal(body, &history)
 	if err != nil {
@@ -140,8 +201,10 @@ func (c *ComfyClient) GetPromptHistoryByID() (map[string]PromptHistoryItem, erro
 
 		// rebuild the images output map
 		for k, o := range ph.Outputs {
-			oid, _ := strconv.Atoi(k)
-			item.Outputs[oid] = *o.Images
+			oid, _ := parseNodeID(k)
+			if o.Images != nil {
+				item.Outputs[oid] = append(item.Outputs[oid], *o.Images...)
+			}
 		}
 		ret[k] = *item
 	}
@@ -164,39 +227,47 @@ func (c *ComfyClient) GetPromptHistoryByID() (map[string]PromptHistoryItem, erro
 // onnx
 // fonts
 func (c *ComfyClient) GetViewMetadata(folder string, file string) (string, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/view_metadata/%s?filename=%s", c.baseAddr, folder, file))
+	return c.GetViewMetadataContext(context.Background(), folder, file)
+}
+
+func (c *ComfyClient) GetViewMetadataContext(ctx context.Context, folder string, file string) (string, error) {
+	params := url.Values{}
+	params.Add("filename", file)
+	body, err := c.httpGet(ctx, fmt.Sprintf("/view_metadata/%s?%s", url.PathEscape(folder), params.Encode()))
 	if err != nil {
 		return "", err
 	}
-
-	body, _ := io.ReadAll(resp.Body)
 	return string(body), nil
 }
 
 // GetImage
 func (c *ComfyClient) GetImage(image_data DataOutput) (*[]byte, error) {
+	return c.GetImageContext(context.Background(), image_data)
+}
+
+func (c *ComfyClient) GetImageContext(ctx context.Context, image_data DataOutput) (*[]byte, error) {
 	params := url.Values{}
 	params.Add("filename", image_data.Filename)
 	params.Add("subfolder", image_data.Subfolder)
 	params.Add("type", image_data.Type)
-	resp, err := http.Get(fmt.Sprintf("http://%s/view?%s", c.baseAddr, params.Encode()))
-
+	body, err := c.httpGet(ctx, "/view?"+params.Encode())
 	if err != nil {
 		return nil, err
 	}
-
-	body, _ := io.ReadAll(resp.Body)
 	return &body, nil
 }
 
 // GetEmbeddings retrieves the list of Embeddings models installed on the ComfyUI server.
 func (c *ComfyClient) GetEmbeddings() ([]string, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/embeddings", c.baseAddr))
+	return c.GetEmbeddingsContext(context.Background())
+}
+
+func (c *ComfyClient) GetEmbeddingsContext(ctx context.Context) ([]string, error) {
+	body, err := c.httpGet(ctx, "/embeddings")
 	if err != nil {
 		return nil, err
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	retv := make([]string, 0)
 	err = json.Unmarshal(body, &retv)
 	if err != nil {
@@ -207,12 +278,15 @@ func (c *ComfyClient) GetEmbeddings() ([]string, error) {
 }
 
 func (c *ComfyClient) GetQueueExecutionInfo() (*QueueExecInfo, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/prompt", c.baseAddr))
+	return c.GetQueueExecutionInfoContext(context.Background())
+}
+
+func (c *ComfyClient) GetQueueExecutionInfoContext(ctx context.Context) (*QueueExecInfo, error) {
+	body, err := c.httpGet(ctx, "/prompt")
 	if err != nil {
 		return nil, err
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	queue_exec := &QueueExecInfo{}
 	err = json.Unmarshal(body, &queue_exec)
 	if err != nil {
@@ -224,12 +298,15 @@ func (c *ComfyClient) GetQueueExecutionInfo() (*QueueExecInfo, error) {
 
 // GetExtensions retrieves the list of extensions installed on the ComfyUI server.
 func (c *ComfyClient) GetExtensions() ([]string, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/extensions", c.baseAddr))
+	return c.GetExtensionsContext(context.Background())
+}
+
+func (c *ComfyClient) GetExtensionsContext(ctx context.Context) ([]string, error) {
+	body, err := c.httpGet(ctx, "/extensions")
 	if err != nil {
 		return nil, err
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	retv := make([]string, 0)
 	err = json.Unmarshal(body, &retv)
 	if err != nil {
@@ -240,13 +317,15 @@ func (c *ComfyClient) GetExtensions() ([]string, error) {
 }
 
 func (c *ComfyClient) GetObjectInfos() (*NodeObjects, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/object_info", c.baseAddr))
+	return c.GetObjectInfosContext(context.Background())
+}
 
+func (c *ComfyClient) GetObjectInfosContext(ctx context.Context) (*NodeObjects, error) {
+	body, err := c.httpGet(ctx, "/object_info")
 	if err != nil {
 		return nil, err
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	result := &NodeObjects{}
 	err = json.Unmarshal(body, &result.Objects)
 	if err != nil {
@@ -257,7 +336,15 @@ func (c *ComfyClient) GetObjectInfos() (*NodeObjects, error) {
 	return result, nil
 }
 
+// QueuePrompt queues the graph for execution.  When ComfyUI rejects the graph, the returned error is
+// a *PromptValidationError.  If the graph was queued but some of its outputs failed validation, both the
+// QueueItem and a *PromptValidationError are returned.  Once queued, the seeds of the graph are changed
+// according to their control_after_generate, see SetSeedControl.
 func (c *ComfyClient) QueuePrompt(graph *Graph) (*QueueItem, error) {
+	return c.QueuePromptContext(context.Background(), graph)
+}
+
+func (c *ComfyClient) QueuePromptContext(ctx context.Context, graph *Graph) (*QueueItem, error) {
 	if !c.websocket.isConnected {
 		return nil, ErrComfyDisconnected
 	}
@@ -266,81 +353,370 @@ func (c *ComfyClient) QueuePrompt(graph *Graph) (*QueueItem, error) {
 	if err != nil {
 		return nil, err
 	}
+	item, err := c.queuePrompt(ctx, &prompt, graph)
+	// like the frontend, the seeds change after generating so that queueing the graph again is not cached
+	if item != nil && !c.seedControlDisabled {
+		if serr := graph.ApplySeedControl(c.seedSource); serr != nil {
+			logger.Warnf("Cannot apply control_after_generate: %v", serr)
+		}
+	}
+	return item, err
+}
 
-	// prevent a race where the ws may provide messages about a queued item before
-	// we add the item to our internal map
-	c.websocket.LockRead()
-	defer c.websocket.UnlockRead()
+// QueueAPIPrompt queues a prompt loaded from "API format" JSON.  The prompt is queued as it is.  When it
+// can be converted to a graph, the graph is used as the workflow of the queued item and is saved with the
+// generated images, otherwise the prompt keeps the workflow it was loaded with, if any.
+func (c *ComfyClient) QueueAPIPrompt(prompt *Prompt) (*QueueItem, error) {
+	return c.QueueAPIPromptContext(context.Background(), prompt)
+}
 
-	data, _ := json.Marshal(prompt)
-	resp, err := http.Post(fmt.Sprintf("http://%s/prompt", c.baseAddr), "application/json", strings.NewReader(string(data)))
+func (c *ComfyClient) QueueAPIPromptContext(ctx context.Context, prompt *Prompt) (*QueueItem, error) {
+	if !c.websocket.isConnected {
+		return nil, ErrComfyDisconnected
+	}
+	if prompt.nodeObjects == nil {
+		if c.nodeObjects == nil {
+			return nil, ErrNotNodeObjects
+		}
+		prompt.CreateNodeProperties(c.nodeObjects)
+	}
 
+	queued := *prompt
+	queued.ClientID = c.clientId
+	graph, err := prompt.ToGraph()
 	if err != nil {
-		return nil, err
+		// prompts using inner nodes, or values the graph cannot hold, are still queued
+		logger.Warnf("prompt is queued without a converted workflow, converting it to a graph failed: %v", err)
+		graph = nil
+	} else {
+		queued.ExtraData.PngInfo.Workflow = graph
 	}
+	return c.queuePrompt(ctx, &queued, graph)
+}
 
-	body, _ := io.ReadAll(resp.Body)
+func (c *ComfyClient) queuePrompt(ctx context.Context, prompt *Prompt, graph *Graph) (*QueueItem, error) {
+	data, _ := json.Marshal(prompt)
+	return c.postPrompt(ctx, data, graph, prompt.DynamicPrompts)
+}
 
-	// create the queue item
-	item := &QueueItem{
-		Workflow: graph,
+// requeuePrompt queues the prompt of a QueueItem of another client again, exactly as it was sent
+func (c *ComfyClient) requeuePrompt(ctx context.Context, item *QueueItem, graph *Graph) (*QueueItem, error) {
+	if !c.websocket.isConnected {
+		return nil, ErrComfyDisconnected
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(item.sent, &fields); err != nil {
+		return nil, err
 	}
+	fields["client_id"], _ = json.Marshal(c.clientId)
+	data, _ := json.Marshal(fields)
+	return c.postPrompt(ctx, data, graph, item.DynamicPrompts)
+}
 
-	err = json.Unmarshal(body, &item)
+// postPrompt POSTs the JSON of a prompt to /prompt and creates its QueueItem
+func (c *ComfyClient) postPrompt(ctx context.Context, data []byte, graph *Graph, dynamicPrompts []DynamicPrompt) (*QueueItem, error) {
+	// prevent a race where the ws may provide messages about a queued item before
+	// we add the item to our internal map
+	c.websocket.LockRead()
+	defer c.websocket.UnlockRead()
+
+	body, status, err := c.doRequest(ctx, http.MethodPost, "/prompt", "application/json", strings.NewReader(string(data)))
 	if err != nil {
+		return nil, err
+	}
+
+	if status < 200 || status > 299 {
 		// mmm-k, is it one of these:
-		// {"error": {"type": "prompt_no_outputs",
-		//				"message": "Prompt has no outputs",
+		// {"error": {"type": "prompt_outputs_failed_validation",
+		//				"message": "Prompt outputs failed validation",
 		//				"details": "",
 		//				"extra_info": {}
 		//			  },
-		// "node_errors": []
+		// "node_errors": {"3": {"errors": [...], "dependent_outputs": ["9"], "class_type": "KSampler"}}
 		// }
 		perror := &PromptErrorMessage{}
 		perr := json.Unmarshal(body, &perror)
 		if perr != nil {
-			// return the original error
 			logger.Errorf("error unmarshalling prompt error body: %s", string(body))
-			return nil, err
-		} else {
-			return nil, errors.New(perror.Error.Message)
+			return nil, fmt.Errorf("POST /prompt failed with status %d", status)
+		}
+		return nil, newPromptValidationError(perror, graph)
+	}
+
+	// create the queue item
+	item := &QueueItem{
+		Workflow:       graph,
+		Messages:       make(chan PromptMessage, queueItemMessageBuffer),
+		DynamicPrompts: dynamicPrompts,
+		sent:           data,
+	}
+
+	err = json.Unmarshal(body, &item)
+	if err != nil {
+		return nil, err
+	}
+	item.client = c
+	item.queue = newMessageQueue(item.Messages)
+	c.addQueuedItem(item)
+
+	if len(item.NodeErrors) != 0 {
+		// the prompt was queued, but the outputs depending on these nodes
+		// failed validation and will not be executed
+		perror := &PromptErrorMessage{}
+		if perr := json.Unmarshal(body, &perror); perr == nil {
+			return item, newPromptValidationError(perror, graph)
 		}
 	}
-	//c.messages = make(chan PromptMessage)
-	//c.queueditems[item.PromptID] = item
 	return item, nil
 }
 
-func (c *ComfyClient) Interrupt() error {
-	resp, err := http.Post(fmt.Sprintf("http://%s/interrupt", c.baseAddr), "application/json", strings.NewReader("{}"))
+// GetQueue retrieves the running and pending prompts of the ComfyUI queue
+func (c *ComfyClient) GetQueue() (*Queue, error) {
+	return c.GetQueueContext(context.Background())
+}
+
+func (c *ComfyClient) GetQueueContext(ctx context.Context) (*Queue, error) {
+	body, err := c.httpGet(ctx, "/queue")
+	if err != nil {
+		return nil, err
+	}
+
+	var queue struct {
+		Running []QueueEntry `json:"queue_running"`
+		Pending []QueueEntry `json:"queue_pending"`
+	}
+	err = json.Unmarshal(body, &queue)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	io.ReadAll(resp.Body)
-	return nil
+	// the pending queue is a heap on the server, order it by the prompt numbers
+	sort.Slice(queue.Pending, func(i, j int) bool {
+		return queue.Pending[i].Number < queue.Pending[j].Number
+	})
+	return &Queue{
+		Running: queue.Running,
+		Pending: queue.Pending,
+	}, nil
 }
 
-func (c *ComfyClient) EraseHistory() error {
-	// delete post takes an array of IDs. We'll provide a single ID in a json array
-	data := "{\"clear\": \"clear\"}"
-	resp, err := http.Post(fmt.Sprintf("http://%s/history", c.baseAddr), "application/json", strings.NewReader(data))
+// DeleteQueued removes prompts that have not started executing from the queue.
+// Prompts that are running are not affected, use Interrupt to stop them.
+func (c *ComfyClient) DeleteQueued(promptIDs ...string) error {
+	return c.DeleteQueuedContext(context.Background(), promptIDs...)
+}
+
+func (c *ComfyClient) DeleteQueuedContext(ctx context.Context, promptIDs ...string) error {
+	if len(promptIDs) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(map[string]interface{}{"delete": promptIDs})
+	_, err := c.httpPostJSON(ctx, "/queue", string(data))
+	return err
+}
+
+// ClearQueue removes every pending prompt from the queue, including those queued by other clients
+func (c *ComfyClient) ClearQueue() error {
+	return c.ClearQueueContext(context.Background())
+}
+
+func (c *ComfyClient) ClearQueueContext(ctx context.Context) error {
+	_, err := c.httpPostJSON(ctx, "/queue", "{\"clear\": true}")
+	return err
+}
+
+func (c *ComfyClient) Interrupt() error {
+	return c.InterruptContext(context.Background())
+}
+
+func (c *ComfyClient) InterruptContext(ctx context.Context) error {
+	_, err := c.httpPostJSON(ctx, "/interrupt", "{}")
+	return err
+}
+
+// CancelPromptResult describes the action CancelPrompt took
+type CancelPromptResult string
+
+const (
+	CancelPromptNotFound    CancelPromptResult = "not_found"   // the prompt was neither pending nor running
+	CancelPromptDequeued    CancelPromptResult = "dequeued"    // the prompt was removed from the pending queue
+	CancelPromptInterrupted CancelPromptResult = "interrupted" // the prompt was executing and was interrupted
+)
+
+// CancelPrompt stops a single prompt without affecting the work of other clients.  A prompt that has not
+// started is removed from the pending queue.  The server is only interrupted when the prompt is the one
+// currently executing.  If the prompt was queued with this client, its QueueItem receives a "stopped" message.
+func (c *ComfyClient) CancelPrompt(ctx context.Context, promptID string) (CancelPromptResult, error) {
+	queue, err := c.GetQueueContext(ctx)
 	if err != nil {
-		return err
+		return CancelPromptNotFound, err
+	}
+
+	for _, e := range queue.Pending {
+		if e.PromptID == promptID {
+			if err := c.DeleteQueuedContext(ctx, promptID); err != nil {
+				return CancelPromptNotFound, err
+			}
+			// the server will never report on a prompt removed from the queue
+			c.stopQueuedItem(&PromptMessageStopped{
+				PromptID:    promptID,
+				Exception:   nil,
+				Interrupted: true,
+				Stop:        true,
+			})
+			return CancelPromptDequeued, nil
+		}
 	}
 
-	io.ReadAll(resp.Body)
-	return nil
+	running := false
+	for _, e := range queue.Running {
+		if e.PromptID == promptID {
+			running = true
+		}
+	}
+	if !running {
+		// fall back to what the websocket told us about our own prompts
+		c.queueMutex.Lock()
+		_, queued := c.queueditems[promptID]
+		running = queued && c.lastProcessedPromptID == promptID
+		c.queueMutex.Unlock()
+	}
+
+	if running {
+		// servers that support it will only interrupt when prompt_id is executing,
+		// guarding against the prompt finishing before the request arrives
+		data, _ := json.Marshal(map[string]interface{}{"prompt_id": promptID})
+		if _, err := c.httpPostJSON(ctx, "/interrupt", string(data)); err != nil {
+			return CancelPromptNotFound, err
+		}
+		return CancelPromptInterrupted, nil
+	}
+	return CancelPromptNotFound, nil
+}
+
+func (c *ComfyClient) EraseHistory() error {
+	return c.EraseHistoryContext(context.Background())
+}
+
+func (c *ComfyClient) EraseHistoryContext(ctx context.Context) error {
+	data := "{\"clear\": \"clear\"}"
+	_, err := c.httpPostJSON(ctx, "/history", data)
+	return err
 }
 
 func (c *ComfyClient) EraseHistoryItem(promptID string) error {
+	return c.EraseHistoryItemContext(context.Background(), promptID)
+}
+
+func (c *ComfyClient) EraseHistoryItemContext(ctx context.Context, promptID string) error {
 	// delete post takes an array of IDs. We'll provide a single ID in a json array
 	item := fmt.Sprintf("{\"delete\": [\"%s\"]}", promptID)
-	resp, err := http.Post(fmt.Sprintf("http://%s/history", c.baseAddr), "application/json", strings.NewReader(item))
+	_, err := c.httpPostJSON(ctx, "/history", item)
+	return err
+}
+
+// uploadFile posts the data as a multipart form to one of the ComfyUI upload routes
+func (c *ComfyClient) uploadFile(ctx context.Context, path string, r io.Reader, filename string, fields map[string]string) (*DataOutput, error) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	fw, err := mw.CreateFormFile("image", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(fw, r); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if err = mw.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+
+	body, status, err := c.doRequest(ctx, http.MethodPost, path, mw.FormDataContentType(), buf)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		return nil, fmt.Errorf("POST %s failed with status %d", path, status)
+	}
+
+	// {"name": "example (1).png", "subfolder": "", "type": "input"}
+	var result struct {
+		Name      string `json:"name"`
+		Subfolder string `json:"subfolder"`
+		Type      string `json:"type"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &DataOutput{Filename: result.Name, Subfolder: result.Subfolder, Type: result.Type}, nil
+}
+
+// uploadedName returns the name of an uploaded file as the widgets of loader nodes take it, which is
+// prefixed by the subfolder when there is one
+func uploadedName(result *DataOutput) string {
+	if result.Subfolder == "" {
+		return result.Filename
+	}
+	return result.Subfolder + "/" + result.Filename
+}
+
+// UploadImage uploads the image data read from r to the ComfyUI server and returns the name assigned
+// by the server, as "subfolder/name" when subfolder is not empty.  uploadType is one of "input", "temp" or "output" and defaults to "input" when empty.
+// When overwrite is false and a file with the same name exists, the server will rename the upload.
+func (c *ComfyClient) UploadImage(ctx context.Context, r io.Reader, filename string, subfolder string, uploadType string, overwrite bool) (string, error) {
+	fields := map[string]string{
+		"subfolder": subfolder,
+		"type":      uploadType,
+		"overwrite": strconv.FormatBool(overwrite),
+	}
+	result, err := c.uploadFile(ctx, "/upload/image", r, filename, fields)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return uploadedName(result), nil
+}
 
-	io.ReadAll(resp.Body)
-	return nil
+// UploadMask uploads the mask data read from r and applies it as the alpha channel of the
+// previously uploaded image referenced by original.  The name assigned by the server is returned,
+// as "subfolder/name" when subfolder is not empty.
+func (c *ComfyClient) UploadMask(ctx context.Context, r io.Reader, filename string, subfolder string, uploadType string, overwrite bool, original DataOutput) (string, error) {
+	ref, err := json.Marshal(original)
+	if err != nil {
+		return "", err
+	}
+	fields := map[string]string{
+		"subfolder":    subfolder,
+		"type":         uploadType,
+		"overwrite":    strconv.FormatBool(overwrite),
+		"original_ref": string(ref),
+	}
+	result, err := c.uploadFile(ctx, "/upload/mask", r, filename, fields)
+	if err != nil {
+		return "", err
+	}
+	return uploadedName(result), nil
+}
+
+// UploadImageToProperty uploads the image data read from r as an "input" image and binds the uploaded
+// file to the image upload property, e.g. the "choose file to upload" property of a LoadImage node.
+// The value set on the property is returned.
+func (c *ComfyClient) UploadImageToProperty(ctx context.Context, prop Property, r io.Reader, filename string, overwrite bool) (string, error) {
+	if prop == nil {
+		return "", errors.New("no image upload property")
+	}
+	uploader, ok := prop.ToImageUploadProperty()
+	if !ok {
+		return "", fmt.Errorf("property %s is not an image upload property", prop.Name())
+	}
+
+	name, err := c.UploadImage(ctx, r, filename, "", "input", overwrite)
+	if err != nil {
+		return "", err
+	}
+	uploader.SetFilename(name)
+	return name, nil
 }
@@ -0,0 +1,132 @@
+package comfy
+
+import (
+	"context"
+	"time"
+)
+
+// RunTimelineEntry records when a node of a prompt was executing
+type RunTimelineEntry struct {
+	NodeID   int
+	Title    string
+	Started  time.Time
+	Finished time.Time
+}
+
+// RunResult is the collected outcome of a prompt executed with ComfyClient.Run
+type RunResult struct {
+	PromptID string
+	// Outputs are the DataOutputs of the prompt, grouped by node ID and then output key (e.g. "images")
+	Outputs     map[int]map[string][]DataOutput
+	Timeline    []RunTimelineEntry
+	CachedNodes []int
+	Started     time.Time
+	Finished    time.Time
+}
+
+// GetOutputs returns the DataOutputs of the given node for the output key, or nil
+func (r *RunResult) GetOutputs(nodeID int, key string) []DataOutput {
+	node, ok := r.Outputs[nodeID]
+	if !ok {
+		return nil
+	}
+	return node[key]
+}
+
+// Run queues the graph, waits for it to finish executing and returns everything ComfyUI reported about it.
+//
+// When execution fails the returned error is an *ExecutionError, and ErrPromptInterrupted when the prompt
+// was interrupted.  In both cases the partial RunResult is returned along with the error.  When ctx is done
+// before the prompt finishes, ctx.Err() is returned; the prompt is left running on the server.  When the
+// graph was queued but some of its outputs failed validation, the prompt is waited for and its RunResult
+// is returned with the *PromptValidationError.
+func (c *ComfyClient) Run(ctx context.Context, graph *Graph) (*RunResult, error) {
+	item, err := c.QueuePromptContext(ctx, graph)
+	if item == nil {
+		return nil, err
+	}
+	retv, werr := item.Wait(ctx)
+	if werr != nil {
+		return retv, werr
+	}
+	return retv, err
+}
+
+// Wait consumes the messages of the QueueItem until the prompt stops, collecting them into a RunResult.
+// See ComfyClient.Run for the errors returned.  When Wait returns before the prompt stopped, the QueueItem
+// is released.
+func (qi *QueueItem) Wait(ctx context.Context) (*RunResult, error) {
+	retv, err := waitForPrompt(ctx, qi.PromptID, qi.Messages, qi.Workflow)
+	if err != nil {
+		// nothing reads the messages of the prompt anymore
+		qi.Release()
+	}
+	return retv, err
+}
+
+// waitForPrompt collects the messages of a prompt into a RunResult until it stops
+func waitForPrompt(ctx context.Context, promptID string, messages <-chan PromptMessage, workflow *Graph) (*RunResult, error) {
+	retv := &RunResult{
+		PromptID:    promptID,
+		Outputs:     make(map[int]map[string][]DataOutput),
+		Timeline:    make([]RunTimelineEntry, 0),
+		CachedNodes: make([]int, 0),
+	}
+
+	// close the timeline entry of the node that is currently executing
+	finishCurrent := func(t time.Time) {
+		if n := len(retv.Timeline); n != 0 && retv.Timeline[n-1].Finished.IsZero() {
+			retv.Timeline[n-1].Finished = t
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return retv, ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				// the channel was closed without a "stopped" message
+				return retv, ErrComfyClosed
+			}
+			now := time.Now()
+			switch msg.Type {
+			case "started":
+				retv.PromptID = msg.ToPromptMessageStarted().PromptID
+				retv.Started = now
+			case "cached":
+				qm := msg.ToPromptMessageCached()
+				retv.CachedNodes = append(retv.CachedNodes, qm.Nodes...)
+			case "executing":
+				qm := msg.ToPromptMessageExecuting()
+				finishCurrent(now)
+				retv.Timeline = append(retv.Timeline, RunTimelineEntry{
+					NodeID:  qm.NodeID,
+					Title:   qm.Title,
+					Started: now,
+				})
+			case "data":
+				qm := msg.ToPromptMessageData()
+				outputs, ok := retv.Outputs[qm.NodeID]
+				if !ok {
+					outputs = make(map[string][]DataOutput)
+					retv.Outputs[qm.NodeID] = outputs
+				}
+				for k, v := range qm.Data {
+					outputs[k] = append(outputs[k], v...)
+				}
+			case "stopped":
+				qm := msg.ToPromptMessageStopped()
+				finishCurrent(now)
+				retv.Finished = now
+				if qm.Exception != nil {
+					return retv, newExecutionError(qm.PromptID, qm.Exception, workflow)
+				}
+				if qm.Interrupted {
+					return retv, ErrPromptInterrupted
+				}
+				return retv, nil
+			}
+		}
+	}
+}
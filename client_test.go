@@ -0,0 +1,803 @@
+package comfy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// newTestServer starts a comfytest.Server that is closed when the test ends
+func newTestServer(t *testing.T) *comfytest.Server {
+	t.Helper()
+	s := comfytest.NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTestClient returns a client connected to the server, with its node objects queried
+func newTestClient(t *testing.T, s *comfytest.Server) *ComfyClient {
+	t.Helper()
+	connected := make(chan struct{}, 1)
+	c := NewComfyClient(s.Addr, &ComfyClientCallbacks{
+		WebsocketConnected: func(*ComfyClient) {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		},
+	})
+	c.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.Close(ctx)
+	})
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+	}
+	if err := c.QueryNodeObjects(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// loadTestGraph loads a workflow of the testdata directory
+func loadTestGraph(t *testing.T, node_objects *NodeObjects, name string) *Graph {
+	t.Helper()
+	graph, missing, err := NewGraphFromJsonFile("testdata/"+name, node_objects)
+	if err != nil {
+		t.Fatalf("loading %s: %v (missing %v)", name, err, missing)
+	}
+	return graph
+}
+
+// slowScript executes the KSampler for d, so that the prompt is running for a while
+func slowScript(d time.Duration) comfytest.Script {
+	return func(p *comfytest.Prompt) []comfytest.Event {
+		return []comfytest.Event{
+			comfytest.Executing("3"),
+			comfytest.Progress("3", 1, 2).After(d),
+			comfytest.Executing("9"),
+			comfytest.ExecutedImages("9", "slow.png"),
+		}
+	}
+}
+
+// testContext returns a context that fails the test instead of hanging it
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newQueuedTestItem registers a QueueItem for the prompt with the client, as QueuePrompt does
+func newQueuedTestItem(c *ComfyClient, promptID string) *QueueItem {
+	item := &QueueItem{
+		PromptID: promptID,
+		Messages: make(chan PromptMessage, queueItemMessageBuffer),
+		client:   c,
+	}
+	item.queue = newMessageQueue(item.Messages)
+	c.addQueuedItem(item)
+	return item
+}
+
+// sendTestMessage passes a websocket message to the client as if ComfyUI had sent it
+func sendTestMessage(t *testing.T, c *ComfyClient, msgType string, data map[string]interface{}) {
+	t.Helper()
+	msg, err := json.Marshal(map[string]interface{}{"type": msgType, "data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OnWindowSocketMessage(string(msg))
+}
+
+// drain reads the channel until it is closed, failing the test if it is not closed in time
+func drain(t *testing.T, messages <-chan PromptMessage) []PromptMessage {
+	t.Helper()
+	retv := make([]PromptMessage, 0)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return retv
+			}
+			retv = append(retv, msg)
+		case <-timeout:
+			t.Fatal("messages channel was not closed")
+		}
+	}
+}
+
+func TestOnWindowSocketMessage(t *testing.T) {
+	c := NewComfyClient("localhost:8188", nil)
+	a := newQueuedTestItem(c, "a")
+	b := newQueuedTestItem(c, "b")
+
+	sendTestMessage(t, c, "execution_start", map[string]interface{}{"prompt_id": "a"})
+	sendTestMessage(t, c, "executing", map[string]interface{}{"prompt_id": "a", "node": "3"})
+	sendTestMessage(t, c, "progress", map[string]interface{}{"prompt_id": "b", "node": "3", "value": 1, "max": 20})
+	sendTestMessage(t, c, "progress", map[string]interface{}{"prompt_id": "unknown", "node": "3", "value": 1, "max": 20})
+	sendTestMessage(t, c, "executed", map[string]interface{}{"prompt_id": "a", "node": "9", "output": map[string]interface{}{
+		"images": []interface{}{map[string]interface{}{"filename": "out.png", "subfolder": "", "type": "output"}},
+	}})
+	sendTestMessage(t, c, "executing", map[string]interface{}{"prompt_id": "a", "node": nil})
+
+	messages := drain(t, a.Messages)
+	types := make([]string, 0, len(messages))
+	for _, m := range messages {
+		types = append(types, m.Type)
+	}
+	want := []string{"started", "executing", "data", "stopped"}
+	if len(types) != len(want) {
+		t.Fatalf("prompt a received %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("prompt a received %v, want %v", types, want)
+		}
+	}
+	if executing := messages[1].ToPromptMessageExecuting(); executing.NodeID != 3 {
+		t.Errorf("executing node %d, want 3", executing.NodeID)
+	}
+	if data := messages[2].ToPromptMessageData(); data.NodeID != 9 || len(data.Data["images"]) != 1 {
+		t.Errorf("unexpected data %+v", data)
+	}
+	if c.GetQueuedItem("a") != nil {
+		t.Error("the stopped prompt is still queued")
+	}
+
+	// the other prompt only received its own message, and is still queued
+	if c.GetQueuedItem("b") != b {
+		t.Fatal("prompt b is not queued anymore")
+	}
+	sendTestMessage(t, c, "execution_interrupted", map[string]interface{}{"prompt_id": "b", "node_id": "3", "node_type": "KSampler"})
+	messages = drain(t, b.Messages)
+	if len(messages) != 2 || messages[0].Type != "progress" || messages[1].Type != "stopped" || c.GetQueuedItem("b") != nil {
+		t.Errorf("the interrupted prompt received %d messages and is not stopped", len(messages))
+	}
+}
+
+func TestWait(t *testing.T) {
+	c := NewComfyClient("localhost:8188", nil)
+	item := newQueuedTestItem(c, "a")
+	sendTestMessage(t, c, "execution_start", map[string]interface{}{"prompt_id": "a"})
+	sendTestMessage(t, c, "execution_cached", map[string]interface{}{"prompt_id": "a", "nodes": []string{"4", "5"}})
+	sendTestMessage(t, c, "executing", map[string]interface{}{"prompt_id": "a", "node": "3"})
+	sendTestMessage(t, c, "executing", map[string]interface{}{"prompt_id": "a", "node": "9"})
+	for _, filename := range []string{"a.png", "b.png"} {
+		sendTestMessage(t, c, "executed", map[string]interface{}{"prompt_id": "a", "node": "9", "output": map[string]interface{}{
+			"images": []interface{}{map[string]interface{}{"filename": filename, "subfolder": "", "type": "output"}},
+		}})
+	}
+	sendTestMessage(t, c, "executing", map[string]interface{}{"prompt_id": "a", "node": nil})
+
+	result, err := item.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PromptID != "a" || len(result.CachedNodes) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if images := result.GetOutputs(9, "images"); len(images) != 2 || images[1].Filename != "b.png" {
+		t.Errorf("outputs of node 9 = %v, want both images", images)
+	}
+	if len(result.Timeline) != 2 || result.Timeline[0].NodeID != 3 || result.Timeline[0].Finished.IsZero() {
+		t.Errorf("unexpected timeline %+v", result.Timeline)
+	}
+	if result.Started.IsZero() || result.Finished.Before(result.Started) {
+		t.Errorf("started %v, finished %v", result.Started, result.Finished)
+	}
+}
+
+func TestWaitStopped(t *testing.T) {
+	c := NewComfyClient("localhost:8188", nil)
+
+	interrupted := newQueuedTestItem(c, "interrupted")
+	sendTestMessage(t, c, "execution_interrupted", map[string]interface{}{"prompt_id": "interrupted", "node_id": "3", "node_type": "KSampler"})
+	if _, err := interrupted.Wait(context.Background()); err != ErrPromptInterrupted {
+		t.Errorf("waiting for an interrupted prompt = %v, want %v", err, ErrPromptInterrupted)
+	}
+
+	closed := newQueuedTestItem(c, "closed")
+	closed.queue.discard()
+	if _, err := closed.Wait(context.Background()); err != ErrComfyClosed {
+		t.Errorf("waiting for a closed item = %v, want %v", err, ErrComfyClosed)
+	}
+
+	running := newQueuedTestItem(c, "running")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if result, err := running.Wait(ctx); err != context.DeadlineExceeded || result == nil {
+		t.Errorf("waiting until the context is done = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWaitExecutionError(t *testing.T) {
+	c := NewComfyClient("localhost:8188", nil)
+	item := newQueuedTestItem(c, "a")
+	sendTestMessage(t, c, "execution_start", map[string]interface{}{"prompt_id": "a"})
+	sendTestMessage(t, c, "execution_error", map[string]interface{}{
+		"prompt_id":         "a",
+		"node_id":           "3",
+		"node_type":         "KSampler",
+		"executed":          []string{"4", "5"},
+		"exception_type":    "torch.OutOfMemoryError",
+		"exception_message": "out of memory",
+		"traceback": []string{
+			"  File \"execution.py\", line 152, in map_node_over_list\n    results.append(getattr(obj, func)(**inputs))\n",
+			"  File \"nodes.py\", line 1369, in sample\n",
+		},
+		"current_inputs":  map[string]interface{}{"steps": []string{"20"}, "seed": []string{"42"}},
+		"current_outputs": []string{"4", "5", "3"},
+	})
+
+	_, err := item.Wait(context.Background())
+	eerr, ok := err.(*ExecutionError)
+	if !ok {
+		t.Fatalf("err = %v, want an *ExecutionError", err)
+	}
+	if eerr.NodeID != 3 || eerr.NodeType != "KSampler" || len(eerr.Executed) != 2 || len(eerr.Traceback) != 2 {
+		t.Errorf("unexpected execution error %+v", eerr)
+	}
+
+	want := `Prompt a failed
+Node: 3 "unknown" (type: KSampler)
+Exception: torch.OutOfMemoryError: out of memory
+Inputs:
+  seed: [42]
+  steps: [20]
+Executed: 4, 5
+Traceback:
+  File "execution.py", line 152, in map_node_over_list
+    results.append(getattr(obj, func)(**inputs))
+  File "nodes.py", line 1369, in sample
+`
+	if got := eerr.Report(); got != want {
+		t.Errorf("Report() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunExecutionError(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(func(p *comfytest.Prompt) []comfytest.Event {
+		return []comfytest.Event{
+			comfytest.Executing("4"),
+			comfytest.Executing("3"),
+			comfytest.ExecutionError("3", "KSampler", "RuntimeError", "out of memory"),
+		}
+	})
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	result, err := c.Run(testContext(t), graph)
+	var eerr *ExecutionError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("err = %v, want an *ExecutionError", err)
+	}
+	if eerr.NodeID != 3 || eerr.Node != graph.GetNodeById(3) || eerr.ExceptionMessage != "out of memory" {
+		t.Errorf("unexpected execution error %+v", eerr)
+	}
+	if result == nil || len(result.Timeline) == 0 {
+		t.Error("the partial result is not returned with the error")
+	}
+
+	want := "Prompt " + eerr.PromptID + ` failed
+Node: 3 "KSampler" (type: KSampler)
+Exception: RuntimeError: out of memory
+Executed: 4
+Traceback:
+  File "nodes.py", line 1, in KSampler
+`
+	if got := eerr.Report(); got != want {
+		t.Errorf("Report() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	ctx := testContext(t)
+
+	name, err := c.UploadImage(ctx, bytes.NewReader([]byte("first")), "cat.png", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.File("cat.png", "", "input"); name != "cat.png" || !ok || string(data) != "first" {
+		t.Errorf("uploaded %s, the server has %q", name, data)
+	}
+
+	// the server renames an upload that would replace a file, unless overwrite is set
+	renamed, err := c.UploadImage(ctx, bytes.NewReader([]byte("second")), "cat.png", "", "input", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed != "cat (1).png" {
+		t.Errorf("the second upload is named %s, want cat (1).png", renamed)
+	}
+	if _, err := c.UploadImage(ctx, bytes.NewReader([]byte("third")), "cat.png", "", "input", true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := s.File("cat.png", "", "input"); string(data) != "third" {
+		t.Errorf("the overwritten file has %q", data)
+	}
+
+	// files in a subfolder are named as the widgets of loader nodes take them
+	name, err = c.UploadImage(ctx, bytes.NewReader([]byte("pet")), "cat.png", "pets", "input", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.File("cat.png", "pets", "input"); name != "pets/cat.png" || !ok || string(data) != "pet" {
+		t.Errorf("uploaded %s, the server has %q", name, data)
+	}
+}
+
+func TestUploadMask(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	ctx := testContext(t)
+
+	original := DataOutput{Filename: "cat.png", Type: "input"}
+	if _, err := c.UploadMask(ctx, bytes.NewReader([]byte("mask")), "mask.png", "", "input", true, original); err == nil {
+		t.Error("a mask is uploaded for an image that does not exist")
+	}
+	if _, err := c.UploadImage(ctx, bytes.NewReader([]byte("image")), "cat.png", "", "input", true); err != nil {
+		t.Fatal(err)
+	}
+	name, err := c.UploadMask(ctx, bytes.NewReader([]byte("mask")), "cat.png", "", "input", false, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.File(name, "", "input"); name != "cat (1).png" || !ok || string(data) != "mask" {
+		t.Errorf("uploaded mask %s, the server has %q", name, data)
+	}
+}
+
+func TestUploadImageToProperty(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "loadimage.json")
+	loader := graph.GetNodeById(1)
+
+	name, err := c.UploadImageToProperty(testContext(t), loader.GetPropertyWithName("choose file to upload"), bytes.NewReader([]byte("image")), "cat.png", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := loader.GetPropertyWithName("image").GetValue(); name != "cat.png" || v != name {
+		t.Errorf("image of the LoadImage = %v, want %s", v, name)
+	}
+	if _, err := c.UploadImageToProperty(testContext(t), loader.GetPropertyWithName("image"), bytes.NewReader(nil), "cat.png", true); err == nil {
+		t.Error("an upload is bound to a property that is not an image upload")
+	}
+}
+
+func TestGetQueue(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(slowScript(time.Second))
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	items := make([]*QueueItem, 0)
+	for i := 0; i < 4; i++ {
+		item, err := c.QueuePrompt(graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	// wait for the first prompt to start executing
+	for msg := range items[0].Messages {
+		if msg.Type == "executing" {
+			break
+		}
+	}
+
+	queue, err := c.GetQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Running) != 1 || len(queue.Pending) != 3 {
+		t.Fatalf("queue has %d running and %d pending prompts, want 1 and 3", len(queue.Running), len(queue.Pending))
+	}
+	for i, item := range items {
+		if p := queue.Position(item.PromptID); p != i {
+			t.Errorf("position of prompt %d = %d, want %d", i, p, i)
+		}
+	}
+	if p := queue.Position("unknown"); p != -1 {
+		t.Errorf("position of an unknown prompt = %d, want -1", p)
+	}
+	entry := queue.Pending[0]
+	if entry.ClientID != c.ClientID() || entry.Number <= queue.Running[0].Number {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if len(entry.OutputNodes) != 1 || entry.OutputNodes[0] != 9 {
+		t.Errorf("output nodes %v, want [9]", entry.OutputNodes)
+	}
+	if pn, ok := entry.Prompt.Nodes[3]; !ok || pn.ClassType != "KSampler" || entry.Prompt.ExtraData.PngInfo.Workflow == nil {
+		t.Error("the prompt of the entry is not read back")
+	}
+	if n := len(queue.GetEntriesForClient(c.ClientID())); n != 4 {
+		t.Errorf("%d entries for the client, want 4", n)
+	}
+
+	if err := c.DeleteQueued(items[2].PromptID); err != nil {
+		t.Fatal(err)
+	}
+	if queue, err = c.GetQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Pending) != 2 || queue.Position(items[2].PromptID) != -1 || queue.Position(items[3].PromptID) != 2 {
+		t.Errorf("the deleted prompt is still pending in %+v", queue.Pending)
+	}
+
+	if err := c.ClearQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if queue, err = c.GetQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Pending) != 0 || queue.Position(items[0].PromptID) != 0 {
+		t.Errorf("clearing the queue left %d pending prompts, or removed the running one", len(queue.Pending))
+	}
+}
+
+func TestCancelPrompt(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(slowScript(time.Second))
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	running, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wait for the first prompt to start executing
+	for msg := range running.Messages {
+		if msg.Type == "executing" {
+			break
+		}
+	}
+
+	ctx := testContext(t)
+	if r, err := c.CancelPrompt(ctx, pending.PromptID); err != nil || r != CancelPromptDequeued {
+		t.Errorf("cancelling the pending prompt = %v, %v, want %v", r, err, CancelPromptDequeued)
+	}
+	if _, err := pending.Wait(ctx); err != ErrPromptInterrupted {
+		t.Errorf("waiting for the dequeued prompt = %v, want %v", err, ErrPromptInterrupted)
+	}
+	if r, err := c.CancelPrompt(ctx, running.PromptID); err != nil || r != CancelPromptInterrupted {
+		t.Errorf("cancelling the running prompt = %v, %v, want %v", r, err, CancelPromptInterrupted)
+	}
+	if _, err := running.Wait(ctx); err != ErrPromptInterrupted {
+		t.Errorf("waiting for the interrupted prompt = %v, want %v", err, ErrPromptInterrupted)
+	}
+	if s.Interrupts() != 1 {
+		t.Errorf("server was interrupted %d times, want 1", s.Interrupts())
+	}
+	if r, err := c.CancelPrompt(ctx, "unknown"); err != nil || r != CancelPromptNotFound {
+		t.Errorf("cancelling an unknown prompt = %v, %v, want %v", r, err, CancelPromptNotFound)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	result, err := c.Run(testContext(t), graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompts := s.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("server received %d prompts, want 1", len(prompts))
+	}
+	if result.PromptID != prompts[0].ID {
+		t.Errorf("PromptID = %s, want %s", result.PromptID, prompts[0].ID)
+	}
+	if prompts[0].ClientID != c.ClientID() {
+		t.Errorf("prompt was queued with client id %s, want %s", prompts[0].ClientID, c.ClientID())
+	}
+	images := result.GetOutputs(9, "images")
+	if len(images) != 1 || images[0].Filename == "" {
+		t.Errorf("outputs of the SaveImage = %v, want an image", images)
+	}
+	if len(result.Timeline) != len(graph.Nodes) {
+		t.Errorf("timeline has %d entries, want %d", len(result.Timeline), len(graph.Nodes))
+	}
+	if result.Started.IsZero() || result.Finished.Before(result.Started) {
+		t.Errorf("started %v, finished %v", result.Started, result.Finished)
+	}
+	if c.GetQueuedItem(result.PromptID) != nil {
+		t.Error("queue item is kept after the prompt stopped")
+	}
+}
+
+func TestQueuePromptValidationError(t *testing.T) {
+	s := newTestServer(t)
+	s.SetValidator(func(p *comfytest.Prompt) (int, interface{}) {
+		return http.StatusBadRequest, map[string]interface{}{
+			"error": map[string]interface{}{
+				"type":       "prompt_outputs_failed_validation",
+				"message":    "Prompt outputs failed validation",
+				"details":    "",
+				"extra_info": map[string]interface{}{},
+			},
+			"node_errors": map[string]interface{}{
+				"3": map[string]interface{}{
+					"errors": []interface{}{map[string]interface{}{
+						"type":       "value_bigger_than_max",
+						"message":    "Value bigger than max",
+						"details":    "steps",
+						"extra_info": map[string]interface{}{"input_name": "steps", "received_value": 100000},
+					}},
+					"dependent_outputs": []string{"9"},
+					"class_type":        "KSampler",
+				},
+			},
+		}
+	})
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	result, err := c.Run(testContext(t), graph)
+	if result != nil {
+		t.Error("a rejected prompt has a result")
+	}
+	var verr *PromptValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want a *PromptValidationError", err)
+	}
+	if verr.Type != "prompt_outputs_failed_validation" || len(verr.NodeErrors) != 1 {
+		t.Fatalf("unexpected validation error %+v", verr)
+	}
+	ne := verr.NodeErrors[0]
+	if ne.NodeID != 3 || ne.NodeTitle != "KSampler" || len(ne.DependentOutputs) != 1 || ne.DependentOutputs[0] != 9 {
+		t.Errorf("unexpected node error %+v", ne)
+	}
+	if len(ne.Errors) != 1 || ne.Errors[0].InputName != "steps" {
+		t.Errorf("unexpected input errors %+v", ne.Errors)
+	}
+	if len(s.Prompts()) != 0 {
+		t.Error("the rejected prompt was queued")
+	}
+}
+
+func TestRunRoutesMessagesToTheirPrompt(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(s.DefaultScript(5 * time.Millisecond))
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	items := make([]*QueueItem, 0)
+	for i := 0; i < 3; i++ {
+		item, err := c.QueuePrompt(graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	for _, item := range items {
+		result, err := item.Wait(testContext(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.PromptID != item.PromptID {
+			t.Errorf("result of %s is for prompt %s", item.PromptID, result.PromptID)
+		}
+		if len(result.GetOutputs(9, "images")) != 1 {
+			t.Errorf("prompt %s has outputs %v, want one image", item.PromptID, result.Outputs)
+		}
+	}
+}
+
+func TestUnreadQueueItemDoesNotBlock(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(func(p *comfytest.Prompt) []comfytest.Event {
+		events := []comfytest.Event{comfytest.Executing("3")}
+		for i := 0; i < 4*queueItemMessageBuffer; i++ {
+			// the executing messages keep the progress messages from being merged
+			events = append(events, comfytest.Progress("3", i, 4*queueItemMessageBuffer), comfytest.Executing("3"))
+		}
+		return append(events, comfytest.Executing("9"), comfytest.ExecutedImages("9", "out.png"))
+	})
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	unread, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Run(ctx, graph); err != nil {
+		t.Fatalf("running a prompt after an unread one: %v", err)
+	}
+
+	messages := drain(t, unread.Messages)
+	if n := len(messages); n < 8*queueItemMessageBuffer || messages[n-1].Type != "stopped" {
+		t.Errorf("the unread item received %d messages, the last one not stopped", n)
+	}
+}
+
+func TestCloseWithUnreadQueueItem(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(func(p *comfytest.Prompt) []comfytest.Event {
+		events := make([]comfytest.Event, 0)
+		for i := 0; i < 4*queueItemMessageBuffer; i++ {
+			events = append(events, comfytest.Executing("3"))
+		}
+		return append(events, comfytest.Executing("3").After(time.Second))
+	})
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	item, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("closing the client: %v", err)
+	}
+	drain(t, item.Messages)
+}
+
+func TestWaitReleasesItemWhenContextIsDone(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(slowScript(time.Second))
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	item, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := item.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if c.GetQueuedItem(item.PromptID) != nil {
+		t.Error("the item is still queued after Wait gave up")
+	}
+	drain(t, item.Messages)
+}
+
+func TestRelease(t *testing.T) {
+	s := newTestServer(t)
+	s.SetScript(slowScript(200 * time.Millisecond))
+	c := newTestClient(t, s)
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+
+	item, err := c.QueuePrompt(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.Release()
+	item.Release()
+	if c.GetQueuedItem(item.PromptID) != nil {
+		t.Error("the released item is still queued")
+	}
+	drain(t, item.Messages)
+
+	// the client keeps working for the other prompts
+	if _, err := c.Run(testContext(t), graph); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueuePromptAppliesSeedControl(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	c.SetSeedSource(func(lo int64, hi int64) int64 { return 42 })
+	graph := loadTestGraph(t, c.NodeObjects(), "txt2img.json")
+	seed := graph.GetNodeById(3).GetPropertyWithName("seed")
+
+	if _, err := c.Run(testContext(t), graph); err != nil {
+		t.Fatal(err)
+	}
+	if sent := s.Prompts()[0].Nodes["3"].Inputs["seed"]; sent != float64(156680208700286) {
+		t.Errorf("sent seed %v, want the seed of the workflow", sent)
+	}
+	if v := seed.GetValue(); v != int64(42) {
+		t.Errorf("seed after queueing = %v, want 42", v)
+	}
+
+	c.SetSeedControl(false)
+	if _, err := c.Run(testContext(t), graph); err != nil {
+		t.Fatal(err)
+	}
+	if v := seed.GetValue(); v != int64(42) {
+		t.Errorf("seed with seed control disabled = %v, want 42", v)
+	}
+}
+
+const testAPIPrompt = `{
+	"3": {"class_type": "KSampler", "inputs": {"seed": 1, "steps": 20, "cfg": 8, "sampler_name": "not_a_sampler",
+		"scheduler": "normal", "denoise": 1, "model": ["4", 0], "positive": ["6", 0], "negative": ["6", 0], "latent_image": ["5", 0]}},
+	"4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "v1-5-pruned-emaonly.safetensors"}},
+	"5": {"class_type": "EmptyLatentImage", "inputs": {"width": 512, "height": 512, "batch_size": 1}},
+	"6": {"class_type": "CLIPTextEncode", "inputs": {"text": "a cat", "clip": ["4", 1]}},
+	"8": {"class_type": "VAEDecode", "inputs": {"samples": ["3", 0], "vae": ["4", 2]}},
+	"9": {"class_type": "SaveImage", "inputs": {"filename_prefix": "ComfyUI", "images": ["8", 0]}}
+}`
+
+func TestQueueAPIPrompt(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+
+	prompt, _, err := c.NewPromptFromJsonString(testAPIPrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := c.QueueAPIPrompt(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := item.Wait(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	// the sampler is not in the object info, the prompt is queued without a workflow
+	sent := s.Prompts()[0]
+	if sent.Nodes["3"].Inputs["sampler_name"] != "not_a_sampler" {
+		t.Errorf("sent sampler_name %v", sent.Nodes["3"].Inputs["sampler_name"])
+	}
+	if pnginfo, _ := sent.ExtraData["extra_pnginfo"].(map[string]interface{}); pnginfo["workflow"] != nil {
+		t.Error("a workflow is sent for a prompt that is not a graph")
+	}
+
+	prompt, _, err = NewPromptFromJsonString(strings.Replace(testAPIPrompt, "not_a_sampler", "euler", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = c.QueueAPIPrompt(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := item.Wait(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if item.Workflow == nil || len(item.Workflow.Nodes) != 6 {
+		t.Error("the converted workflow is not attached to the queued item")
+	}
+	if pnginfo, _ := s.Prompts()[1].ExtraData["extra_pnginfo"].(map[string]interface{}); pnginfo["workflow"] == nil {
+		t.Error("the converted workflow is not sent")
+	}
+}
+
+func TestQueueAPIPromptWithoutNodeObjects(t *testing.T) {
+	s := newTestServer(t)
+	connected := make(chan struct{}, 1)
+	c := NewComfyClient(s.Addr, &ComfyClientCallbacks{
+		WebsocketConnected: func(*ComfyClient) { connected <- struct{}{} },
+	})
+	c.Start()
+	defer c.Close(context.Background())
+	<-connected
+
+	prompt, _, err := NewPromptFromJsonString(testAPIPrompt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.QueueAPIPrompt(prompt); err != ErrNotNodeObjects {
+		t.Errorf("err = %v, want %v", err, ErrNotNodeObjects)
+	}
+}
@@ -0,0 +1,120 @@
+package comfytest
+
+// DefaultObjectInfo is the /object_info served by a new Server.  It describes the core
+// nodes of a basic txt2img and img2img workflow.
+const DefaultObjectInfo = `{
+	"CheckpointLoaderSimple": {
+		"input": {"required": {"ckpt_name": [["v1-5-pruned-emaonly.safetensors", "sd_xl_base_1.0.safetensors"]]}},
+		"output": ["MODEL", "CLIP", "VAE"],
+		"output_is_list": [false, false, false],
+		"output_name": ["MODEL", "CLIP", "VAE"],
+		"name": "CheckpointLoaderSimple",
+		"display_name": "Load Checkpoint",
+		"description": "",
+		"category": "loaders",
+		"output_node": false
+	},
+	"CLIPTextEncode": {
+		"input": {"required": {"text": ["STRING", {"multiline": true, "dynamicPrompts": true}], "clip": ["CLIP"]}},
+		"output": ["CONDITIONING"],
+		"output_is_list": [false],
+		"output_name": ["CONDITIONING"],
+		"name": "CLIPTextEncode",
+		"display_name": "CLIP Text Encode (Prompt)",
+		"description": "",
+		"category": "conditioning",
+		"output_node": false
+	},
+	"EmptyLatentImage": {
+		"input": {"required": {
+			"width": ["INT", {"default": 512, "min": 16, "max": 16384, "step": 8}],
+			"height": ["INT", {"default": 512, "min": 16, "max": 16384, "step": 8}],
+			"batch_size": ["INT", {"default": 1, "min": 1, "max": 4096}]
+		}},
+		"output": ["LATENT"],
+		"output_is_list": [false],
+		"output_name": ["LATENT"],
+		"name": "EmptyLatentImage",
+		"display_name": "Empty Latent Image",
+		"description": "",
+		"category": "latent",
+		"output_node": false
+	},
+	"KSampler": {
+		"input": {"required": {
+			"model": ["MODEL"],
+			"seed": ["INT", {"default": 0, "min": 0, "max": 18446744073709551615}],
+			"steps": ["INT", {"default": 20, "min": 1, "max": 10000}],
+			"cfg": ["FLOAT", {"default": 8.0, "min": 0.0, "max": 100.0, "step": 0.1, "round": 0.01}],
+			"sampler_name": [["euler", "euler_ancestral", "dpmpp_2m", "ddim"]],
+			"scheduler": [["normal", "karras", "exponential", "simple"]],
+			"positive": ["CONDITIONING"],
+			"negative": ["CONDITIONING"],
+			"latent_image": ["LATENT"],
+			"denoise": ["FLOAT", {"default": 1.0, "min": 0.0, "max": 1.0, "step": 0.01}]
+		}},
+		"output": ["LATENT"],
+		"output_is_list": [false],
+		"output_name": ["LATENT"],
+		"name": "KSampler",
+		"display_name": "KSampler",
+		"description": "",
+		"category": "sampling",
+		"output_node": false
+	},
+	"VAEDecode": {
+		"input": {"required": {"samples": ["LATENT"], "vae": ["VAE"]}},
+		"output": ["IMAGE"],
+		"output_is_list": [false],
+		"output_name": ["IMAGE"],
+		"name": "VAEDecode",
+		"display_name": "VAE Decode",
+		"description": "",
+		"category": "latent",
+		"output_node": false
+	},
+	"VAEEncode": {
+		"input": {"required": {"pixels": ["IMAGE"], "vae": ["VAE"]}},
+		"output": ["LATENT"],
+		"output_is_list": [false],
+		"output_name": ["LATENT"],
+		"name": "VAEEncode",
+		"display_name": "VAE Encode",
+		"description": "",
+		"category": "latent",
+		"output_node": false
+	},
+	"LoadImage": {
+		"input": {"required": {"image": [["example.png"], {"image_upload": true}]}},
+		"output": ["IMAGE", "MASK"],
+		"output_is_list": [false, false],
+		"output_name": ["IMAGE", "MASK"],
+		"name": "LoadImage",
+		"display_name": "Load Image",
+		"description": "",
+		"category": "image",
+		"output_node": false
+	},
+	"SaveImage": {
+		"input": {"required": {"images": ["IMAGE"], "filename_prefix": ["STRING", {"default": "ComfyUI"}]}},
+		"output": [],
+		"output_is_list": [],
+		"output_name": [],
+		"name": "SaveImage",
+		"display_name": "Save Image",
+		"description": "Saves the input images to your ComfyUI output directory.",
+		"category": "image",
+		"output_node": true
+	},
+	"PreviewImage": {
+		"input": {"required": {"images": ["IMAGE"]}},
+		"output": [],
+		"output_is_list": [],
+		"output_name": [],
+		"name": "PreviewImage",
+		"display_name": "Preview Image",
+		"description": "Saves the input images to your ComfyUI output directory.",
+		"category": "image",
+		"output_node": true
+	}
+}`
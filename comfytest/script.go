@@ -0,0 +1,103 @@
+package comfytest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Event is a single websocket message emitted while the Server executes a prompt.
+// The prompt_id is added to Data by the Server.
+type Event struct {
+	Type  string
+	Data  map[string]interface{}
+	Delay time.Duration // how long to wait before the event is sent
+}
+
+// After returns a copy of the event that is sent after the given delay
+func (e Event) After(d time.Duration) Event {
+	e.Delay = d
+	return e
+}
+
+// Script returns the events emitted while executing a prompt.  An "execution_start" is always sent
+// before the events, and a final "executing" with a nil node after them unless the prompt was interrupted.
+type Script func(p *Prompt) []Event
+
+// Cached reports nodes whose outputs were served from the cache
+func Cached(nodes ...string) Event {
+	return Event{Type: "execution_cached", Data: map[string]interface{}{"nodes": nodes}}
+}
+
+// Executing reports that node started executing
+func Executing(node string) Event {
+	return Event{Type: "executing", Data: map[string]interface{}{"node": node}}
+}
+
+// Progress reports the progress of a node, e.g. sampling steps
+func Progress(node string, value int, max int) Event {
+	return Event{Type: "progress", Data: map[string]interface{}{"node": node, "value": value, "max": max}}
+}
+
+// Executed reports the output of a node
+func Executed(node string, output map[string]interface{}) Event {
+	return Event{Type: "executed", Data: map[string]interface{}{"node": node, "output": output}}
+}
+
+// ExecutedImages reports images saved by a node to the "output" folder.  The Server will serve
+// placeholder data for them on /view.
+func ExecutedImages(node string, filenames ...string) Event {
+	images := make([]interface{}, 0, len(filenames))
+	for _, f := range filenames {
+		images = append(images, map[string]interface{}{"filename": f, "subfolder": "", "type": "output"})
+	}
+	return Executed(node, map[string]interface{}{"images": images})
+}
+
+// ExecutionError reports an exception raised by node.  The nodes executed so far are
+// filled in by the Server.
+func ExecutionError(node string, nodeType string, exceptionType string, message string) Event {
+	return Event{Type: "execution_error", Data: map[string]interface{}{
+		"node_id":           node,
+		"node_type":         nodeType,
+		"exception_type":    exceptionType,
+		"exception_message": message,
+		"traceback":         []string{fmt.Sprintf("  File \"nodes.py\", line 1, in %s\n", nodeType)},
+		"current_inputs":    map[string]interface{}{},
+		"current_outputs":   []string{},
+	}}
+}
+
+// SortedNodeIDs returns the ids of the prompt's nodes in numeric order
+func (p *Prompt) SortedNodeIDs() []string {
+	ids := make([]string, 0, len(p.Nodes))
+	for k := range p.Nodes {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, aerr := strconv.Atoi(ids[i])
+		b, berr := strconv.Atoi(ids[j])
+		if aerr != nil || berr != nil {
+			return ids[i] < ids[j]
+		}
+		return a < b
+	})
+	return ids
+}
+
+// DefaultScript executes every node of the prompt in order of its id, each taking stepDelay.
+// Nodes whose type is an output node in the server's object info save a single image.
+func (s *Server) DefaultScript(stepDelay time.Duration) Script {
+	return func(p *Prompt) []Event {
+		retv := make([]Event, 0)
+		for _, id := range p.SortedNodeIDs() {
+			retv = append(retv, Executing(id).After(stepDelay))
+			if s.isOutputNode(p.Nodes[id].ClassType) {
+				filename := fmt.Sprintf("ComfyUI_%05d_.png", p.Number)
+				retv = append(retv, ExecutedImages(id, filename))
+			}
+		}
+		return retv
+	}
+}
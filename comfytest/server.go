@@ -0,0 +1,817 @@
+// Package comfytest provides an in-process fake ComfyUI server for testing clients offline.
+//
+// The Server implements the HTTP routes and the /ws event stream used by the comfy package,
+// and executes queued prompts by emitting a scriptable sequence of websocket events.
+package comfytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Node is a node of a queued prompt in API format
+type Node struct {
+	ClassType string                 `json:"class_type"`
+	Inputs    map[string]interface{} `json:"inputs"`
+}
+
+// Prompt is a prompt received by the Server on POST /prompt
+type Prompt struct {
+	ID        string
+	Number    int
+	ClientID  string
+	Nodes     map[string]Node
+	ExtraData map[string]interface{}
+}
+
+// Validator inspects a prompt posted to the Server.  Returning a status other than 200 rejects the
+// prompt and the body is sent back to the client as JSON.
+type Validator func(p *Prompt) (status int, body interface{})
+
+type historyEntry struct {
+	prompt  *Prompt
+	outputs map[string]map[string]interface{}
+	status  string
+}
+
+type wsClient struct {
+	conn  *websocket.Conn
+	mutex sync.Mutex
+}
+
+func (c *wsClient) send(msgType string, data interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteJSON(map[string]interface{}{"type": msgType, "data": data})
+}
+
+// Server is a fake ComfyUI server
+type Server struct {
+	// Addr is the host:port of the server, suitable for comfy.NewComfyClient
+	Addr string
+
+	http       *httptest.Server
+	upgrader   websocket.Upgrader
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	closed     bool
+	objectInfo map[string]json.RawMessage
+	outputs    map[string]bool // node types that are output nodes
+	stats      map[string]interface{}
+	script     Script
+	validator  Validator
+	clients    map[string]*wsClient
+	prompts    []*Prompt
+	pending    []*Prompt
+	running    *Prompt
+	interrupt  chan struct{}
+	history    map[string]*historyEntry
+	files      map[string][]byte // keyed by type/subfolder/filename
+	number     int
+	interrupts int
+}
+
+// NewServer starts a Server serving DefaultObjectInfo.  Prompts are executed with DefaultScript
+// without any delay between nodes.  Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		clients: make(map[string]*wsClient),
+		history: make(map[string]*historyEntry),
+		files:   make(map[string][]byte),
+		stats: map[string]interface{}{
+			"system": map[string]interface{}{"os": "posix", "python_version": "3.11.0", "embedded_python": false},
+			"devices": []interface{}{
+				map[string]interface{}{
+					"name": "cuda:0 Fake GPU", "type": "cuda", "index": 0,
+					"vram_total": int64(24) << 30, "vram_free": int64(20) << 30,
+					"torch_vram_total": int64(0), "torch_vram_free": int64(0),
+				},
+			},
+		},
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	if err := s.SetObjectInfo(DefaultObjectInfo); err != nil {
+		panic(err)
+	}
+	s.script = s.DefaultScript(0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebsocket)
+	mux.HandleFunc("/object_info", s.handleObjectInfo)
+	mux.HandleFunc("/object_info/", s.handleObjectInfo)
+	mux.HandleFunc("/prompt", s.handlePrompt)
+	mux.HandleFunc("/history", s.handleHistory)
+	mux.HandleFunc("/history/", s.handleHistory)
+	mux.HandleFunc("/queue", s.handleQueue)
+	mux.HandleFunc("/interrupt", s.handleInterrupt)
+	mux.HandleFunc("/view", s.handleView)
+	mux.HandleFunc("/upload/image", s.handleUpload)
+	mux.HandleFunc("/upload/mask", s.handleUpload)
+	mux.HandleFunc("/system_stats", s.handleSystemStats)
+	mux.HandleFunc("/embeddings", s.handleEmptyList)
+	mux.HandleFunc("/extensions", s.handleEmptyList)
+
+	s.http = httptest.NewServer(mux)
+	s.Addr = strings.TrimPrefix(s.http.URL, "http://")
+	go s.worker()
+	return s
+}
+
+// Close disconnects all websocket clients and shuts the server down
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	if s.interrupt != nil {
+		close(s.interrupt)
+		s.interrupt = nil
+	}
+	for _, c := range s.clients {
+		c.conn.Close()
+	}
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	s.http.CloseClientConnections()
+	s.http.Close()
+}
+
+// SetObjectInfo replaces the node definitions served on /object_info
+func (s *Server) SetObjectInfo(objectInfo string) error {
+	info := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(objectInfo), &info); err != nil {
+		return err
+	}
+	outputs := make(map[string]bool)
+	for k, v := range info {
+		var o struct {
+			OutputNode bool `json:"output_node"`
+		}
+		if err := json.Unmarshal(v, &o); err != nil {
+			return err
+		}
+		outputs[k] = o.OutputNode
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objectInfo = info
+	s.outputs = outputs
+	return nil
+}
+
+// SetScript sets the script used to execute the prompts queued after the call
+func (s *Server) SetScript(script Script) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.script = script
+}
+
+// SetValidator sets a validator that is run on every posted prompt, after the server checked that
+// all node types exist.  A nil validator accepts every prompt.
+func (s *Server) SetValidator(v Validator) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.validator = v
+}
+
+// SetVRAMFree sets the free VRAM reported for the device on /system_stats
+func (s *Server) SetVRAMFree(free int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device := s.stats["devices"].([]interface{})[0].(map[string]interface{})
+	device["vram_free"] = free
+}
+
+// AddFile makes data available on /view
+func (s *Server) AddFile(filename string, subfolder string, fileType string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[fileKey(filename, subfolder, fileType)] = data
+}
+
+// File returns the data of a file uploaded to, or produced by, the server
+func (s *Server) File(filename string, subfolder string, fileType string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.files[fileKey(filename, subfolder, fileType)]
+	return data, ok
+}
+
+// Prompts returns every prompt that was accepted by the server, in the order they were posted
+func (s *Server) Prompts() []*Prompt {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	retv := make([]*Prompt, len(s.prompts))
+	copy(retv, s.prompts)
+	return retv
+}
+
+// Interrupts returns the number of times /interrupt was called while a prompt was running
+func (s *Server) Interrupts() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.interrupts
+}
+
+// DisconnectClients drops every websocket connection, without stopping the server
+func (s *Server) DisconnectClients() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+func fileKey(filename string, subfolder string, fileType string) string {
+	if fileType == "" {
+		fileType = "output"
+	}
+	return fileType + "/" + subfolder + "/" + filename
+}
+
+func (s *Server) isOutputNode(classType string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.outputs[classType]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func promptError(errType string, message string, details string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":       errType,
+			"message":    message,
+			"details":    details,
+			"extra_info": map[string]interface{}{},
+		},
+		"node_errors": map[string]interface{}{},
+	}
+}
+
+// queueRemaining must be called with the mutex held
+func (s *Server) queueRemaining() int {
+	n := len(s.pending)
+	if s.running != nil {
+		n++
+	}
+	return n
+}
+
+func (s *Server) statusData(sid string) map[string]interface{} {
+	s.mutex.Lock()
+	remaining := s.queueRemaining()
+	s.mutex.Unlock()
+	data := map[string]interface{}{
+		"status": map[string]interface{}{
+			"exec_info": map[string]interface{}{"queue_remaining": remaining},
+		},
+	}
+	if sid != "" {
+		data["sid"] = sid
+	}
+	return data
+}
+
+func (s *Server) broadcastStatus() {
+	data := s.statusData("")
+	s.mutex.Lock()
+	clients := make([]*wsClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mutex.Unlock()
+	for _, c := range clients {
+		c.send("status", data)
+	}
+}
+
+func (s *Server) sendTo(clientID string, msgType string, data interface{}) {
+	s.mutex.Lock()
+	c, ok := s.clients[clientID]
+	s.mutex.Unlock()
+	if ok {
+		c.send(msgType, data)
+	}
+}
+
+func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	sid := r.URL.Query().Get("clientId")
+	if sid == "" {
+		sid = strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	c := &wsClient{conn: conn}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[sid] = c
+	s.mutex.Unlock()
+
+	c.send("status", s.statusData(sid))
+
+	// the client never sends anything meaningful, read until the connection is closed
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mutex.Lock()
+	if s.clients[sid] == c {
+		delete(s.clients, sid)
+	}
+	s.mutex.Unlock()
+	conn.Close()
+}
+
+func (s *Server) handleObjectInfo(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/object_info"), "/")
+	if name == "" {
+		writeJSON(w, http.StatusOK, s.objectInfo)
+		return
+	}
+	retv := make(map[string]json.RawMessage)
+	if o, ok := s.objectInfo[name]; ok {
+		retv[name] = o
+	}
+	writeJSON(w, http.StatusOK, retv)
+}
+
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.mutex.Lock()
+		remaining := s.queueRemaining()
+		s.mutex.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"exec_info": map[string]interface{}{"queue_remaining": remaining},
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		ClientID  string                 `json:"client_id"`
+		Prompt    map[string]Node        `json:"prompt"`
+		ExtraData map[string]interface{} `json:"extra_data"`
+		Front     bool                   `json:"front"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, promptError("invalid_prompt", "Invalid prompt", err.Error()))
+		return
+	}
+	if len(body.Prompt) == 0 {
+		writeJSON(w, http.StatusBadRequest, promptError("no_prompt", "No prompt provided", ""))
+		return
+	}
+
+	p := &Prompt{
+		ID:        uuid.New().String(),
+		ClientID:  body.ClientID,
+		Nodes:     body.Prompt,
+		ExtraData: body.ExtraData,
+	}
+
+	s.mutex.Lock()
+	hasOutput := false
+	for id, n := range p.Nodes {
+		if _, ok := s.objectInfo[n.ClassType]; !ok {
+			s.mutex.Unlock()
+			writeJSON(w, http.StatusBadRequest, promptError("invalid_prompt",
+				fmt.Sprintf("Cannot execute because node %s does not exist.", n.ClassType),
+				fmt.Sprintf("Node ID '#%s'", id)))
+			return
+		}
+		if s.outputs[n.ClassType] {
+			hasOutput = true
+		}
+	}
+	validator := s.validator
+	s.mutex.Unlock()
+
+	if !hasOutput {
+		writeJSON(w, http.StatusBadRequest, promptError("prompt_no_outputs", "Prompt has no outputs", ""))
+		return
+	}
+	if validator != nil {
+		if status, errbody := validator(p); status != http.StatusOK {
+			writeJSON(w, status, errbody)
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	p.Number = s.number
+	s.number++
+	s.prompts = append(s.prompts, p)
+	if body.Front {
+		s.pending = append([]*Prompt{p}, s.pending...)
+	} else {
+		s.pending = append(s.pending, p)
+	}
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"prompt_id":   p.ID,
+		"number":      p.Number,
+		"node_errors": map[string]interface{}{},
+	})
+	s.broadcastStatus()
+}
+
+// queueEntry serializes a prompt the way ComfyUI stores it in the queue and history, with the
+// ids of its output nodes.  It must be called with the mutex held.
+func (s *Server) queueEntry(p *Prompt) []interface{} {
+	outputs := make([]string, 0)
+	for _, id := range p.SortedNodeIDs() {
+		if s.outputs[p.Nodes[id].ClassType] {
+			outputs = append(outputs, id)
+		}
+	}
+	extra := p.ExtraData
+	if extra == nil {
+		extra = make(map[string]interface{})
+	}
+	extra["client_id"] = p.ClientID
+	return []interface{}{p.Number, p.ID, p.Nodes, extra, outputs}
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.mutex.Lock()
+		running := make([]interface{}, 0)
+		if s.running != nil {
+			running = append(running, s.queueEntry(s.running))
+		}
+		pending := make([]interface{}, 0, len(s.pending))
+		for _, p := range s.pending {
+			pending = append(pending, s.queueEntry(p))
+		}
+		s.mutex.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"queue_running": running,
+			"queue_pending": pending,
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Clear  bool     `json:"clear"`
+		Delete []string `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	if body.Clear {
+		s.pending = nil
+	}
+	for _, id := range body.Delete {
+		for i, p := range s.pending {
+			if p.ID == id {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+	s.broadcastStatus()
+}
+
+func (s *Server) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// like newer ComfyUI servers, only interrupt when the given prompt is running
+	var body struct {
+		PromptID string `json:"prompt_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.mutex.Lock()
+	if body.PromptID != "" && (s.running == nil || s.running.ID != body.PromptID) {
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if s.interrupt != nil {
+		close(s.interrupt)
+		s.interrupt = nil
+		s.interrupts++
+	}
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var body struct {
+			Clear  interface{} `json:"clear"`
+			Delete []string    `json:"delete"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mutex.Lock()
+		if body.Clear != nil {
+			s.history = make(map[string]*historyEntry)
+		}
+		for _, id := range body.Delete {
+			delete(s.history, id)
+		}
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	promptID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+	retv := make(map[string]interface{})
+	s.mutex.Lock()
+	for id, h := range s.history {
+		if promptID != "" && id != promptID {
+			continue
+		}
+		retv[id] = map[string]interface{}{
+			"prompt":  s.queueEntry(h.prompt),
+			"outputs": h.outputs,
+			"status": map[string]interface{}{
+				"status_str": h.status,
+				"completed":  h.status == "success",
+				"messages":   []interface{}{},
+			},
+		}
+	}
+	s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, retv)
+}
+
+func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data, ok := s.File(q.Get("filename"), q.Get("subfolder"), q.Get("type"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// handleUpload serves /upload/image and /upload/mask.  Masks are stored as uploaded rather
+// than being composited into the original image.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Path == "/upload/mask" {
+		var ref struct {
+			Filename  string `json:"filename"`
+			Subfolder string `json:"subfolder"`
+			Type      string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(r.FormValue("original_ref")), &ref); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.File(ref.Filename, ref.Subfolder, ref.Type); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	subfolder := r.FormValue("subfolder")
+	fileType := r.FormValue("type")
+	if fileType == "" {
+		fileType = "input"
+	}
+	overwrite := r.FormValue("overwrite") == "true" || r.FormValue("overwrite") == "1"
+	name := s.storeUpload(header.Filename, subfolder, fileType, overwrite, data)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":      name,
+		"subfolder": subfolder,
+		"type":      fileType,
+	})
+}
+
+// storeUpload saves the data, renaming it like ComfyUI does when the file exists and overwrite is false
+func (s *Server) storeUpload(filename string, subfolder string, fileType string, overwrite bool, data []byte) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := filename
+	if !overwrite {
+		base := filename
+		ext := ""
+		if i := strings.LastIndex(filename, "."); i >= 0 {
+			base = filename[:i]
+			ext = filename[i:]
+		}
+		for i := 1; ; i++ {
+			if _, exists := s.files[fileKey(name, subfolder, fileType)]; !exists {
+				break
+			}
+			name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+	}
+	s.files[fileKey(name, subfolder, fileType)] = data
+	return name
+}
+
+func (s *Server) handleSystemStats(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, s.stats)
+}
+
+func (s *Server) handleEmptyList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, []string{})
+}
+
+// worker executes the pending prompts one at a time, like the ComfyUI prompt worker
+func (s *Server) worker() {
+	for {
+		s.mutex.Lock()
+		for len(s.pending) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mutex.Unlock()
+			return
+		}
+		p := s.pending[0]
+		s.pending = s.pending[1:]
+		s.running = p
+		interrupt := make(chan struct{})
+		s.interrupt = interrupt
+		script := s.script
+		s.mutex.Unlock()
+
+		s.broadcastStatus()
+		entry := s.execute(p, script, interrupt)
+
+		s.mutex.Lock()
+		s.running = nil
+		if s.interrupt == interrupt {
+			s.interrupt = nil
+		}
+		s.history[p.ID] = entry
+		s.mutex.Unlock()
+		s.broadcastStatus()
+	}
+}
+
+func (s *Server) execute(p *Prompt, script Script, interrupt chan struct{}) *historyEntry {
+	entry := &historyEntry{
+		prompt:  p,
+		outputs: make(map[string]map[string]interface{}),
+		status:  "success",
+	}
+	executed := make([]string, 0)
+	current := ""
+
+	s.sendTo(p.ClientID, "execution_start", map[string]interface{}{"prompt_id": p.ID})
+	for _, ev := range script(p) {
+		if ev.Delay > 0 {
+			select {
+			case <-interrupt:
+			case <-time.After(ev.Delay):
+			}
+		}
+		select {
+		case <-interrupt:
+			nodeType := ""
+			if n, ok := p.Nodes[current]; ok {
+				nodeType = n.ClassType
+			}
+			s.sendTo(p.ClientID, "execution_interrupted", map[string]interface{}{
+				"prompt_id": p.ID,
+				"node_id":   current,
+				"node_type": nodeType,
+				"executed":  executed,
+			})
+			entry.status = "error"
+			return entry
+		default:
+		}
+
+		data := make(map[string]interface{}, len(ev.Data)+1)
+		for k, v := range ev.Data {
+			data[k] = v
+		}
+		data["prompt_id"] = p.ID
+
+		switch ev.Type {
+		case "executing":
+			if current != "" {
+				executed = append(executed, current)
+			}
+			current, _ = data["node"].(string)
+		case "execution_cached":
+			if nodes, ok := data["nodes"].([]string); ok {
+				executed = append(executed, nodes...)
+			}
+		case "executed":
+			node, _ := data["node"].(string)
+			if output, ok := data["output"].(map[string]interface{}); ok {
+				entry.outputs[node] = output
+				s.registerOutputFiles(output)
+			}
+		case "execution_error":
+			if _, ok := data["executed"]; !ok {
+				// a node that was executing before the failing one has finished
+				if node, _ := data["node_id"].(string); current != "" && current != node {
+					executed = append(executed, current)
+				}
+				data["executed"] = executed
+			}
+		}
+
+		s.sendTo(p.ClientID, ev.Type, data)
+		if ev.Type == "execution_error" {
+			entry.status = "error"
+			break
+		}
+	}
+
+	s.sendTo(p.ClientID, "executing", map[string]interface{}{"node": nil, "prompt_id": p.ID})
+	return entry
+}
+
+// registerOutputFiles makes placeholder data available on /view for every file in the output
+func (s *Server) registerOutputFiles(output map[string]interface{}) {
+	for _, v := range output {
+		files, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, f := range files {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			filename, _ := fm["filename"].(string)
+			subfolder, _ := fm["subfolder"].(string)
+			fileType, _ := fm["type"].(string)
+			if filename == "" {
+				continue
+			}
+			key := fileKey(filename, subfolder, fileType)
+			s.mutex.Lock()
+			if _, exists := s.files[key]; !exists {
+				s.files[key] = []byte(strconv.Quote(filename))
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
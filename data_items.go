@@ -1,5 +1,10 @@
 package comfy
 
+import (
+	"encoding/json"
+	"errors"
+)
+
 // There may be other DataOutput types.  We definitely need a text type
 
 type DataOutput struct {
@@ -50,7 +55,147 @@ type PromptError struct {
 	ExtraInfo map[string]interface{} `json:"extra_info"`
 }
 
+// PromptNodeError holds the validation errors ComfyUI reported for a single node of a prompt
+type PromptNodeError struct {
+	Errors           []PromptError `json:"errors"`
+	DependentOutputs []interface{} `json:"dependent_outputs"`
+	ClassType        string        `json:"class_type"`
+}
+
 type PromptErrorMessage struct {
-	Error      PromptError   `json:"error"`
-	NodeErrors []interface{} `json:"node_errors"`
+	Error      PromptError                `json:"error"`
+	NodeErrors map[string]PromptNodeError `json:"node_errors"`
+}
+
+func (pem *PromptErrorMessage) UnmarshalJSON(b []byte) error {
+	// node_errors is a map keyed by node id, but is serialized as an empty
+	// array when there are no node errors
+	var temp struct {
+		Error      PromptError     `json:"error"`
+		NodeErrors json.RawMessage `json:"node_errors"`
+	}
+	if err := json.Unmarshal(b, &temp); err != nil {
+		return err
+	}
+
+	pem.Error = temp.Error
+	pem.NodeErrors = make(map[string]PromptNodeError)
+	if len(temp.NodeErrors) != 0 && temp.NodeErrors[0] == '{' {
+		if err := json.Unmarshal(temp.NodeErrors, &pem.NodeErrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueEntry is a prompt in the ComfyUI queue
+type QueueEntry struct {
+	Number      int
+	PromptID    string
+	ClientID    string
+	Prompt      *Prompt // reconstructed from the queued data, the workflow is nil if it was not sent
+	OutputNodes []int   // ids of the output nodes that will be executed
+}
+
+// Queue is the state of the ComfyUI queue
+type Queue struct {
+	Running []QueueEntry
+	Pending []QueueEntry // ordered by the position in the queue
+}
+
+// Position returns 0 when the prompt is running, its 1 based position in the pending
+// queue when it is waiting, or -1 when the prompt is not in the queue
+func (q *Queue) Position(promptID string) int {
+	for _, e := range q.Running {
+		if e.PromptID == promptID {
+			return 0
+		}
+	}
+	for i, e := range q.Pending {
+		if e.PromptID == promptID {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// GetEntriesForClient returns the running and pending entries queued by the given client id
+func (q *Queue) GetEntriesForClient(clientID string) []QueueEntry {
+	retv := make([]QueueEntry, 0)
+	for _, e := range q.Running {
+		if e.ClientID == clientID {
+			retv = append(retv, e)
+		}
+	}
+	for _, e := range q.Pending {
+		if e.ClientID == clientID {
+			retv = append(retv, e)
+		}
+	}
+	return retv
+}
+
+func (qe *QueueEntry) UnmarshalJSON(b []byte) error {
+	// The entry is stored as an array layed out like this:
+	// [
+	// 	[0] number 		int,
+	// 	[1] promptID 	string,
+	// 	[2] prompt 		map[string]PromptNode,
+	// 	[3] extra_data 	{"client_id": string, "extra_pnginfo": {"workflow": Graph}},
+	//  [4] outputs     []string 						// array of nodeIDs that will be executed
+	// ]
+	var tmp []json.RawMessage
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		return err
+	}
+	if len(tmp) < 4 {
+		return errors.New("wrong number of fields in queue entry")
+	}
+
+	if err := json.Unmarshal(tmp[0], &qe.Number); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(tmp[1], &qe.PromptID); err != nil {
+		return err
+	}
+
+	nodes := make(map[string]*PromptNode)
+	if err := json.Unmarshal(tmp[2], &nodes); err != nil {
+		return err
+	}
+
+	var extra struct {
+		ClientID     string `json:"client_id"`
+		ExtraPngInfo struct {
+			Workflow *Graph `json:"workflow"`
+		} `json:"extra_pnginfo"`
+	}
+	if err := json.Unmarshal(tmp[3], &extra); err != nil {
+		return err
+	}
+	qe.ClientID = extra.ClientID
+
+	qe.Prompt = &Prompt{
+		ClientID: extra.ClientID,
+		Nodes:    make(map[int]*PromptNode),
+		PID:      qe.PromptID,
+	}
+	qe.Prompt.ExtraData.PngInfo.Workflow = extra.ExtraPngInfo.Workflow
+	if err := qe.Prompt.setNodes(nodes); err != nil {
+		return err
+	}
+
+	qe.OutputNodes = make([]int, 0)
+	if len(tmp) > 4 {
+		outputs := make([]string, 0)
+		if err := json.Unmarshal(tmp[4], &outputs); err != nil {
+			return err
+		}
+		for _, o := range outputs {
+			if oid, err := parseNodeID(o); err == nil {
+				qe.OutputNodes = append(qe.OutputNodes, oid)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,130 @@
+package comfy
+
+import (
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ChoiceSource returns a random index from 0 to n-1 for the choices of a dynamic prompt.  rand.Intn is
+// a ChoiceSource, as is the Intn method of a seeded rand.Rand for reproducible prompts.
+type ChoiceSource func(n int) int
+
+// DynamicPrompt records the text a dynamic prompt of a STRING input was expanded to
+type DynamicPrompt struct {
+	NodeID   string // the id of the node in the prompt, namespaced for the nodes of group nodes and subgraphs
+	Input    string
+	Template string
+	Text     string
+}
+
+var dynamicPromptComments = regexp.MustCompile(`/\*[\s\S]*?\*/|//.*`)
+
+// ExpandDynamicPrompt expands the text of a STRING input with dynamicPrompts like the frontend does before
+// queueing: comments are removed, and each {a|b|c} is replaced by one of its choices picked by source.
+// Choices can be nested, only the chosen one is expanded, so source is called once for each block that
+// ends up in the text.  \{, \} and \| are replaced by the character, other backslashes are kept.  A "{"
+// without a matching "}" is kept as it is.  A nil source uses math/rand.
+func ExpandDynamicPrompt(text string, source ChoiceSource) string {
+	if source == nil {
+		source = rand.Intn
+	}
+	runes := []rune(dynamicPromptComments.ReplaceAllString(text, ""))
+	e := &promptExpander{runes: runes, closing: make(map[int]int), source: source}
+	e.matchBraces()
+	var retv strings.Builder
+	e.expand(&retv, 0, len(runes))
+	return retv.String()
+}
+
+// promptExpander expands the blocks of a dynamic prompt
+type promptExpander struct {
+	runes   []rune
+	closing map[int]int // the index of the "}" closing the "{" at an index
+	source  ChoiceSource
+}
+
+// isEscape returns true when the rune at i escapes the rune following it
+func (e *promptExpander) isEscape(i int) bool {
+	if e.runes[i] != '\\' || i+1 >= len(e.runes) {
+		return false
+	}
+	next := e.runes[i+1]
+	return next == '{' || next == '}' || next == '|'
+}
+
+func (e *promptExpander) matchBraces() {
+	open := make([]int, 0)
+	for i := 0; i < len(e.runes); i++ {
+		switch {
+		case e.isEscape(i):
+			i++
+		case e.runes[i] == '{':
+			open = append(open, i)
+		case e.runes[i] == '}' && len(open) != 0:
+			e.closing[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+	}
+}
+
+// expand writes the expansion of the runes from start up to end
+func (e *promptExpander) expand(w *strings.Builder, start int, end int) {
+	for i := start; i < end; i++ {
+		c := e.runes[i]
+		if e.isEscape(i) {
+			i++
+			w.WriteRune(e.runes[i])
+			continue
+		}
+		closing, ok := e.closing[i]
+		if c != '{' || !ok {
+			w.WriteRune(c)
+			continue
+		}
+
+		// split the block at the "|" that are not in nested blocks
+		bounds := []int{i}
+		for j := i + 1; j < closing; j++ {
+			if e.isEscape(j) {
+				j++
+			} else if nested, ok := e.closing[j]; ok && e.runes[j] == '{' {
+				j = nested
+			} else if e.runes[j] == '|' {
+				bounds = append(bounds, j)
+			}
+		}
+		bounds = append(bounds, closing)
+		chosen := e.source(len(bounds) - 1)
+		e.expand(w, bounds[chosen]+1, bounds[chosen+1])
+		i = closing
+	}
+}
+
+// expandDynamicPrompts expands the values of the node's STRING inputs with dynamicPrompts in the prompt
+// node, in the order of the inputs so that a seeded source picks the same choices
+func (s *promptScope) expandDynamicPrompts(p *Prompt, node *GraphNode, pn *PromptNode, id string) {
+	props := make([]*StringProperty, 0)
+	for _, prop := range node.Properties {
+		sp, ok := prop.ToStringProperty()
+		if !ok || !sp.DynamicPrompts {
+			continue
+		}
+		props = append(props, sp)
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return props[i].Index() < props[j].Index()
+	})
+
+	for _, sp := range props {
+		// linked inputs are not expanded
+		template, ok := pn.Inputs[sp.Name()].(string)
+		if !ok {
+			continue
+		}
+		text := ExpandDynamicPrompt(template, s.choices)
+		pn.Inputs[sp.Name()] = text
+		p.DynamicPrompts = append(p.DynamicPrompts, DynamicPrompt{NodeID: id, Input: sp.Name(), Template: template, Text: text})
+	}
+}
@@ -0,0 +1,73 @@
+package comfy
+
+import (
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// lastChoice always picks the last choice, counting the blocks it is called for
+func lastChoice(calls *int) ChoiceSource {
+	return func(n int) int {
+		*calls++
+		return n - 1
+	}
+}
+
+func TestExpandDynamicPrompt(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		want  string
+		calls int
+	}{
+		{"a cat", "a cat", 0},
+		{"a {cat|dog}", "a dog", 1},
+		{"a {red|blue} {cat|dog}", "a blue dog", 2},
+		{"a {cat|{small|big} dog}", "a big dog", 2},
+		// only the chosen choice is expanded
+		{"a {{small|big} dog|cat}", "a cat", 1},
+		{"a {cat|dog} // comment", "a dog ", 1},
+		{"a /* {cat|dog} */cat", "a cat", 0},
+		{`a \{cat\|dog\}`, "a {cat|dog}", 0},
+		{`a \(cat\)`, `a \(cat\)`, 0},
+		{"a {cat|dog", "a {cat|dog", 0},
+		{"a {cat|{dog|bird}", "a {cat|bird", 1},
+		{"a cat}", "a cat}", 0},
+		{"a {}", "a ", 1},
+	} {
+		calls := 0
+		if got := ExpandDynamicPrompt(tc.text, lastChoice(&calls)); got != tc.want {
+			t.Errorf("%q expands to %q, want %q", tc.text, got, tc.want)
+		}
+		if calls != tc.calls {
+			t.Errorf("%q picked %d choices, want %d", tc.text, calls, tc.calls)
+		}
+	}
+}
+
+func TestGraphToPromptDynamicPrompts(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	if err := graph.GetNodeById(6).GetPropertyWithName("text").SetValue("a {cat|dog}"); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	graph.DynamicPromptSource = lastChoice(&calls)
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inputValue(p, 6, "text"); got != "a dog" {
+		t.Errorf("text sent as %q, want %q", got, "a dog")
+	}
+	if len(p.DynamicPrompts) != 2 {
+		t.Fatalf("dynamic prompts %v, want one for each CLIPTextEncode", p.DynamicPrompts)
+	}
+	if dp := p.DynamicPrompts[0]; dp.NodeID != "6" || dp.Input != "text" || dp.Template != "a {cat|dog}" || dp.Text != "a dog" {
+		t.Errorf("unexpected dynamic prompt %+v", dp)
+	}
+	// the template is kept in the graph
+	if v := graph.GetNodeById(6).GetPropertyWithName("text").GetValue(); v != "a {cat|dog}" {
+		t.Errorf("text of the graph = %v", v)
+	}
+}
@@ -2,8 +2,323 @@ package comfy
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var ErrComfyDisconnected = errors.New("comfy disconnected")
 var ErrNotNodeObjects = errors.New("not node objects")
 var ErrNotWorkflowInPNG = errors.New("png does not contain workflow metadata")
+var ErrComfyClosed = errors.New("comfy client closed")
+var ErrPromptInterrupted = errors.New("prompt interrupted")
+var ErrNoClientAvailable = errors.New("no comfy client available")
+var ErrNoCapableClient = errors.New("no comfy client supports every node type of the graph")
+var ErrNodeNotInGraph = errors.New("node is not in the graph")
+var ErrLinkNotInGraph = errors.New("link is not in the graph")
+var ErrGraphCycle = errors.New("graph contains a cycle")
+var ErrVirtualNodeDeadEnd = errors.New("virtual node does not lead to a node")
+var ErrInvalidValueType = errors.New("invalid value type")
+var ErrValueOutOfRange = errors.New("value out of range")
+var ErrValueNotOnStep = errors.New("value is not on a step")
+var ErrValueNotInList = errors.New("value not in list")
+var ErrPropertyNotSettable = errors.New("property is not settable")
+var ErrPropertyNotBound = errors.New("property has no target node")
+
+// PropertyValueError is returned when a property cannot be set to a value.  Err is one of ErrInvalidValueType,
+// ErrValueOutOfRange, ErrValueNotOnStep, ErrValueNotInList, ErrPropertyNotSettable or ErrPropertyNotBound.
+type PropertyValueError struct {
+	NodeID   int
+	NodeType string // empty when the property is not bound to a node
+	Property string
+	Value    interface{}
+	Reason   string
+	Err      error
+}
+
+func newValueError(err error, format string, args ...interface{}) *PropertyValueError {
+	return &PropertyValueError{Err: err, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (e *PropertyValueError) Error() string {
+	what := fmt.Sprintf("property %s", e.Property)
+	if e.NodeType != "" {
+		what = fmt.Sprintf("node %d (%s) %s", e.NodeID, e.NodeType, what)
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: %v", what, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", what, e.Err, e.Reason)
+}
+
+func (e *PropertyValueError) Unwrap() error {
+	return e.Err
+}
+
+// ExecutionError is returned when ComfyUI raised an exception while executing a prompt.
+// The failing node is resolved against the Graph that was queued, when available.
+type ExecutionError struct {
+	PromptID         string
+	NodeID           int
+	NodeType         string
+	NodeName         string     // the title of the node, or its display name when it has no title
+	NodeDisplayName  string     // the display name of the node type
+	Node             *GraphNode // the failing node in the queued graph, nil if it could not be resolved
+	ExceptionMessage string
+	ExceptionType    string
+	Traceback        []string
+	Executed         []int                  // nodes that finished executing before the exception
+	CurrentInputs    map[string]interface{} // formatted input values of the failing node
+	CurrentOutputs   interface{}
+}
+
+func newExecutionError(promptID string, e *PromptMessageStoppedException, graph *Graph) *ExecutionError {
+	retv := &ExecutionError{
+		PromptID:         promptID,
+		NodeID:           e.NodeID,
+		NodeType:         e.NodeType,
+		NodeName:         e.NodeName,
+		ExceptionMessage: e.ExceptionMessage,
+		ExceptionType:    e.ExceptionType,
+		Traceback:        e.Traceback,
+		Executed:         e.Executed,
+		CurrentInputs:    e.CurrentInputs,
+		CurrentOutputs:   e.CurrentOutputs,
+	}
+	if graph != nil {
+		if node := graph.GetNodeById(e.NodeID); node != nil {
+			retv.Node = node
+			retv.NodeDisplayName = node.DisplayName
+			retv.NodeName = node.Title
+			if retv.NodeName == "" {
+				retv.NodeName = node.DisplayName
+			}
+			if retv.NodeType == "" {
+				retv.NodeType = node.Type
+			}
+		}
+	}
+	return retv
+}
+
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("prompt %s failed at node %d (%s): %s: %s", e.PromptID, e.NodeID, e.NodeType, e.ExceptionType, e.ExceptionMessage)
+}
+
+// Report formats the error as a readable multi-line report including the failing node,
+// its inputs, the nodes that were already executed and the python traceback
+func (e *ExecutionError) Report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Prompt %s failed\n", e.PromptID)
+	fmt.Fprintf(&sb, "Node: %d", e.NodeID)
+	if e.NodeName != "" {
+		fmt.Fprintf(&sb, " %q", e.NodeName)
+	}
+	fmt.Fprintf(&sb, " (type: %s", e.NodeType)
+	if e.NodeDisplayName != "" && e.NodeDisplayName != e.NodeType {
+		fmt.Fprintf(&sb, ", display name: %s", e.NodeDisplayName)
+	}
+	sb.WriteString(")\n")
+	fmt.Fprintf(&sb, "Exception: %s: %s\n", e.ExceptionType, e.ExceptionMessage)
+
+	if len(e.CurrentInputs) != 0 {
+		sb.WriteString("Inputs:\n")
+		names := make([]string, 0, len(e.CurrentInputs))
+		for k := range e.CurrentInputs {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(&sb, "  %s: %v\n", k, e.CurrentInputs[k])
+		}
+	}
+
+	if len(e.Executed) != 0 {
+		executed := make([]string, 0, len(e.Executed))
+		for _, id := range e.Executed {
+			executed = append(executed, strconv.Itoa(id))
+		}
+		fmt.Fprintf(&sb, "Executed: %s\n", strings.Join(executed, ", "))
+	}
+
+	if len(e.Traceback) != 0 {
+		sb.WriteString("Traceback:\n")
+		for _, line := range e.Traceback {
+			// each traceback entry may contain several lines
+			for _, l := range strings.Split(strings.TrimRight(line, "\n"), "\n") {
+				sb.WriteString(l)
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// InputValidationError is a single problem ComfyUI found with an input of a node
+type InputValidationError struct {
+	Type          string
+	Message       string
+	Details       string
+	InputName     string      // empty when the error is not related to a specific input
+	ReceivedValue interface{} // the value that was sent for the input, if reported
+	ExtraInfo     map[string]interface{}
+}
+
+// NodeValidationError collects the validation errors of a single node in the graph
+type NodeValidationError struct {
+	NodeID           int
+	NodeTitle        string
+	ClassType        string
+	DependentOutputs []int
+	Errors           []InputValidationError
+}
+
+// PromptValidationError is returned by QueuePrompt when ComfyUI rejects the graph.
+// Use errors.As to retrieve it and inspect the per-node, per-input errors.
+type PromptValidationError struct {
+	Type       string
+	Message    string
+	Details    string
+	ExtraInfo  map[string]interface{}
+	NodeErrors []NodeValidationError
+}
+
+func newPromptValidationError(perror *PromptErrorMessage, graph *Graph) *PromptValidationError {
+	retv := &PromptValidationError{
+		Type:       perror.Error.Type,
+		Message:    perror.Error.Message,
+		Details:    perror.Error.Details,
+		ExtraInfo:  perror.Error.ExtraInfo,
+		NodeErrors: make([]NodeValidationError, 0, len(perror.NodeErrors)),
+	}
+
+	for k, ne := range perror.NodeErrors {
+		nid, _ := parseNodeID(k)
+		nve := NodeValidationError{
+			NodeID:           nid,
+			ClassType:        ne.ClassType,
+			DependentOutputs: make([]int, 0, len(ne.DependentOutputs)),
+			Errors:           make([]InputValidationError, 0, len(ne.Errors)),
+		}
+		if graph != nil {
+			if node := graph.GetNodeById(nid); node != nil {
+				nve.NodeTitle = node.Title
+				if nve.NodeTitle == "" {
+					nve.NodeTitle = node.DisplayName
+				}
+			}
+		}
+		for _, d := range ne.DependentOutputs {
+			// output ids are serialized as strings
+			if ds, ok := d.(string); ok {
+				if did, err := parseNodeID(ds); err == nil {
+					nve.DependentOutputs = append(nve.DependentOutputs, did)
+				}
+			}
+		}
+		for _, e := range ne.Errors {
+			ive := InputValidationError{
+				Type:      e.Type,
+				Message:   e.Message,
+				Details:   e.Details,
+				ExtraInfo: e.ExtraInfo,
+			}
+			if e.ExtraInfo != nil {
+				if name, ok := e.ExtraInfo["input_name"].(string); ok {
+					ive.InputName = name
+				}
+				ive.ReceivedValue = e.ExtraInfo["received_value"]
+			}
+			nve.Errors = append(nve.Errors, ive)
+		}
+		retv.NodeErrors = append(retv.NodeErrors, nve)
+	}
+
+	sort.Slice(retv.NodeErrors, func(i, j int) bool {
+		return retv.NodeErrors[i].NodeID < retv.NodeErrors[j].NodeID
+	})
+	if retv.Message == "" && len(retv.NodeErrors) != 0 {
+		retv.Message = "Prompt has node errors"
+	}
+	return retv
+}
+
+func (e *PromptValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Message)
+	if e.Details != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Details)
+	}
+	for _, ne := range e.NodeErrors {
+		for _, ie := range ne.Errors {
+			fmt.Fprintf(&sb, "; node %d (%s)", ne.NodeID, ne.ClassType)
+			if ie.InputName != "" {
+				fmt.Fprintf(&sb, " input %s", ie.InputName)
+			}
+			fmt.Fprintf(&sb, ": %s", ie.Message)
+			if ie.Details != "" {
+				fmt.Fprintf(&sb, " (%s)", ie.Details)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// GetNodeErrors returns the validation errors for the node with the given ID, or nil
+func (e *PromptValidationError) GetNodeErrors(nodeID int) *NodeValidationError {
+	for i := range e.NodeErrors {
+		if e.NodeErrors[i].NodeID == nodeID {
+			return &e.NodeErrors[i]
+		}
+	}
+	return nil
+}
+
+// GetInputError returns the first validation error for the named input of the node, or nil
+func (e *PromptValidationError) GetInputError(nodeID int, inputName string) *InputValidationError {
+	ne := e.GetNodeErrors(nodeID)
+	if ne == nil {
+		return nil
+	}
+	for i := range ne.Errors {
+		if ne.Errors[i].InputName == inputName {
+			return &ne.Errors[i]
+		}
+	}
+	return nil
+}
+
+// ReplaceNodeError is returned by ReplaceNode with the new node when values or links of the replaced node
+// matched the new node but could not be moved to it.  Values holds the errors setting the properties of the
+// new node by name, Links the errors reconnecting the links of the replaced node by link id.  Those links
+// are removed with the replaced node.
+type ReplaceNodeError struct {
+	NodeID   int
+	NodeType string
+	Values   map[string]error
+	Links    map[int]error
+}
+
+func (e *ReplaceNodeError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "node %d (%s) replaced", e.NodeID, e.NodeType)
+	names := make([]string, 0, len(e.Values))
+	for name := range e.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "; value %s not copied: %v", name, e.Values[name])
+	}
+	ids := make([]int, 0, len(e.Links))
+	for id := range e.Links {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&sb, "; link %d dropped: %v", id, e.Links[id])
+	}
+	return sb.String()
+}
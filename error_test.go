@@ -0,0 +1,60 @@
+package comfy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// a prompt rejected by ComfyUI, as returned by POST /prompt
+const testPromptErrorBody = `{
+	"error": {"type": "prompt_outputs_failed_validation", "message": "Prompt outputs failed validation", "details": "", "extra_info": {}},
+	"node_errors": {
+		"7": {"errors": [{"type": "required_input_missing", "message": "Required input is missing", "details": "clip",
+			"extra_info": {"input_name": "clip"}}], "dependent_outputs": ["9"], "class_type": "CLIPTextEncode"},
+		"3": {"errors": [{"type": "value_bigger_than_max", "message": "Value 100000 bigger than max of 10000", "details": "steps",
+			"extra_info": {"input_name": "steps", "received_value": 100000}}], "dependent_outputs": ["9", "12"], "class_type": "KSampler"}
+	}
+}`
+
+func TestPromptValidationError(t *testing.T) {
+	perror := &PromptErrorMessage{}
+	if err := json.Unmarshal([]byte(testPromptErrorBody), perror); err != nil {
+		t.Fatal(err)
+	}
+	verr := newPromptValidationError(perror, nil)
+
+	if verr.Type != "prompt_outputs_failed_validation" || len(verr.NodeErrors) != 2 {
+		t.Fatalf("unexpected validation error %+v", verr)
+	}
+	// the nodes are sorted by id
+	ks := verr.NodeErrors[0]
+	if ks.NodeID != 3 || ks.ClassType != "KSampler" || len(ks.DependentOutputs) != 2 || ks.DependentOutputs[1] != 12 {
+		t.Errorf("unexpected node error %+v", ks)
+	}
+	steps := verr.GetInputError(3, "steps")
+	if steps == nil || steps.Type != "value_bigger_than_max" || steps.ReceivedValue != 100000.0 {
+		t.Errorf("unexpected input error %+v", steps)
+	}
+	if verr.GetInputError(3, "cfg") != nil || verr.GetNodeErrors(4) != nil {
+		t.Error("errors are returned for inputs without errors")
+	}
+
+	want := "Prompt outputs failed validation" +
+		"; node 3 (KSampler) input steps: Value 100000 bigger than max of 10000 (steps)" +
+		"; node 7 (CLIPTextEncode) input clip: Required input is missing (clip)"
+	if got := verr.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestPromptErrorMessageWithoutNodeErrors(t *testing.T) {
+	perror := &PromptErrorMessage{}
+	body := `{"error": {"type": "prompt_no_outputs", "message": "Prompt has no outputs", "details": "", "extra_info": {}}, "node_errors": []}`
+	if err := json.Unmarshal([]byte(body), perror); err != nil {
+		t.Fatal(err)
+	}
+	verr := newPromptValidationError(perror, nil)
+	if verr.Type != "prompt_no_outputs" || len(verr.NodeErrors) != 0 || verr.Error() != "Prompt has no outputs" {
+		t.Errorf("unexpected validation error %+v", verr)
+	}
+}
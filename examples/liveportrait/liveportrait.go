@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -97,66 +98,35 @@ func main() {
 	qpProp := saver.GetPropertyWithName("quality")
 	qpProp.SetValue(85)
 
-	_, err = cc.QueuePrompt(g)
+	result, err := cc.Run(context.Background(), g)
 	if err != nil {
-		logger.Error("Failed to queue prompt: ", err)
+		logger.Error("Failed to run prompt: ", err)
 		os.Exit(1)
 	}
 
-	//var currentNodeTitle string
-	for continueLoop := true; continueLoop; {
-		msg := <-cc.GetMessages()
-		switch msg.Type {
-		case "started":
-			qm := msg.ToPromptMessageStarted()
-			logger.Debugf("Start executing prompt ID %s\n", qm.PromptID)
-		case "executing":
-			qm := msg.ToPromptMessageExecuting()
-			// store the node's title so we can use it in the progress bar
-			//currentNodeTitle = qm.Title
-			logger.Debugf("Executing Node: %d\n", qm.NodeID)
-		case "progress":
-			// update our progress bar
-			qm := msg.ToPromptMessageProgress()
-			fmt.Printf("\rprogress: %d", qm.Value)
-		case "stopped":
-			// if we were stopped for an exception, display the exception message
-			qm := msg.ToPromptMessageStopped()
-			if qm.Exception != nil {
-				logger.Debugf("exception: %v", qm.Exception)
-				os.Exit(1)
-			}
-			continueLoop = !qm.Stop
-			logger.Debugf("continueLoop:  %v \n", qm.Stop)
-		case "data":
-			qm := msg.ToPromptMessageData()
-			// data objects have the fields: Filename, Subfolder, Type
-			// * Subfolder is the subfolder in the output directory
-			// * Type is the type of the image temp/
-			for k, v := range qm.Data {
-				if k == "images" || k == "gifs" {
-					for _, output := range v {
-						img_data, err := cc.GetImage(output)
-						if err != nil {
-							logger.Debugf("Failed to get image: %v", err)
-							os.Exit(1)
-						}
-						f, err := os.Create(output.Filename)
-						if err != nil {
-							logger.Debugf("Failed to write image: %v", err)
-							os.Exit(1)
-						}
-						f.Write(*img_data)
-						f.Close()
-						logger.Debugf("Got data: %s", output.Filename)
+	// data objects have the fields: Filename, Subfolder, Type
+	// * Subfolder is the subfolder in the output directory
+	// * Type is the type of the image temp/
+	for _, outputs := range result.Outputs {
+		for k, v := range outputs {
+			if k == "images" || k == "gifs" {
+				for _, output := range v {
+					img_data, err := cc.GetImage(output)
+					if err != nil {
+						logger.Debugf("Failed to get image: %v", err)
+						os.Exit(1)
+					}
+					f, err := os.Create(output.Filename)
+					if err != nil {
+						logger.Debugf("Failed to write image: %v", err)
+						os.Exit(1)
 					}
+					f.Write(*img_data)
+					f.Close()
+					logger.Debugf("Got data: %s", output.Filename)
 				}
 			}
 		}
 	}
-	// logger.Debug("clear message  >> ")
-	// for range cc.GetMessages() {
-	// 	logger.Debug("clear message ")
-	// }
 	logger.Debug("ByeBye...")
 }
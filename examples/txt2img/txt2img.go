@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -82,59 +83,32 @@ func main() {
 	positive.GetPropertyWithName("text").SetValue("1girl, dancing, outdoor, large breasts")
 	negative.GetPropertyWithName("text").SetValue("text, watermark")
 
-	_, err = cc.QueuePrompt(g)
+	result, err := cc.Run(context.Background(), g)
 	if err != nil {
-		log.Println("Failed to queue prompt: ", err)
+		log.Println("Failed to run prompt: ", err)
 		os.Exit(1)
 	}
 
-	//var currentNodeTitle string
-	for continueLoop := true; continueLoop; {
-		msg := <-cc.GetMessages()
-		switch msg.Type {
-		case "started":
-			qm := msg.ToPromptMessageStarted()
-			log.Printf("Start executing prompt ID %s\n", qm.PromptID)
-		case "executing":
-
-			qm := msg.ToPromptMessageExecuting()
-			// store the node's title so we can use it in the progress bar
-			//currentNodeTitle = qm.Title
-			log.Printf("Executing Node: %d\n", qm.NodeID)
-		case "progress":
-			// update our progress bar
-			qm := msg.ToPromptMessageProgress()
-			fmt.Printf("\rprogress: %d", qm.Value)
-		case "stopped":
-			// if we were stopped for an exception, display the exception message
-			qm := msg.ToPromptMessageStopped()
-			if qm.Exception != nil {
-				log.Println(qm.Exception)
-				os.Exit(1)
-			}
-			continueLoop = false
-		case "data":
-			qm := msg.ToPromptMessageData()
-			// data objects have the fields: Filename, Subfolder, Type
-			// * Subfolder is the subfolder in the output directory
-			// * Type is the type of the image temp/
-			for k, v := range qm.Data {
-				if k == "images" || k == "gifs" {
-					for _, output := range v {
-						img_data, err := cc.GetImage(output)
-						if err != nil {
-							log.Println("Failed to get image:", err)
-							os.Exit(1)
-						}
-						f, err := os.Create(output.Filename)
-						if err != nil {
-							log.Println("Failed to write image:", err)
-							os.Exit(1)
-						}
-						f.Write(*img_data)
-						f.Close()
-						log.Println("Got data: ", output.Filename)
+	// data objects have the fields: Filename, Subfolder, Type
+	// * Subfolder is the subfolder in the output directory
+	// * Type is the type of the image temp/
+	for _, outputs := range result.Outputs {
+		for k, v := range outputs {
+			if k == "images" || k == "gifs" {
+				for _, output := range v {
+					img_data, err := cc.GetImage(output)
+					if err != nil {
+						log.Println("Failed to get image:", err)
+						os.Exit(1)
+					}
+					f, err := os.Create(output.Filename)
+					if err != nil {
+						log.Println("Failed to write image:", err)
+						os.Exit(1)
 					}
+					f.Write(*img_data)
+					f.Close()
+					log.Println("Got data: ", output.Filename)
 				}
 			}
 		}
@@ -3,7 +3,7 @@ module github.com/er1cw00/comfy.go
 go 1.21.5
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 )
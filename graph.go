@@ -3,10 +3,9 @@ package comfy
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/er1cw00/comfy.go/base/logger"
@@ -30,6 +29,37 @@ type Graph struct {
 	LinksByID             map[int]*Link      `json:"-"`
 	NodesInExecutionOrder []*GraphNode       `json:"-"`
 	HasErrors             bool               `json:"-"`
+	Models                []interface{}      `json:"models,omitempty"`
+	// ID, Revision, State, Config, Reroutes and FloatingLinks are only used by schema 1.0 workflows
+	ID            string                 `json:"id,omitempty"`
+	Revision      int                    `json:"revision,omitempty"`
+	State         *GraphState            `json:"state,omitempty"`
+	Config        map[string]interface{} `json:"config,omitempty"`
+	Reroutes      []*Reroute             `json:"reroutes,omitempty"`
+	FloatingLinks []*Link                `json:"floatingLinks,omitempty"`
+	// Extra holds the frontend's extra information, such as the definitions of group nodes
+	Extra map[string]interface{} `json:"extra,omitempty"`
+	// Definitions holds the subgraphs of the workflow
+	Definitions *GraphDefinitions `json:"definitions,omitempty"`
+	// DynamicPromptSource picks the choices of dynamic prompts in GraphToPrompt, math/rand when nil
+	DynamicPromptSource ChoiceSource `json:"-"`
+	rangePolicy         RangePolicy
+	nodeObjects         *NodeObjects
+	subgraphs           map[string]*Subgraph // the subgraphs by id, shared with the graphs of composite nodes
+}
+
+// NewGraph creates an empty graph whose nodes will be created from the node_objects
+func NewGraph(node_objects *NodeObjects) *Graph {
+	return &Graph{
+		Nodes:                 make([]*GraphNode, 0),
+		Links:                 make([]*Link, 0),
+		Groups:                make([]*Group, 0),
+		Version:               SchemaVersionLegacy,
+		NodesByID:             make(map[int]*GraphNode),
+		LinksByID:             make(map[int]*Link),
+		NodesInExecutionOrder: make([]*GraphNode, 0),
+		nodeObjects:           node_objects,
+	}
 }
 
 // GetGroupWithTitle returns the 'first' group with the given title
@@ -52,6 +82,7 @@ func (t *Graph) GetNodesInGroup(g *Group) []*GraphNode {
 	return retv
 }
 
+// UnmarshalJSON reads workflows of the legacy schema and of the schema 1.0, with links as arrays or objects
 func (t *Graph) UnmarshalJSON(b []byte) error {
 	// Create an alias type to avoid recursive call to UnmarshalJSON
 	type Alias Graph
@@ -69,9 +100,22 @@ func (t *Graph) UnmarshalJSON(b []byte) error {
 	t.LastNodeID = alias.LastNodeID
 	t.LastLinkID = alias.LastLinkID
 	t.Version = alias.Version
+	t.ID = alias.ID
+	t.Revision = alias.Revision
+	t.State = alias.State
+	t.Config = alias.Config
+	t.Reroutes = alias.Reroutes
+	t.FloatingLinks = alias.FloatingLinks
+	t.Models = alias.Models
+	t.Extra = alias.Extra
+	t.Definitions = alias.Definitions
 	t.NodesByID = make(map[int]*GraphNode)
 	t.LinksByID = make(map[int]*Link)
 
+	if err := t.resolveNodeIDs(); err != nil {
+		return err
+	}
+
 	for _, node := range t.Nodes {
 		// Populate the "by ID's"
 		t.NodesByID[node.ID] = node
@@ -83,10 +127,20 @@ func (t *Graph) UnmarshalJSON(b []byte) error {
 		t.LinksByID[link.ID] = link
 	}
 
-	// get the ordinality of nodes
-	t.NodesInExecutionOrder = make([]*GraphNode, len(t.Nodes))
-	copy(t.NodesInExecutionOrder, t.Nodes)
-	sort.Sort(ByGraphOrdinal(t.NodesInExecutionOrder))
+	// schema 1.0 workflows keep the last ids in the state
+	if t.State != nil {
+		if t.State.LastNodeID > t.LastNodeID {
+			t.LastNodeID = t.State.LastNodeID
+		}
+		if t.State.LastLinkID > t.LastLinkID {
+			t.LastLinkID = t.State.LastLinkID
+		}
+	}
+
+	// the order saved by the frontend may be stale, compute the execution order from the links
+	if err := t.UpdateExecutionOrder(); err != nil {
+		logger.Warnf("workflow execution order, err: %v", err)
+	}
 
 	return nil
 }
@@ -95,18 +149,33 @@ func duplicateProperty(prop Property) Property {
 	switch prop.TypeString() {
 	case "STRING":
 		np := *prop.(*StringProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
 		return &np
 	case "FLOAT":
 		np := *prop.(*FloatProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
 		return &np
 	case "COMBO":
 		np := *prop.(*ComboProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
 		return &np
 	case "INT":
 		np := *prop.(*IntProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
+		return &np
+	case "BOOLEAN":
+		np := *prop.(*BoolProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
 		return &np
 	case "UNKNOWN":
 		np := *prop.(*UnknownProperty)
+		np.UpdateParent(&np)
+		np.secondaries = nil
 		return &np
 	}
 	logger.Warn("Cannot duplicate property of unknown type")
@@ -134,55 +203,14 @@ func (t *Graph) CreateNodeProperties(node_objects *NodeObjects) *[]string {
 	// had thier properties created
 	primitives := make([]*GraphNode, 0)
 	var retv *[]string = nil
+	t.nodeObjects = node_objects
 	for _, n := range t.Nodes {
-		pindex := 0
-
-		// random numbers seem to have an additional widget added in widget.js addValueControlWidget @ln 15
-		// when an INT widget is created with either the name "seed" or "noise_seed", the additional
-		// widget is added directly after.
-		// it is a COMBO called "control_after_generate" with one of:
-		// 	fixed
-		//	increment
-		//	decrement
-		// 	randomize
-
 		// create a new map to hold the properties by name
 		n.Properties = make(map[string]Property)
 		nobject := node_objects.GetNodeObjectByName(n.Type)
 
 		if nobject != nil {
-			// get the display name and description
-			n.DisplayName = nobject.DisplayName
-			n.Description = nobject.Description
-
-			// is this node an output node?
-			n.IsOutput = nobject.OutputNode
-
-			// get the settable properties and associate them with correct widgets
-			props := nobject.GetSettableProperties()
-			t.ProcessSettableProperties(n, &props, &pindex)
-
-			// check if the number of properties is the same as the number of widget values
-			if n.WidgetValueCount() != len(props) {
-				// If the count of WidgetValues is not the same as props there may be potential issues
-				// which may arrise here if not handled properly.  An example is LoadImage and LoadImageMask where
-				// there is a widget "choose file to upload" whose field points to the
-				// property that the upload would be set to.  This widget is added in web/extensions/core/uploadImage.js
-				if nobject.Name == "LoadImage" || nobject.Name == "LoadImageMask" {
-					// create an imageuploader property and point to it's associated COMBO property
-					targetProp := n.GetPropertyWithName("image")
-					if targetProp != nil {
-						np := newImageUploadProperty("choose file to upload", targetProp.(*ComboProperty), len(n.Properties))
-						// set the alias to "file"
-						(*np).SetAlias("file")
-						n.Properties["choose file to upload"] = *np
-					} else {
-						logger.Error("Cannot find \"image\" property")
-					}
-				} else {
-					logger.Debugf("size missmatch for node type: %v", n.Type)
-				}
-			}
+			t.createNodeObjectProperties(n, nobject)
 		} else {
 			if n.Type == "PrimitiveNode" {
 				primitives = append(primitives, n)
@@ -197,6 +225,20 @@ func (t *Graph) CreateNodeProperties(node_objects *NodeObjects) *[]string {
 			} else if n.Type == "Reroute" {
 				// skip Reroute
 				continue
+			} else if composite, missing := t.createCompositeNodeProperties(n, node_objects); composite {
+				// the missing node types are those of the inner nodes
+				if missing != nil {
+					for _, m := range *missing {
+						if retv == nil {
+							r := make([]string, 0)
+							retv = &r
+						}
+						if !containsString(retv, m) {
+							r := append(*retv, m)
+							retv = &r
+						}
+					}
+				}
 			} else {
 				logger.Errorf("Could not get node object for node type(%s), id(%d), title(%s)", n.Type, n.ID, n.Title)
 				if retv == nil {
@@ -213,53 +255,81 @@ func (t *Graph) CreateNodeProperties(node_objects *NodeObjects) *[]string {
 
 	// process primitives
 	// Can a primitive?:
-	// 		Connect to reroute: 					Nope (thank god)
+	// 		Connect to reroute: 					Yes, the reroutes are followed to the widgets
 	//		Connect combo to two different types: 	Nope
 	for _, primitive_node := range primitives {
-		for _, primitive_node_output := range primitive_node.Outputs {
-			// For outputs, we need to contend with multiple links.
-			// Go through each output, get the link, then the target node,
-			// then the target property of that node.
-			if primitive_node_output.Links != nil && len(*primitive_node_output.Links) != 0 {
-				// we'll use the type and value of primitive_node_output.Links[0].  I'll assume that.
-				// the link IDs are ordered and [0] would be the first on linked
-				var first_property Property
-				pindex := 0
-				for _, l := range *primitive_node_output.Links {
-					primitive_node_output_link := t.GetLinkById(l)
-					if primitive_node_output_link != nil {
-						// get the target node
-						target_node := t.GetNodeById(primitive_node_output_link.TargetID)
-						if target_node != nil {
-							if first_property == nil {
-								first_property = target_node.Inputs[primitive_node_output_link.TargetSlot].Property
-								if first_property == nil {
-									logger.Warnf("Could not get primitive target slot property %s for node %s", target_node.Inputs[primitive_node_output_link.TargetSlot].Name, target_node.Title)
-									continue
-								}
-								// copy the property and assign it the node's "value" property
-								np := duplicateProperty(first_property)
-								np.SetIndex(pindex)
-								primitive_node.Properties["value"] = np
-							} else {
-								// copy the property and add the node's "value" property as a secondary
-								p := target_node.Inputs[primitive_node_output_link.TargetSlot].Property
-								if p != nil {
-									newp := duplicateProperty(p)
-									newp.SetIndex(pindex)
-									primitive_node.Properties["value"].AttachSecondaryProperty(newp)
-								}
-							}
-						}
-					}
-					pindex++
+		// the targets of the primitive, through any Reroutes
+		var first_property Property
+		for pindex, l := range primitive_node.GetLinks() {
+			primitive_node_output_link := t.GetLinkById(l)
+			target_node := t.GetNodeById(primitive_node_output_link.TargetID)
+			if target_node == nil || primitive_node_output_link.TargetSlot >= len(target_node.Inputs) {
+				continue
+			}
+			p := target_node.Inputs[primitive_node_output_link.TargetSlot].Property
+			if p == nil {
+				logger.Warnf("Could not get primitive target slot property %s for node %s", target_node.Inputs[primitive_node_output_link.TargetSlot].Name, target_node.Title)
+				continue
+			}
+			if first_property == nil {
+				// copy the property and point it to the primitive's own widget, the value
+				// is copied to the targets by ApplyToGraph
+				first_property = p
+				if !primitive_node.IsWidgetValueArray() || len(primitive_node.WidgetValuesArray()) == 0 {
+					primitive_node.WidgetValues = []interface{}{p.GetValue()}
 				}
+				np := duplicateProperty(first_property)
+				if np == nil {
+					break
+				}
+				np.SetTargetWidget(primitive_node, 0)
+				np.SetIndex(0)
+				primitive_node.Properties["value"] = np
+			}
+			// setting the value of the primitive also sets the targets right away
+			if newp := duplicateProperty(p); newp != nil {
+				newp.SetIndex(pindex)
+				primitive_node.Properties["value"].AttachSecondaryProperty(newp)
 			}
 		}
 	}
 	return retv
 }
 
+// createNodeObjectProperties creates the properties of a node from the node object describing its type
+func (t *Graph) createNodeObjectProperties(n *GraphNode, nobject *NodeObject) {
+	pindex := 0
+
+	// random numbers seem to have an additional widget added in widget.js addValueControlWidget @ln 15
+	// when an INT widget is created with either the name "seed" or "noise_seed", the additional
+	// widget is added directly after.
+	// it is a COMBO called "control_after_generate" with one of:
+	// 	fixed
+	//	increment
+	//	decrement
+	// 	randomize
+
+	// get the display name and description
+	n.DisplayName = nobject.DisplayName
+	n.Description = nobject.Description
+
+	// is this node an output node?
+	n.IsOutput = nobject.OutputNode
+
+	// get the settable properties and associate them with correct widgets
+	props := nobject.GetSettableProperties()
+	t.ProcessSettableProperties(n, &props, &pindex)
+
+	// the frontend adds an upload widget to combos with an upload flag, like the "image" of LoadImage, see
+	// web/extensions/core/uploadImage.js.  Its widget value makes the number of widget values differ from props.
+	uploads := addUploadProperties(n.Properties, props)
+
+	// check if the number of properties is the same as the number of widget values
+	if n.WidgetValueCount() != len(props) && !uploads {
+		logger.Debugf("size missmatch for node type: %v", n.Type)
+	}
+}
+
 func (t *Graph) ProcessSettableProperties(n *GraphNode, props *[]Property, pindex *int) {
 	for _, prop := range *props {
 		// convert to actual property type, deep copy
@@ -275,6 +345,7 @@ func (t *Graph) ProcessSettableProperties(n *GraphNode, props *[]Property, pinde
 			n.affixPropertyToInputSlot(prop.Name(), &np)
 		case "FLOAT":
 			np := *prop.(*FloatProperty)
+			np.RangePolicy = t.rangePolicy
 			np.UpdateParent(&np)
 			np.SetTargetWidget(n, *pindex)
 			*pindex++
@@ -289,6 +360,7 @@ func (t *Graph) ProcessSettableProperties(n *GraphNode, props *[]Property, pinde
 			n.affixPropertyToInputSlot(prop.Name(), &np)
 		case "INT":
 			np := *prop.(*IntProperty)
+			np.RangePolicy = t.rangePolicy
 			np.UpdateParent(&np)
 			np.SetTargetWidget(n, *pindex)
 			*pindex++
@@ -346,6 +418,33 @@ func (t *Graph) ProcessSettableProperties(n *GraphNode, props *[]Property, pinde
 	}
 }
 
+// SetRangePolicy sets the RangePolicy of the INT and FLOAT properties of the graph, including those of
+// the nodes of group nodes and subgraphs, and of the nodes added later on.  The default is RangePolicyReject.
+func (t *Graph) SetRangePolicy(policy RangePolicy) {
+	t.setRangePolicy(policy, make(map[*Graph]bool))
+}
+
+func (t *Graph) setRangePolicy(policy RangePolicy, visited map[*Graph]bool) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	t.rangePolicy = policy
+	for _, n := range t.Nodes {
+		if n.IsComposite() {
+			n.InnerGraph.setRangePolicy(policy, visited)
+		}
+		for _, prop := range n.Properties {
+			switch p := prop.(type) {
+			case *IntProperty:
+				p.RangePolicy = policy
+			case *FloatProperty:
+				p.RangePolicy = policy
+			}
+		}
+	}
+}
+
 func (t *Graph) GetLinkById(id int) *Link {
 	val, ok := t.LinksByID[id]
 	if ok {
@@ -474,62 +573,96 @@ func (t *Graph) SaveGraphToFile(path string) error {
 	return nil
 }
 
+// GraphToPrompt creates the prompt that is queued to ComfyUI for the graph.  The inner nodes of group
+// nodes and subgraphs are added to the prompt's InnerNodes, with the ids of the nodes containing them
+// as prefix, e.g. "12:3" for node 3 of the subgraph of node 12.
 func (t *Graph) GraphToPrompt(clientID string) (Prompt, error) {
 	p := Prompt{
 		ClientID: clientID,
-		Nodes:    make(map[int]PromptNode),
+		Nodes:    make(map[int]*PromptNode),
 		// PID:      "floopy-thingy-ma-bob", // we can add additionl information that is ignored by ComfyUI
 	}
-	for _, node := range t.NodesInExecutionOrder {
-		if node.IsVirtual() {
-			// Don't serialize frontend only nodes but let them make changes
-			node.ApplyToGraph()
-			continue
-		}
+	if err := (&promptScope{graph: t, choices: t.DynamicPromptSource}).serialize(&p); err != nil {
+		return p, err
+	}
+	// assign our current graph as the workflow
+	p.ExtraData.PngInfo.Workflow = t
+	return p, nil
+}
 
-		if node.Mode == 2 {
-			// Don't serialize muted nodes
-			continue
-		}
+// resolveInputLink returns the link that provides the value of the input slot of the node when the prompt is executed.
+// Links from Reroutes are followed to the node feeding them, and links from bypassed nodes are followed to the input
+// of the bypassed node with the same type as the slot, trying the input at the index of the bypassed output first,
+// like the frontend does.  nil is returned when the input does not receive a value from a node, such as a widget
+// set by a PrimitiveNode or an input linked to a muted node.  An error is returned when a Reroute does not lead to a node.
+func (t *Graph) resolveInputLink(node *GraphNode, slotIndex int) (*Link, error) {
+	if slotIndex >= len(node.Inputs) {
+		return nil, nil
+	}
+	input := node.Inputs[slotIndex]
+	return t.followLink(node.GetInputLink(slotIndex), input.Type,
+		fmt.Sprintf("input %s of node %d (%s)", input.Name, node.ID, node.Type))
+}
 
-		// create the prompt node
-		pn := PromptNode{
-			ClassType: node.Type,
-			Inputs:    make(map[string]interface{}),
+// followLink follows the link through frontend only and bypassed nodes for an input of the given type,
+// see resolveInputLink.  Links from the input of a subgraph are returned as they are.
+func (t *Graph) followLink(link *Link, inputType string, what string) (*Link, error) {
+	visited := make(map[int]bool)
+	for link != nil {
+		if link.OriginID == subgraphInputNodeID {
+			return link, nil
 		}
-
-		// populate the node input values
-		for k, prop := range node.Properties {
-			if prop.Serializable() {
-				pn.Inputs[k] = prop.GetValue()
-			}
+		parent := t.GetNodeById(link.OriginID)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %s is linked to missing node %d", ErrVirtualNodeDeadEnd, what, link.OriginID)
 		}
-
-		// populate the node input links
-		for i, slot := range node.Inputs {
-			parent := node.GetNodeForInput(i)
-			if parent != nil {
-				link := t.GetLinkById(slot.Link)
-				for parent != nil && parent.IsVirtual() {
-					link = parent.GetInputLink(link.OriginSlot)
-					if link != nil {
-						parent = parent.GetNodeForInput(link.OriginSlot)
-					} else {
-						break
-					}
-				}
-
-				if link != nil {
-					linfo := make([]interface{}, 2)
-					linfo[0] = strconv.Itoa(link.OriginID)
-					linfo[1] = link.OriginSlot
-					pn.Inputs[node.Inputs[i].Name] = linfo
+		if visited[parent.ID] {
+			return nil, fmt.Errorf("%w: %s is linked through a cycle of node %d", ErrGraphCycle, what, parent.ID)
+		}
+		visited[parent.ID] = true
+
+		switch {
+		case parent.Type == "PrimitiveNode":
+			// the value of the primitive is applied to the widget of the input
+			return nil, nil
+		case parent.IsMuted():
+			// like the frontend, inputs linked to muted nodes are not sent
+			return nil, nil
+		case parent.IsVirtual():
+			next := parent.GetInputLink(link.OriginSlot)
+			if next == nil {
+				return nil, fmt.Errorf("%w: %s is linked to %s %d, which has no input", ErrVirtualNodeDeadEnd, what, parent.Type, parent.ID)
+			}
+			link = next
+		case parent.IsBypassed():
+			candidates := []int{link.OriginSlot}
+			for i := range parent.Inputs {
+				candidates = append(candidates, i)
+			}
+			found := false
+			for _, i := range candidates {
+				if i < len(parent.Inputs) && parent.Inputs[i].Type == inputType {
+					link = parent.GetInputLink(i)
+					found = true
+					break
 				}
 			}
+			if !found {
+				return nil, nil
+			}
+		default:
+			return link, nil
 		}
-		p.Nodes[node.ID] = pn
 	}
-	// assign our current graph as the workflow
-	p.ExtraData.PngInfo.Workflow = t
-	return p, nil
+	return nil, nil
+}
+
+// SetGroupMode sets the mode of every node in the group, e.g. to bypass an optional stage of a workflow
+func (t *Graph) SetGroupMode(g *Group, mode int) error {
+	for _, n := range t.GetNodesInGroup(g) {
+		if err := n.SetMode(mode); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,361 @@
+package comfy
+
+import (
+	"encoding/json"
+
+	"github.com/er1cw00/comfy.go/base/logger"
+)
+
+// Clone returns a deep copy of the graph whose properties are bound to the copied nodes, so that setting
+// values on the copy does not change the graph.  A workflow can be loaded once and cloned for every variant
+// that is queued, also from several goroutines, as long as the graph itself is not changed meanwhile.
+func (t *Graph) Clone() *Graph {
+	c := &graphCloner{
+		graphs:    make(map[*Graph]*Graph),
+		subgraphs: make(map[*Subgraph]*Subgraph),
+		nodes:     make(map[*GraphNode]*GraphNode),
+		values:    make(map[*interface{}]*interface{}),
+		props:     make(map[Property]Property),
+	}
+	retv := c.graph(t)
+	// the properties are bound once every node is copied, they may target nodes of other graphs
+	for old, n := range c.nodes {
+		c.bindNode(old, n)
+	}
+	return retv
+}
+
+// graphCloner keeps what was copied by Graph.Clone, so that references are copied only once
+type graphCloner struct {
+	graphs    map[*Graph]*Graph
+	subgraphs map[*Subgraph]*Subgraph
+	nodes     map[*GraphNode]*GraphNode
+	values    map[*interface{}]*interface{} // the widget values, by address
+	props     map[Property]Property
+}
+
+func (c *graphCloner) graph(g *Graph) *Graph {
+	if g == nil {
+		return nil
+	}
+	if retv, ok := c.graphs[g]; ok {
+		return retv
+	}
+	retv := &Graph{
+		Nodes:                 make([]*GraphNode, 0, len(g.Nodes)),
+		LastNodeID:            g.LastNodeID,
+		LastLinkID:            g.LastLinkID,
+		Version:               g.Version,
+		NodesByID:             make(map[int]*GraphNode, len(g.Nodes)),
+		LinksByID:             make(map[int]*Link, len(g.Links)),
+		NodesInExecutionOrder: make([]*GraphNode, 0, len(g.NodesInExecutionOrder)),
+		HasErrors:             g.HasErrors,
+		ID:                    g.ID,
+		Revision:              g.Revision,
+		DynamicPromptSource:   g.DynamicPromptSource,
+		rangePolicy:           g.rangePolicy,
+		nodeObjects:           g.nodeObjects,
+	}
+	c.graphs[g] = retv
+
+	if g.Links != nil {
+		retv.Links = make([]*Link, 0, len(g.Links))
+		for _, l := range g.Links {
+			nl := *l
+			retv.Links = append(retv.Links, &nl)
+			retv.LinksByID[nl.ID] = &nl
+		}
+	}
+	for _, l := range g.FloatingLinks {
+		nl := *l
+		retv.FloatingLinks = append(retv.FloatingLinks, &nl)
+	}
+	if g.Groups != nil {
+		retv.Groups = make([]*Group, 0, len(g.Groups))
+		for _, gr := range g.Groups {
+			ng := *gr
+			ng.Bounding = append([]float64(nil), gr.Bounding...)
+			ng.Flags = copyValueMap(gr.Flags)
+			retv.Groups = append(retv.Groups, &ng)
+		}
+	}
+	if g.State != nil {
+		state := *g.State
+		retv.State = &state
+	}
+	for _, r := range g.Reroutes {
+		nr := *r
+		nr.Pos = append([]float64(nil), r.Pos...)
+		nr.LinkIDs = append([]int(nil), r.LinkIDs...)
+		nr.Floating = copyValueMap(r.Floating)
+		retv.Reroutes = append(retv.Reroutes, &nr)
+	}
+	retv.Config = copyValueMap(g.Config)
+	retv.Extra = copyValueMap(g.Extra)
+	if g.Models != nil {
+		retv.Models = copyValue(g.Models).([]interface{})
+	}
+	if g.Definitions != nil {
+		retv.Definitions = &GraphDefinitions{}
+		for _, sg := range g.Definitions.Subgraphs {
+			retv.Definitions.Subgraphs = append(retv.Definitions.Subgraphs, c.subgraph(sg))
+		}
+	}
+	if g.subgraphs != nil {
+		retv.subgraphs = make(map[string]*Subgraph, len(g.subgraphs))
+		for id, sg := range g.subgraphs {
+			retv.subgraphs[id] = c.subgraph(sg)
+		}
+	}
+
+	for _, n := range g.Nodes {
+		nn := c.node(n, retv)
+		retv.Nodes = append(retv.Nodes, nn)
+		retv.NodesByID[nn.ID] = nn
+	}
+	for _, n := range g.NodesInExecutionOrder {
+		if nn, ok := c.nodes[n]; ok {
+			retv.NodesInExecutionOrder = append(retv.NodesInExecutionOrder, nn)
+		}
+	}
+	return retv
+}
+
+func (c *graphCloner) subgraph(sg *Subgraph) *Subgraph {
+	if sg == nil {
+		return nil
+	}
+	if retv, ok := c.subgraphs[sg]; ok {
+		return retv
+	}
+	retv := &Subgraph{
+		ID:   sg.ID,
+		Name: sg.Name,
+	}
+	c.subgraphs[sg] = retv
+	retv.Inputs = copyPorts(sg.Inputs)
+	retv.Outputs = copyPorts(sg.Outputs)
+	if sg.raw != nil {
+		// the raw messages are not changed, only replaced
+		retv.raw = make(map[string]json.RawMessage, len(sg.raw))
+		for k, v := range sg.raw {
+			retv.raw[k] = v
+		}
+	}
+	retv.Graph = c.graph(sg.Graph)
+	return retv
+}
+
+func copyPorts(ports []SubgraphPort) []SubgraphPort {
+	if ports == nil {
+		return nil
+	}
+	retv := make([]SubgraphPort, len(ports))
+	for i, p := range ports {
+		retv[i] = p
+		retv[i].LinkIDs = append([]int(nil), p.LinkIDs...)
+	}
+	return retv
+}
+
+// node copies the node without its properties, which are copied by bindNode
+func (c *graphCloner) node(n *GraphNode, g *Graph) *GraphNode {
+	retv := *n
+	retv.Graph = g
+	retv.Position = copyValue(n.Position)
+	if n.Flags != nil {
+		flags := copyValue(*n.Flags)
+		retv.Flags = &flags
+	}
+	if n.InternalProperties != nil {
+		props := copyValueMap(*n.InternalProperties)
+		retv.InternalProperties = &props
+	}
+	if n.CustomData != nil {
+		data := copyValue(*n.CustomData)
+		retv.CustomData = &data
+	}
+	retv.WidgetValues = c.widgetValues(n.WidgetValues)
+	retv.Inputs = copySlots(n.Inputs)
+	retv.Outputs = copySlots(n.Outputs)
+	if n.Widgets != nil {
+		retv.Widgets = make([]*Widget, 0, len(n.Widgets))
+		for _, w := range n.Widgets {
+			retv.Widgets = append(retv.Widgets, copyWidget(w))
+		}
+	}
+	retv.Properties = nil
+	retv.InnerGraph = c.graph(n.InnerGraph)
+	c.nodes[n] = &retv
+	return &retv
+}
+
+// widgetValues copies the widget values, and remembers where each value of an array was copied to
+func (c *graphCloner) widgetValues(v interface{}) interface{} {
+	values, ok := v.([]interface{})
+	if !ok {
+		return copyValue(v)
+	}
+	retv := make([]interface{}, len(values))
+	for i := range values {
+		retv[i] = copyValue(values[i])
+		c.values[&values[i]] = &retv[i]
+	}
+	return retv
+}
+
+func copySlots(slots []Slot) []Slot {
+	if slots == nil {
+		return nil
+	}
+	retv := make([]Slot, len(slots))
+	for i, s := range slots {
+		retv[i] = s
+		if s.Links != nil {
+			links := append([]int{}, *s.Links...)
+			retv[i].Links = &links
+		}
+		retv[i].Widget = copyWidget(s.Widget)
+		if s.Shape != nil {
+			shape := *s.Shape
+			retv[i].Shape = &shape
+		}
+		if s.SlotIndex != nil {
+			index := *s.SlotIndex
+			retv[i].SlotIndex = &index
+		}
+	}
+	return retv
+}
+
+func copyWidget(w *Widget) *Widget {
+	if w == nil {
+		return nil
+	}
+	retv := &Widget{}
+	if w.Name != nil {
+		name := *w.Name
+		retv.Name = &name
+	}
+	if w.Config != nil {
+		config := copyValue(*w.Config)
+		retv.Config = &config
+	}
+	return retv
+}
+
+// bindNode copies the properties of the node to its copy, and points the slots to the copies
+func (c *graphCloner) bindNode(old *GraphNode, n *GraphNode) {
+	if old.Properties != nil {
+		n.Properties = make(map[string]Property, len(old.Properties))
+		for k, p := range old.Properties {
+			n.Properties[k] = c.property(p)
+		}
+	}
+	for i := range n.Inputs {
+		n.Inputs[i].Node = c.nodeOf(old.Inputs[i].Node)
+		n.Inputs[i].Property = c.property(old.Inputs[i].Property)
+	}
+	for i := range n.Outputs {
+		n.Outputs[i].Node = c.nodeOf(old.Outputs[i].Node)
+		n.Outputs[i].Property = c.property(old.Outputs[i].Property)
+	}
+}
+
+// nodeOf returns the copy of the node, or the node itself when it is not part of the cloned graph
+func (c *graphCloner) nodeOf(n *GraphNode) *GraphNode {
+	if retv, ok := c.nodes[n]; ok {
+		return retv
+	}
+	return n
+}
+
+// property copies the property, pointing it to the copies of its target node, widget value and secondaries
+func (c *graphCloner) property(p Property) Property {
+	if p == nil {
+		return nil
+	}
+	if retv, ok := c.props[p]; ok {
+		return retv
+	}
+
+	var retv Property
+	var base *BaseProperty
+	switch v := p.(type) {
+	case *BoolProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *IntProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *FloatProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *StringProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *ComboProperty:
+		np := *v
+		// uploaded files are appended to the values
+		np.Values = append([]string(nil), v.Values...)
+		retv, base = &np, &np.BaseProperty
+	case *CascadingProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *ImageUploadProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	case *UnknownProperty:
+		np := *v
+		retv, base = &np, &np.BaseProperty
+	default:
+		logger.Warnf("Cannot clone property of type %T", p)
+		return p
+	}
+	c.props[p] = retv
+
+	base.UpdateParent(retv)
+	base.target_node = c.nodeOf(base.target_node)
+	if base.direct_value != nil {
+		if dv, ok := c.values[base.direct_value]; ok {
+			base.direct_value = dv
+		}
+	}
+	if base.secondaries != nil {
+		secondaries := make([]Property, 0, len(base.secondaries))
+		for _, s := range base.secondaries {
+			secondaries = append(secondaries, c.property(s))
+		}
+		base.secondaries = secondaries
+	}
+	if iu, ok := retv.(*ImageUploadProperty); ok && iu.TargetProperty != nil {
+		iu.TargetProperty, _ = c.property(iu.TargetProperty).(*ComboProperty)
+	}
+	return retv
+}
+
+// copyValue copies a value decoded from JSON, with its arrays and maps
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		retv := make([]interface{}, len(val))
+		for i := range val {
+			retv[i] = copyValue(val[i])
+		}
+		return retv
+	case map[string]interface{}:
+		return copyValueMap(val)
+	}
+	return v
+}
+
+func copyValueMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	retv := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		retv[k] = copyValue(v)
+	}
+	return retv
+}
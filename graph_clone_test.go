@@ -0,0 +1,100 @@
+package comfy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+func TestClone(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	clone := graph.Clone()
+
+	ks := clone.GetNodeById(3)
+	if ks == nil || ks == graph.GetNodeById(3) || ks.Graph != clone {
+		t.Fatal("the nodes are not copied into the clone")
+	}
+	if err := ks.GetPropertyWithName("steps").SetValue(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.GetNodeById(6).GetPropertyWithName("text").SetValue("a dog"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.Connect(clone.GetNodeById(4), "CLIP", clone.GetNodeById(7), "clip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.RemoveNode(clone.GetNodeById(9)); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := graph.GetNodeById(3).GetPropertyWithName("steps").GetValue(); fmt.Sprint(v) != "20" {
+		t.Errorf("steps of the graph = %v, want 20", v)
+	}
+	if v := graph.GetNodeById(6).GetPropertyWithName("text").GetValue(); v != "a cat" {
+		t.Errorf("text of the graph = %v, want a cat", v)
+	}
+	if graph.GetNodeById(9) == nil || len(graph.Links) != 9 || graph.LastLinkID != 9 {
+		t.Error("editing the clone changed the nodes or links of the graph")
+	}
+
+	p, err := clone.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inputValue(p, 3, "steps"); got != "5" {
+		t.Errorf("steps sent by the clone = %s, want 5", got)
+	}
+	if group := clone.GetGroupWithTitle("API"); group == nil || len(clone.GetNodesInGroup(group)) != 2 {
+		t.Error("the groups are not copied")
+	}
+}
+
+func TestCloneComposite(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "composite.json")
+	clone := graph.Clone()
+
+	group := clone.GetNodeById(2)
+	if group.InnerGraph == nil || group.InnerGraph == graph.GetNodeById(2).InnerGraph {
+		t.Fatal("the inner graph of the group node is not copied")
+	}
+	inner := group.InnerGraph.Nodes[0]
+	if err := inner.GetPropertyWithName("text").SetValue("a dog"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := clone.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := before.InnerNodes["2:0"].Inputs["text"]; got != "a cat" {
+		t.Errorf("text of the graph = %v, want a cat", got)
+	}
+	if got := after.InnerNodes["2:0"].Inputs["text"]; got != "a dog" {
+		t.Errorf("text of the clone = %v, want a dog", got)
+	}
+}
+
+func TestCloneNote(t *testing.T) {
+	graph, _, err := NewGraphFromJsonString(`{"last_node_id":1,"last_link_id":0,"nodes":[
+		{"id":1,"type":"Note","pos":[0,0],"size":[200,60],"flags":{},"order":0,"mode":0,"properties":{},"widgets_values":["a note"]}
+	],"links":[],"groups":[],"config":{},"extra":{},"version":0.4}`, testNodeObjects(t, comfytest.DefaultObjectInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := graph.Clone()
+
+	if err := clone.GetNodeById(1).GetPropertyWithName("text").SetValue("another note"); err != nil {
+		t.Fatal(err)
+	}
+	if v := graph.GetNodeById(1).WidgetValuesArray()[0]; v != "a note" {
+		t.Errorf("text of the graph's note = %v, want a note", v)
+	}
+	if v := clone.GetNodeById(1).WidgetValuesArray()[0]; v != "another note" {
+		t.Errorf("widget of the clone's note = %v, want another note", v)
+	}
+}
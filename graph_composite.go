@@ -0,0 +1,545 @@
+package comfy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/er1cw00/comfy.go/base/logger"
+)
+
+// the ids the links of a subgraph use for the inputs and outputs of the node containing it
+const (
+	subgraphInputNodeID  = -10
+	subgraphOutputNodeID = -20
+)
+
+// GraphDefinitions holds the definitions shared by the nodes of a workflow
+type GraphDefinitions struct {
+	Subgraphs []*Subgraph `json:"subgraphs"`
+}
+
+// SubgraphPort is an input or output of a subgraph
+type SubgraphPort struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	LinkIDs []int  `json:"linkIds"`
+}
+
+// Subgraph is the definition of a subgraph.  Nodes whose type is the id of a subgraph are expanded into
+// the nodes of the subgraph when the prompt is created.  Like in the frontend, every node using the
+// subgraph shares the nodes of the definition, and so the values of their widgets.
+type Subgraph struct {
+	ID      string
+	Name    string
+	Inputs  []SubgraphPort
+	Outputs []SubgraphPort
+	Graph   *Graph
+	raw     map[string]json.RawMessage // the fields of the definition that are kept as they are
+}
+
+func (s *Subgraph) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &s.raw); err != nil {
+		return err
+	}
+	var header struct {
+		ID      string         `json:"id"`
+		Name    string         `json:"name"`
+		Inputs  []SubgraphPort `json:"inputs"`
+		Outputs []SubgraphPort `json:"outputs"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return err
+	}
+	s.ID = header.ID
+	s.Name = header.Name
+	s.Inputs = header.Inputs
+	s.Outputs = header.Outputs
+	s.Graph = &Graph{}
+	return json.Unmarshal(b, s.Graph)
+}
+
+func (s *Subgraph) MarshalJSON() ([]byte, error) {
+	tmp := make(map[string]interface{}, len(s.raw)+6)
+	for k, v := range s.raw {
+		tmp[k] = v
+	}
+	tmp["id"] = s.ID
+	tmp["name"] = s.Name
+	if _, ok := s.raw["inputs"]; !ok {
+		tmp["inputs"] = s.Inputs
+	}
+	if _, ok := s.raw["outputs"]; !ok {
+		tmp["outputs"] = s.Outputs
+	}
+	if s.Graph != nil {
+		tmp["nodes"] = s.Graph.Nodes
+		links := make([]linkObject, 0, len(s.Graph.Links))
+		for _, l := range s.Graph.Links {
+			links = append(links, l.toObject(s.Graph))
+		}
+		tmp["links"] = links
+	}
+	return json.Marshal(tmp)
+}
+
+// groupNodeDefinition is the definition of a group node saved by the frontend in extra.groupNodes
+type groupNodeDefinition struct {
+	Nodes []json.RawMessage `json:"nodes"`
+	// links are [origin index, origin slot, target index, target slot, origin id, type]
+	Links [][]interface{} `json:"links"`
+	// external are [node index, output slot, type] of outputs also linked outside of the group
+	External [][]interface{} `json:"external"`
+}
+
+// IsComposite returns true if the node is a group node or a subgraph, whose inner nodes are sent to
+// ComfyUI in its place
+func (n *GraphNode) IsComposite() bool {
+	return n.InnerGraph != nil
+}
+
+// groupNodeName returns the name of the group node definition of a node type such as "workflow>MyGroup"
+func groupNodeName(nodeType string) (string, bool) {
+	for _, prefix := range []string{"workflow>", "workflow/"} {
+		if strings.HasPrefix(nodeType, prefix) {
+			return nodeType[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// getSubgraph returns the definition of the subgraph with the given id, or nil
+func (t *Graph) getSubgraph(id string) *Subgraph {
+	if t.subgraphs == nil {
+		t.subgraphs = make(map[string]*Subgraph)
+		if t.Definitions != nil {
+			for _, sg := range t.Definitions.Subgraphs {
+				t.subgraphs[sg.ID] = sg
+			}
+		}
+	}
+	return t.subgraphs[id]
+}
+
+// getGroupNodeDefinition returns the definition of the group node with the given name, or nil
+func (t *Graph) getGroupNodeDefinition(name string) (*groupNodeDefinition, error) {
+	groupNodes, ok := t.Extra["groupNodes"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	raw, ok := groupNodes[name]
+	if !ok {
+		return nil, nil
+	}
+	def := &groupNodeDefinition{}
+	if err := remarshal(raw, def); err != nil {
+		return nil, fmt.Errorf("invalid definition of group node %s: %w", name, err)
+	}
+	return def, nil
+}
+
+// createCompositeNodeProperties expands a node whose type is a subgraph or a group node into its inner
+// graph, and creates the properties of the inner nodes.  Returns false if the node is not composite.
+func (t *Graph) createCompositeNodeProperties(n *GraphNode, node_objects *NodeObjects) (bool, *[]string) {
+	if sg := t.getSubgraph(n.Type); sg != nil && sg.Graph != nil {
+		inner := sg.Graph
+		var missing *[]string
+		// the definition is shared by every node using it, its properties are created once
+		if inner.nodeObjects == nil {
+			inner.subgraphs = t.subgraphs
+			missing = inner.CreateNodeProperties(node_objects)
+		}
+		n.InnerGraph = inner
+		if n.DisplayName == "" {
+			n.DisplayName = sg.Name
+		}
+		t.addInnerProperties(n, inner.settableProperties())
+		return true, missing
+	}
+
+	name, ok := groupNodeName(n.Type)
+	if !ok {
+		return false, nil
+	}
+	def, err := t.getGroupNodeDefinition(name)
+	if err != nil {
+		logger.Errorf("Could not expand group node %d: %v", n.ID, err)
+		return false, nil
+	}
+	if def == nil {
+		return false, nil
+	}
+	inner, err := t.newGroupNodeGraph(def)
+	if err != nil {
+		logger.Errorf("Could not expand group node %d: %v", n.ID, err)
+		return false, nil
+	}
+	missing := inner.CreateNodeProperties(node_objects)
+	n.InnerGraph = inner
+	if n.DisplayName == "" {
+		n.DisplayName = name
+	}
+	t.bindGroupNode(n, inner, def)
+	inner.updateExecutionOrder()
+	return true, missing
+}
+
+// newGroupNodeGraph creates the inner graph of a group node from its definition.  Every group node gets
+// its own graph, in which the nodes have the index in the definition as id.
+func (t *Graph) newGroupNodeGraph(def *groupNodeDefinition) (*Graph, error) {
+	inner := NewGraph(nil)
+	inner.Extra = t.Extra
+	inner.subgraphs = t.subgraphs
+	for i, raw := range def.Nodes {
+		node := &GraphNode{}
+		if err := json.Unmarshal(raw, node); err != nil {
+			return nil, err
+		}
+		var index struct {
+			Index *int `json:"index"`
+		}
+		node.ID = i
+		if err := json.Unmarshal(raw, &index); err == nil && index.Index != nil {
+			node.ID = *index.Index
+		}
+		node.Graph = inner
+		for j := range node.Inputs {
+			node.Inputs[j].Link = 0
+		}
+		for j := range node.Outputs {
+			node.Outputs[j].Links = &[]int{}
+		}
+		inner.Nodes = append(inner.Nodes, node)
+		inner.NodesByID[node.ID] = node
+		if node.ID > inner.LastNodeID {
+			inner.LastNodeID = node.ID
+		}
+	}
+
+	for _, l := range def.Links {
+		if len(l) < 4 {
+			continue
+		}
+		var ends [4]int
+		valid := true
+		for i := range ends {
+			f, ok := numberValue(l[i])
+			if !ok {
+				valid = false
+				break
+			}
+			ends[i] = int(f)
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid link %v", l)
+		}
+		link := &Link{ID: inner.LastLinkID + 1, OriginID: ends[0], OriginSlot: ends[1], TargetID: ends[2], TargetSlot: ends[3]}
+		if len(l) > 5 {
+			link.Type, _ = l[5].(string)
+		}
+		inner.attachLink(link)
+	}
+	return inner, nil
+}
+
+// attachLink adds the link to the graph and to the slots of the nodes it connects, if they exist
+func (t *Graph) attachLink(link *Link) {
+	t.Links = append(t.Links, link)
+	t.LinksByID[link.ID] = link
+	if link.ID > t.LastLinkID {
+		t.LastLinkID = link.ID
+	}
+	if origin := t.GetNodeById(link.OriginID); origin != nil && link.OriginSlot < len(origin.Outputs) {
+		slot := &origin.Outputs[link.OriginSlot]
+		if slot.Links == nil {
+			slot.Links = &[]int{}
+		}
+		*slot.Links = append(*slot.Links, link.ID)
+	}
+	if target := t.GetNodeById(link.TargetID); target != nil && link.TargetSlot < len(target.Inputs) {
+		target.Inputs[link.TargetSlot].Link = link.ID
+	}
+}
+
+// innerProperty is a property of a node of the inner graph of a composite node
+type innerProperty struct {
+	node *GraphNode
+	prop Property
+}
+
+// settableProperties returns the settable properties of the graph's nodes, ordered by node id and widget
+func (t *Graph) settableProperties() []innerProperty {
+	nodes := make([]*GraphNode, len(t.Nodes))
+	copy(nodes, t.Nodes)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	retv := make([]innerProperty, 0)
+	for _, n := range nodes {
+		props := make([]Property, 0, len(n.Properties))
+		for _, p := range n.Properties {
+			if p.Settable() && p.GetTargetNode() == n {
+				props = append(props, p)
+			}
+		}
+		sort.Slice(props, func(i, j int) bool { return props[i].GetTargetWidget() < props[j].GetTargetWidget() })
+		for _, p := range props {
+			retv = append(retv, innerProperty{node: n, prop: p})
+		}
+	}
+	return retv
+}
+
+// addInnerProperties makes the properties of inner nodes available through the composite node.  Every
+// property is available as "<inner node id>:<name>", and as its plain name when that is not ambiguous.
+func (t *Graph) addInnerProperties(n *GraphNode, props []innerProperty) map[string]innerProperty {
+	count := make(map[string]int)
+	for _, ip := range props {
+		count[ip.prop.Name()]++
+	}
+	retv := make(map[string]innerProperty)
+	for _, ip := range props {
+		key := fmt.Sprintf("%d:%s", ip.node.ID, ip.prop.Name())
+		n.Properties[key] = ip.prop
+		retv[key] = ip
+		if _, exists := n.Properties[ip.prop.Name()]; count[ip.prop.Name()] == 1 && !exists {
+			n.Properties[ip.prop.Name()] = ip.prop
+			retv[ip.prop.Name()] = ip
+		}
+	}
+	return retv
+}
+
+// bindGroupNode connects the inner graph of a group node to the group node.  The widgets of the inner
+// nodes that are not linked inside the group are the widgets of the group node in order, and their
+// properties are pointed at the group node's widget values.  The inputs and outputs of the inner nodes
+// that are not linked inside the group are the inputs and outputs of the group node in order, and get
+// links from subgraphInputNodeID and to subgraphOutputNodeID.
+func (t *Graph) bindGroupNode(n *GraphNode, inner *Graph, def *groupNodeDefinition) {
+	nodes := make([]*GraphNode, len(inner.Nodes))
+	copy(nodes, inner.Nodes)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	// the widgets
+	values := n.WidgetValuesArray()
+	widgets := make([]innerProperty, 0)
+	for _, ip := range inner.settableProperties() {
+		if slot := ip.node.GetInputWithName(ip.prop.Name()); slot != nil && slot.Link != 0 {
+			continue
+		}
+		widgets = append(widgets, ip)
+	}
+	for o, ip := range widgets {
+		if o < len(values) && ip.node.IsWidgetValueArray() {
+			ip.prop.SetTargetWidget(n, o)
+		}
+	}
+	byName := t.addInnerProperties(n, widgets)
+
+	// the outputs
+	internal := make(map[[2]int]bool)
+	for _, l := range inner.Links {
+		internal[[2]int{l.OriginID, l.OriginSlot}] = true
+	}
+	external := make(map[[2]int]bool)
+	for _, e := range def.External {
+		if len(e) < 2 {
+			continue
+		}
+		id, ok1 := numberValue(e[0])
+		slot, ok2 := numberValue(e[1])
+		if ok1 && ok2 {
+			external[[2]int{int(id), int(slot)}] = true
+		}
+	}
+	m := 0
+	for _, in := range nodes {
+		for j, slot := range in.Outputs {
+			end := [2]int{in.ID, j}
+			if internal[end] && !external[end] {
+				continue
+			}
+			if m >= len(n.Outputs) {
+				break
+			}
+			inner.attachLink(&Link{ID: inner.LastLinkID + 1, OriginID: in.ID, OriginSlot: j, TargetID: subgraphOutputNodeID, TargetSlot: m, Type: slot.Type})
+			m++
+		}
+	}
+
+	// the inputs that are not widgets
+	outer := make([]int, 0, len(n.Inputs))
+	for k, slot := range n.Inputs {
+		if slot.Widget == nil {
+			outer = append(outer, k)
+		}
+	}
+	next := 0
+	for _, in := range nodes {
+		for j, slot := range in.Inputs {
+			if slot.Link != 0 || slot.Widget != nil || next >= len(outer) {
+				continue
+			}
+			inner.attachLink(&Link{ID: inner.LastLinkID + 1, OriginID: subgraphInputNodeID, OriginSlot: outer[next], TargetID: in.ID, TargetSlot: j, Type: slot.Type})
+			next++
+		}
+	}
+
+	// the widgets converted to inputs on the group node
+	for k, slot := range n.Inputs {
+		if slot.Widget == nil || slot.Widget.Name == nil {
+			continue
+		}
+		ip, ok := byName[*slot.Widget.Name]
+		if !ok {
+			logger.Warnf("group node %d has no widget %s", n.ID, *slot.Widget.Name)
+			continue
+		}
+		n.Inputs[k].Property = ip.prop
+		index := ip.node.GetInputIndex(ip.prop.Name())
+		if index < 0 {
+			index = ip.node.convertWidgetToInput(ip.prop.Name())
+		}
+		if index < 0 {
+			continue
+		}
+		inner.attachLink(&Link{ID: inner.LastLinkID + 1, OriginID: subgraphInputNodeID, OriginSlot: k, TargetID: ip.node.ID, TargetSlot: index, Type: slot.Type})
+	}
+}
+
+// promptScope serializes the nodes of a graph into a prompt.  The nodes of the inner graphs of
+// composite nodes are serialized in scopes whose prefix is the path of ids of the composite nodes.
+type promptScope struct {
+	graph  *Graph
+	prefix string       // prefixed to the ids of the graph's nodes, empty for the workflow
+	parent *promptScope // the scope of the composite node, nil for the workflow
+	node   *GraphNode   // the composite node in the parent scope
+	// choices picks the choices of dynamic prompts
+	choices ChoiceSource
+}
+
+func (s *promptScope) child(n *GraphNode) *promptScope {
+	return &promptScope{
+		graph:   n.InnerGraph,
+		prefix:  s.prefix + strconv.Itoa(n.ID) + ":",
+		parent:  s,
+		node:    n,
+		choices: s.choices,
+	}
+}
+
+func (s *promptScope) serialize(p *Prompt) error {
+	// let frontend only nodes make their changes before any values are serialized
+	for _, node := range s.graph.NodesInExecutionOrder {
+		if node.IsVirtual() {
+			if err := node.ApplyToGraph(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, node := range s.graph.NodesInExecutionOrder {
+		if node.IsVirtual() {
+			// Don't serialize frontend only nodes
+			continue
+		}
+
+		if node.IsMuted() || node.IsBypassed() {
+			// Don't serialize muted or bypassed nodes, links through bypassed nodes are
+			// resolved for the nodes they lead to
+			continue
+		}
+
+		if node.IsComposite() {
+			if err := s.child(node).serialize(p); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// create the prompt node
+		pn := &PromptNode{
+			ID:        node.ID,
+			ClassType: node.Type,
+			Inputs:    make(map[string]interface{}),
+		}
+
+		// populate the node input values
+		for k, prop := range node.Properties {
+			if prop.Serializable() {
+				pn.Inputs[k] = prop.GetValue()
+			}
+		}
+
+		// populate the node input links
+		for i := range node.Inputs {
+			link, err := s.graph.resolveInputLink(node, i)
+			if err != nil {
+				return err
+			}
+			if link == nil {
+				continue
+			}
+			ref, err := s.linkRef(link)
+			if err != nil {
+				return err
+			}
+			if ref != nil {
+				pn.Inputs[node.Inputs[i].Name] = ref
+			}
+		}
+
+		s.expandDynamicPrompts(p, node, pn, s.prefix+strconv.Itoa(node.ID))
+
+		if s.prefix == "" {
+			p.Nodes[node.ID] = pn
+		} else {
+			if p.InnerNodes == nil {
+				p.InnerNodes = make(map[string]*PromptNode)
+			}
+			p.InnerNodes[s.prefix+strconv.Itoa(node.ID)] = pn
+		}
+	}
+	return nil
+}
+
+// linkRef returns the prompt input for a link resolved in the scope's graph, or nil when the
+// input does not receive a value from a node
+func (s *promptScope) linkRef(link *Link) ([]interface{}, error) {
+	if link.OriginID == subgraphInputNodeID {
+		if s.parent == nil {
+			return nil, nil
+		}
+		return s.parent.inputRef(s.node, link.OriginSlot)
+	}
+	if origin := s.graph.GetNodeById(link.OriginID); origin != nil && origin.IsComposite() {
+		return s.child(origin).outputRef(link.OriginSlot)
+	}
+	return []interface{}{s.prefix + strconv.Itoa(link.OriginID), link.OriginSlot}, nil
+}
+
+// inputRef returns the prompt input for the input slot of a composite node of the scope
+func (s *promptScope) inputRef(n *GraphNode, slot int) ([]interface{}, error) {
+	link, err := s.graph.resolveInputLink(n, slot)
+	if err != nil || link == nil {
+		return nil, err
+	}
+	return s.linkRef(link)
+}
+
+// outputRef returns the prompt input for the output slot of the scope's composite node
+func (s *promptScope) outputRef(slot int) ([]interface{}, error) {
+	for _, l := range s.graph.Links {
+		if l.TargetID != subgraphOutputNodeID || l.TargetSlot != slot {
+			continue
+		}
+		link, err := s.graph.followLink(l, l.Type, fmt.Sprintf("output %d of node %d (%s)", slot, s.node.ID, s.node.Type))
+		if err != nil || link == nil {
+			return nil, err
+		}
+		return s.linkRef(link)
+	}
+	return nil, fmt.Errorf("%w: output %d of node %d (%s) is not linked to an inner node", ErrVirtualNodeDeadEnd, slot, s.node.ID, s.node.Type)
+}
@@ -0,0 +1,518 @@
+package comfy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AddNode creates a node of the given type from the graph's NodeObjects and adds it to the graph.
+// The node's inputs, outputs, widget values and properties are created the same way the
+// ComfyUI frontend creates them, with every widget set to its default value.
+func (t *Graph) AddNode(nodeType string) (*GraphNode, error) {
+	return t.addNodeWithID(nodeType, t.nextNodeID())
+}
+
+// nextNodeID returns the id following the last node id, skipping the ids given to the nodes read with
+// ids that are not numbers
+func (t *Graph) nextNodeID() int {
+	retv := t.LastNodeID + 1
+	for t.GetNodeById(retv) != nil {
+		retv++
+	}
+	return retv
+}
+
+func (t *Graph) addNodeWithID(nodeType string, id int) (*GraphNode, error) {
+	if t.GetNodeById(id) != nil {
+		return nil, fmt.Errorf("graph already has a node with id %d", id)
+	}
+	if t.nodeObjects == nil {
+		return nil, ErrNotNodeObjects
+	}
+	nobject := t.nodeObjects.GetNodeObjectByName(nodeType)
+	if nobject == nil {
+		return nil, fmt.Errorf("unknown node type %s", nodeType)
+	}
+
+	n := &GraphNode{
+		ID:                 id,
+		Type:               nodeType,
+		Order:              len(t.Nodes),
+		Mode:               0,
+		InternalProperties: &map[string]interface{}{"Node name for S&R": nodeType},
+		Inputs:             make([]Slot, 0),
+		Outputs:            make([]Slot, 0),
+		Graph:              t,
+	}
+
+	// inputs that are not widgets become input slots
+	if nobject.Input != nil {
+		names := append(append([]string{}, nobject.Input.OrderedRequired...), nobject.Input.OrderedOptional...)
+		for _, name := range names {
+			p, ok := nobject.InputPropertiesByID[name]
+			if !ok || (*p).Settable() {
+				continue
+			}
+			n.Inputs = append(n.Inputs, Slot{Name: name, Type: (*p).TypeString(), Node: n})
+		}
+	}
+
+	// outputs
+	if nobject.Output != nil {
+		var names []interface{}
+		if nobject.OutputName != nil {
+			names, _ = (*nobject.OutputName).([]interface{})
+		}
+		for i, o := range *nobject.Output {
+			otype, ok := o.(string)
+			if !ok {
+				// a list of values is a combo output
+				otype = "COMBO"
+			}
+			oname := otype
+			if i < len(names) {
+				if s, ok := names[i].(string); ok {
+					oname = s
+				}
+			}
+			index := i
+			n.Outputs = append(n.Outputs, Slot{Name: oname, Type: otype, Links: &[]int{}, SlotIndex: &index, Node: n})
+		}
+	}
+
+	// widget values
+	widgets := make([]interface{}, 0)
+	for _, p := range nobject.GetSettableProperties() {
+		v, err := defaultWidgetValue(p)
+		if err != nil {
+			return nil, err
+		}
+		widgets = append(widgets, v)
+	}
+	for _, p := range nobject.GetSettableProperties() {
+		if cp, ok := p.(*ComboProperty); ok && cp.Upload != "" {
+			// the value of the "choose file to upload" widget, which is the kind of file it uploads
+			widgets = append(widgets, cp.Upload)
+		}
+	}
+	n.WidgetValues = widgets
+
+	// place the node to the right of every other node
+	x := 0.0
+	for _, other := range t.Nodes {
+		if pos, ok := other.Position.([]interface{}); ok && len(pos) == 2 {
+			if px, ok := pos[0].(float64); ok && px+other.Size.Width+50 > x {
+				x = px + other.Size.Width + 50
+			}
+		}
+	}
+	n.Position = []interface{}{x, 100.0}
+	rows := len(n.Inputs)
+	if len(n.Outputs) > rows {
+		rows = len(n.Outputs)
+	}
+	n.Size = Size{Width: 315, Height: float64(30 + 22*rows + 26*len(widgets))}
+
+	n.Properties = make(map[string]Property)
+	t.createNodeObjectProperties(n, nobject)
+
+	t.Nodes = append(t.Nodes, n)
+	t.NodesByID[n.ID] = n
+	t.NodesInExecutionOrder = append(t.NodesInExecutionOrder, n)
+	if n.ID > t.LastNodeID {
+		t.LastNodeID = n.ID
+	}
+	return n, nil
+}
+
+// defaultWidgetValue returns the value the ComfyUI frontend gives a new widget for the property
+func defaultWidgetValue(p Property) (interface{}, error) {
+	switch p.TypeString() {
+	case "INT":
+		ip, _ := p.ToIntProperty()
+		return ip.Default, nil
+	case "FLOAT":
+		fp, _ := p.ToFloatProperty()
+		return fp.Default, nil
+	case "STRING":
+		sp, _ := p.ToStringProperty()
+		return sp.Default, nil
+	case "BOOLEAN":
+		bp, _ := p.ToBoolProperty()
+		return bp.Default, nil
+	case "COMBO":
+		cp, _ := p.ToComboProperty()
+		if cp.Name() == "control_after_generate" {
+			return "randomize", nil
+		}
+		if cp.MultiSelect {
+			return []interface{}{}, nil
+		}
+		if cp.Default != "" {
+			return cp.Default, nil
+		}
+		if len(cp.Values) == 0 {
+			return "", nil
+		}
+		if cp.IsBool {
+			return cp.Values[0] == "true", nil
+		}
+		return cp.Values[0], nil
+	}
+	return nil, fmt.Errorf("cannot create a default value for property %s of type %s", p.Name(), p.TypeString())
+}
+
+// slotTypesMatch returns true if a link of the output type can be connected to the input type.
+// Types may be a comma separated list, and "*" accepts any type.
+func slotTypesMatch(outputType string, inputType string) bool {
+	if outputType == "*" || inputType == "*" || outputType == "" || inputType == "" {
+		return true
+	}
+	for _, o := range strings.Split(outputType, ",") {
+		for _, i := range strings.Split(inputType, ",") {
+			if strings.TrimSpace(o) == strings.TrimSpace(i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetOutputIndex returns the index of the output slot with the given name, or with the given type
+// when no output has that name.  -1 is returned when there is no such output.
+func (n *GraphNode) GetOutputIndex(name string) int {
+	for i, s := range n.Outputs {
+		if s.Name == name {
+			return i
+		}
+	}
+	for i, s := range n.Outputs {
+		if s.Type == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetInputIndex returns the index of the input slot with the given name, or -1
+func (n *GraphNode) GetInputIndex(name string) int {
+	for i, s := range n.Inputs {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// convertWidgetToInput adds an input slot for the widget property with the given name, like the
+// frontend's "Convert to input", and returns its index or -1 if there is no such property
+func (n *GraphNode) convertWidgetToInput(name string) int {
+	p := n.GetPropertyWithName(name)
+	if p == nil || !p.Settable() {
+		return -1
+	}
+	wname := p.Name()
+	n.Inputs = append(n.Inputs, Slot{
+		Name:     wname,
+		Type:     p.TypeString(),
+		Node:     n,
+		Widget:   &Widget{Name: &wname},
+		Property: p,
+	})
+	return len(n.Inputs) - 1
+}
+
+// Connect links the output named outName of from to the input named inName of to.  Outputs may also be
+// referenced by their type.  Connecting to the name of a widget converts the widget to an input.
+// An existing link to the input is replaced.
+func (t *Graph) Connect(from *GraphNode, outName string, to *GraphNode, inName string) (*Link, error) {
+	if t.GetNodeById(from.ID) != from || t.GetNodeById(to.ID) != to {
+		return nil, ErrNodeNotInGraph
+	}
+
+	oindex := from.GetOutputIndex(outName)
+	if oindex < 0 {
+		return nil, fmt.Errorf("node %d (%s) has no output %s", from.ID, from.Type, outName)
+	}
+	iindex := to.GetInputIndex(inName)
+	if iindex < 0 {
+		iindex = to.convertWidgetToInput(inName)
+		if iindex < 0 {
+			return nil, fmt.Errorf("node %d (%s) has no input %s", to.ID, to.Type, inName)
+		}
+	}
+	return t.ConnectSlots(from, oindex, to, iindex)
+}
+
+// ConnectSlots links the output slot of from at oindex to the input slot of to at iindex.
+// An existing link to the input is replaced.
+func (t *Graph) ConnectSlots(from *GraphNode, oindex int, to *GraphNode, iindex int) (*Link, error) {
+	if oindex < 0 || oindex >= len(from.Outputs) {
+		return nil, fmt.Errorf("node %d (%s) has no output slot %d", from.ID, from.Type, oindex)
+	}
+	if iindex < 0 || iindex >= len(to.Inputs) {
+		return nil, fmt.Errorf("node %d (%s) has no input slot %d", to.ID, to.Type, iindex)
+	}
+	output := &from.Outputs[oindex]
+	input := &to.Inputs[iindex]
+	if !slotTypesMatch(output.Type, input.Type) {
+		return nil, fmt.Errorf("cannot connect output %s (%s) of node %d to input %s (%s) of node %d",
+			output.Name, output.Type, from.ID, input.Name, input.Type, to.ID)
+	}
+	if from == to || t.IsUpstream(from, to) {
+		return nil, fmt.Errorf("%w: node %d depends on node %d", ErrGraphCycle, from.ID, to.ID)
+	}
+
+	// an input can only have a single link
+	if existing := t.GetLinkById(input.Link); existing != nil {
+		t.removeLink(existing)
+	}
+
+	link := &Link{
+		ID:         t.LastLinkID + 1,
+		OriginID:   from.ID,
+		OriginSlot: oindex,
+		TargetID:   to.ID,
+		TargetSlot: iindex,
+		Type:       output.Type,
+	}
+	t.LastLinkID = link.ID
+	t.Links = append(t.Links, link)
+	t.LinksByID[link.ID] = link
+
+	if output.Links == nil {
+		output.Links = &[]int{}
+	}
+	*output.Links = append(*output.Links, link.ID)
+	if output.SlotIndex == nil {
+		index := oindex
+		output.SlotIndex = &index
+	}
+	input.Link = link.ID
+	t.updateExecutionOrder()
+	return link, nil
+}
+
+// removeLink removes the link from the graph and from the slots it connects
+func (t *Graph) removeLink(link *Link) {
+	delete(t.LinksByID, link.ID)
+	for i, l := range t.Links {
+		if l == link {
+			t.Links = append(t.Links[:i], t.Links[i+1:]...)
+			break
+		}
+	}
+
+	if origin := t.GetNodeById(link.OriginID); origin != nil && link.OriginSlot < len(origin.Outputs) {
+		if links := origin.Outputs[link.OriginSlot].Links; links != nil {
+			for i, id := range *links {
+				if id == link.ID {
+					*links = append((*links)[:i], (*links)[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if target := t.GetNodeById(link.TargetID); target != nil && link.TargetSlot < len(target.Inputs) {
+		if target.Inputs[link.TargetSlot].Link == link.ID {
+			target.Inputs[link.TargetSlot].Link = 0
+		}
+	}
+}
+
+// Disconnect removes the link from the graph, clearing the input and output slots it connected
+func (t *Graph) Disconnect(link *Link) error {
+	if link == nil || t.GetLinkById(link.ID) != link {
+		return ErrLinkNotInGraph
+	}
+	t.removeLink(link)
+	t.updateExecutionOrder()
+	return nil
+}
+
+// Reconnect moves the target end of the link to the input slot of newTarget at slot, keeping the link's id.
+// An existing link to the new input is replaced.
+func (t *Graph) Reconnect(link *Link, newTarget *GraphNode, slot int) (*Link, error) {
+	if link == nil || t.GetLinkById(link.ID) != link {
+		return nil, ErrLinkNotInGraph
+	}
+	if t.GetNodeById(newTarget.ID) != newTarget {
+		return nil, ErrNodeNotInGraph
+	}
+	if slot < 0 || slot >= len(newTarget.Inputs) {
+		return nil, fmt.Errorf("node %d (%s) has no input slot %d", newTarget.ID, newTarget.Type, slot)
+	}
+	input := &newTarget.Inputs[slot]
+	if !slotTypesMatch(link.Type, input.Type) {
+		return nil, fmt.Errorf("cannot connect link %d (%s) to input %s (%s) of node %d",
+			link.ID, link.Type, input.Name, input.Type, newTarget.ID)
+	}
+	if link.TargetID == newTarget.ID && link.TargetSlot == slot {
+		return link, nil
+	}
+	if origin := t.GetNodeById(link.OriginID); origin == newTarget || (origin != nil && t.IsUpstream(origin, newTarget)) {
+		return nil, fmt.Errorf("%w: node %d depends on node %d", ErrGraphCycle, link.OriginID, newTarget.ID)
+	}
+
+	if existing := t.GetLinkById(input.Link); existing != nil {
+		t.removeLink(existing)
+	}
+	if target := t.GetNodeById(link.TargetID); target != nil && link.TargetSlot < len(target.Inputs) {
+		if target.Inputs[link.TargetSlot].Link == link.ID {
+			target.Inputs[link.TargetSlot].Link = 0
+		}
+	}
+	link.TargetID = newTarget.ID
+	link.TargetSlot = slot
+	input.Link = link.ID
+	t.updateExecutionOrder()
+	return link, nil
+}
+
+// RemoveNode removes the node and every link to or from it from the graph
+func (t *Graph) RemoveNode(n *GraphNode) error {
+	if n == nil || t.GetNodeById(n.ID) != n {
+		return ErrNodeNotInGraph
+	}
+	for _, in := range n.Inputs {
+		if l := t.GetLinkById(in.Link); l != nil {
+			t.removeLink(l)
+		}
+	}
+	for _, out := range n.Outputs {
+		if out.Links == nil {
+			continue
+		}
+		ids := append([]int{}, *out.Links...)
+		for _, id := range ids {
+			if l := t.GetLinkById(id); l != nil {
+				t.removeLink(l)
+			}
+		}
+	}
+
+	delete(t.NodesByID, n.ID)
+	t.Nodes = removeGraphNode(t.Nodes, n)
+	t.NodesInExecutionOrder = removeGraphNode(t.NodesInExecutionOrder, n)
+	n.Graph = nil
+	t.updateExecutionOrder()
+	return nil
+}
+
+func removeGraphNode(nodes []*GraphNode, n *GraphNode) []*GraphNode {
+	for i, node := range nodes {
+		if node == n {
+			return append(nodes[:i], nodes[i+1:]...)
+		}
+	}
+	return nodes
+}
+
+// matchSlot returns the index of the slot with the given name, or else of the first slot of a matching type
+// that is not used yet.  -1 is returned when no slot matches.
+func matchSlot(slots []Slot, name string, slotType string, used map[int]bool) int {
+	for i, s := range slots {
+		if s.Name == name && !used[i] && slotTypesMatch(slotType, s.Type) {
+			return i
+		}
+	}
+	for i, s := range slots {
+		if s.Type == slotType && !used[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReplaceNode replaces the node with a new node of the given type at the same position.  Links are moved
+// to the slots of the new node with the same name, or else the same type, and links that do not fit the
+// new node are dropped.  Widget values of properties with the same name are copied when they are valid.
+// When values or links that match the new node cannot be moved, the new node is returned with a
+// *ReplaceNodeError listing them.
+func (t *Graph) ReplaceNode(old *GraphNode, newType string) (*GraphNode, error) {
+	if old == nil || t.GetNodeById(old.ID) != old {
+		return nil, ErrNodeNotInGraph
+	}
+	n, err := t.AddNode(newType)
+	if err != nil {
+		return nil, err
+	}
+	n.Position = old.Position
+	n.Mode = old.Mode
+	n.Color = old.Color
+	n.BGColor = old.BGColor
+	if old.Type == newType {
+		n.Title = old.Title
+	}
+	failed := &ReplaceNodeError{NodeID: n.ID, NodeType: n.Type, Values: make(map[string]error), Links: make(map[int]error)}
+
+	for name, p := range old.Properties {
+		np := n.GetPropertyWithName(name)
+		if np == nil || !p.Settable() || !np.Settable() || np.TypeString() != p.TypeString() {
+			continue
+		}
+		if v := p.GetValue(); v != nil {
+			if err := np.SetValue(v); err != nil {
+				failed.Values[name] = err
+			}
+		}
+	}
+
+	// move the links of converted widgets and inputs
+	used := make(map[int]bool)
+	for _, in := range old.Inputs {
+		l := t.GetLinkById(in.Link)
+		if l == nil {
+			continue
+		}
+		index := matchSlot(n.Inputs, in.Name, l.Type, used)
+		if index < 0 && in.Widget != nil {
+			index = n.convertWidgetToInput(in.Name)
+		}
+		if index < 0 {
+			continue
+		}
+		if _, err := t.Reconnect(l, n, index); err != nil {
+			failed.Links[l.ID] = err
+			continue
+		}
+		used[index] = true
+	}
+
+	// relink the outputs
+	used = make(map[int]bool)
+	for oindex, out := range old.Outputs {
+		if out.Links == nil || len(*out.Links) == 0 {
+			continue
+		}
+		index := matchSlot(n.Outputs, out.Name, out.Type, used)
+		if index < 0 {
+			continue
+		}
+		used[index] = true
+		ids := append([]int{}, *out.Links...)
+		for _, id := range ids {
+			l := t.GetLinkById(id)
+			if l == nil || l.OriginSlot != oindex {
+				continue
+			}
+			target := t.GetNodeById(l.TargetID)
+			tslot := l.TargetSlot
+			t.removeLink(l)
+			if target == nil {
+				continue
+			}
+			if _, err := t.ConnectSlots(n, index, target, tslot); err != nil {
+				failed.Links[id] = err
+			}
+		}
+	}
+
+	if err := t.RemoveNode(old); err != nil {
+		return nil, err
+	}
+	if len(failed.Values) != 0 || len(failed.Links) != 0 {
+		return n, failed
+	}
+	return n, nil
+}
@@ -0,0 +1,288 @@
+package comfy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// buildTestGraph builds the txt2img workflow node by node
+func buildTestGraph(t *testing.T) (*Graph, map[string]*GraphNode) {
+	t.Helper()
+	graph := NewGraph(testNodeObjects(t, comfytest.DefaultObjectInfo))
+	nodes := make(map[string]*GraphNode)
+	for _, name := range []string{"CheckpointLoaderSimple", "CLIPTextEncode", "EmptyLatentImage", "KSampler", "VAEDecode", "SaveImage"} {
+		n, err := graph.AddNode(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[name] = n
+	}
+	connect := func(from string, out string, to string, in string) {
+		t.Helper()
+		if _, err := graph.Connect(nodes[from], out, nodes[to], in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connect("CheckpointLoaderSimple", "CLIP", "CLIPTextEncode", "clip")
+	connect("CheckpointLoaderSimple", "MODEL", "KSampler", "model")
+	connect("CLIPTextEncode", "CONDITIONING", "KSampler", "positive")
+	connect("CLIPTextEncode", "CONDITIONING", "KSampler", "negative")
+	connect("EmptyLatentImage", "LATENT", "KSampler", "latent_image")
+	connect("KSampler", "LATENT", "VAEDecode", "samples")
+	connect("CheckpointLoaderSimple", "VAE", "VAEDecode", "vae")
+	connect("VAEDecode", "IMAGE", "SaveImage", "images")
+	return graph, nodes
+}
+
+func TestAddNode(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+
+	if ks.ID != 4 || graph.LastNodeID != 6 || graph.GetNodeById(4) != ks {
+		t.Errorf("KSampler has id %d, last node id %d", ks.ID, graph.LastNodeID)
+	}
+	// the widgets have their default values, the seed is followed by its control widget
+	want := []interface{}{int64(0), "randomize", int64(20), 8.0, "euler", "normal", 1.0}
+	values := ks.WidgetValuesArray()
+	if len(values) != len(want) {
+		t.Fatalf("widget values %v, want %v", values, want)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("widget value %d = %v (%T), want %v (%T)", i, values[i], values[i], want[i], want[i])
+		}
+	}
+	if len(ks.Inputs) != 4 || len(ks.Outputs) != 1 {
+		t.Errorf("KSampler has %d inputs and %d outputs, want 4 and 1", len(ks.Inputs), len(ks.Outputs))
+	}
+	if _, err := graph.AddNode("NotANode"); err == nil {
+		t.Error("a node of an unknown type is added")
+	}
+
+	// the built graph is queued the way it was connected
+	p, err := graph.GraphToPrompt("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Nodes) != 6 || inputValue(p, 4, "model") != "[1 0]" || inputValue(p, 5, "vae") != "[1 2]" || inputValue(p, 4, "steps") != "20" {
+		t.Errorf("unexpected prompt %+v", p.Nodes)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ck, ks, vd := nodes["CheckpointLoaderSimple"], nodes["KSampler"], nodes["VAEDecode"]
+
+	if _, err := graph.Connect(ck, "MODEL", vd, "vae"); err == nil {
+		t.Error("a MODEL output is connected to a VAE input")
+	}
+	if _, err := graph.Connect(vd, "IMAGE", ks, "latent_image"); err == nil {
+		t.Error("an IMAGE output is connected to a LATENT input")
+	}
+	if _, err := graph.Connect(ks, "LATENT", ks, "latent_image"); !errors.Is(err, ErrGraphCycle) {
+		t.Errorf("connecting a node to itself = %v, want %v", err, ErrGraphCycle)
+	}
+	second, err := graph.AddNode("KSampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.Connect(ks, "LATENT", second, "latent_image"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.Connect(second, "LATENT", ks, "latent_image"); !errors.Is(err, ErrGraphCycle) {
+		t.Errorf("connecting a node to a node it depends on = %v, want %v", err, ErrGraphCycle)
+	}
+	decoded, err := graph.Connect(second, "LATENT", vd, "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.Reconnect(decoded, ks, 3); !errors.Is(err, ErrGraphCycle) {
+		t.Errorf("reconnecting a link to a node it depends on = %v, want %v", err, ErrGraphCycle)
+	}
+	if _, err := graph.Connect(ck, "NOPE", vd, "vae"); err == nil {
+		t.Error("an unknown output is connected")
+	}
+
+	// an input has a single link, connecting it again replaces the link
+	links := len(graph.Links)
+	latent, err := graph.Connect(nodes["EmptyLatentImage"], "LATENT", ks, "latent_image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Links) != links || ks.Inputs[3].Link != latent.ID || latent.ID != graph.LastLinkID {
+		t.Errorf("connecting an input again left %d links, want %d", len(graph.Links), links)
+	}
+
+	// a widget converted to an input only accepts its own type
+	if _, err := graph.Connect(nodes["EmptyLatentImage"], "LATENT", ks, "steps"); err == nil {
+		t.Error("a LATENT is connected to the steps widget")
+	}
+	if err := graph.Disconnect(latent); err != nil {
+		t.Fatal(err)
+	}
+	if ks.Inputs[3].Link != 0 || graph.GetLinkById(latent.ID) != nil {
+		t.Error("the disconnected link is kept")
+	}
+	if err := graph.Disconnect(latent); err != ErrLinkNotInGraph {
+		t.Errorf("disconnecting twice = %v, want %v", err, ErrLinkNotInGraph)
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+
+	if err := graph.RemoveNode(ks); err != nil {
+		t.Fatal(err)
+	}
+	if graph.GetNodeById(ks.ID) != nil || len(graph.Nodes) != 5 {
+		t.Error("the node is still in the graph")
+	}
+	for _, l := range graph.Links {
+		if l.OriginID == ks.ID || l.TargetID == ks.ID {
+			t.Errorf("link %d of the removed node is kept", l.ID)
+		}
+	}
+	if nodes["VAEDecode"].Inputs[0].Link != 0 {
+		t.Error("the input linked to the removed node still has its link")
+	}
+	if len(*nodes["EmptyLatentImage"].Outputs[0].Links) != 0 {
+		t.Error("the output linked to the removed node still has its link")
+	}
+	if err := graph.RemoveNode(ks); err != ErrNodeNotInGraph {
+		t.Errorf("removing twice = %v, want %v", err, ErrNodeNotInGraph)
+	}
+}
+
+func TestReplaceNode(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	if err := nodes["KSampler"].GetPropertyWithName("steps").SetValue(5); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := graph.ReplaceNode(nodes["KSampler"], "KSampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := ks.GetPropertyWithName("steps").GetValue(); v != int64(5) {
+		t.Errorf("steps of the new node = %v, want 5", v)
+	}
+	for i, in := range ks.Inputs {
+		if graph.GetLinkById(in.Link) == nil {
+			t.Errorf("input %d (%s) of the new node is not linked", i, in.Name)
+		}
+	}
+
+	preview, err := graph.ReplaceNode(nodes["SaveImage"], "PreviewImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := graph.GetLinkById(preview.Inputs[0].Link); l == nil || l.OriginID != nodes["VAEDecode"].ID {
+		t.Error("the images input is not moved to the PreviewImage")
+	}
+	if graph.GetNodeById(nodes["SaveImage"].ID) != nil {
+		t.Error("the replaced node is still in the graph")
+	}
+}
+
+func TestReplaceNodeErrors(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	nodes["KSampler"].WidgetValuesArray()[testStepsWidget] = 100000.0
+
+	ks, err := graph.ReplaceNode(nodes["KSampler"], "KSampler")
+	var rerr *ReplaceNodeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("err = %v, want a ReplaceNodeError", err)
+	}
+	if ks == nil || rerr.NodeID != ks.ID {
+		t.Fatalf("node %v, error for node %d, want the new node", ks, rerr.NodeID)
+	}
+	if !errors.Is(rerr.Values["steps"], ErrValueOutOfRange) || len(rerr.Values) != 1 || len(rerr.Links) != 0 {
+		t.Errorf("values %v, links %v, want steps out of range", rerr.Values, rerr.Links)
+	}
+
+	// the images input of the SaveImage no longer takes the IMAGE of the VAEDecode
+	save := nodes["SaveImage"]
+	id := save.Inputs[0].Link
+	save.Inputs[0].Type = "LATENT"
+	_, err = graph.ReplaceNode(nodes["VAEDecode"], "VAEDecode")
+	if !errors.As(err, &rerr) {
+		t.Fatalf("err = %v, want a ReplaceNodeError", err)
+	}
+	if len(rerr.Values) != 0 || len(rerr.Links) != 1 || rerr.Links[id] == nil {
+		t.Errorf("values %v, links %v, want link %d", rerr.Values, rerr.Links, id)
+	}
+	if graph.GetLinkById(save.Inputs[0].Link) != nil {
+		t.Error("the dropped link is still connected")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	second, err := graph.AddNode("KSampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := graph.GetLinkById(ks.Inputs[3].Link)
+	moved, err := graph.Reconnect(link, second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ID != link.ID || second.Inputs[3].Link != link.ID || ks.Inputs[3].Link != 0 {
+		t.Error("the link is not moved to the input of the other node")
+	}
+	if _, err := graph.Reconnect(link, second, 0); err == nil {
+		t.Error("a LATENT link is moved to a MODEL input")
+	}
+}
+
+func TestExecutionOrder(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	// added last, the latent must still be executed before the sampler
+	latent, err := graph.AddNode("EmptyLatentImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.Connect(latent, "LATENT", nodes["KSampler"], "latent_image"); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.UpdateExecutionOrder(); err != nil {
+		t.Fatal(err)
+	}
+
+	position := make(map[*GraphNode]int)
+	for i, n := range graph.NodesInExecutionOrder {
+		position[n] = i
+		if n.Order != i {
+			t.Errorf("node %d has order %d at position %d", n.ID, n.Order, i)
+		}
+	}
+	for _, l := range graph.Links {
+		origin, target := graph.GetNodeById(l.OriginID), graph.GetNodeById(l.TargetID)
+		if position[origin] > position[target] {
+			t.Errorf("node %d is executed after node %d that it is linked to", origin.ID, target.ID)
+		}
+	}
+
+	ids := func(nodes []*GraphNode) []int {
+		retv := make([]int, 0, len(nodes))
+		for _, n := range nodes {
+			retv = append(retv, n.ID)
+		}
+		return retv
+	}
+	ks := nodes["KSampler"]
+	if up := ids(graph.Upstream(ks)); len(up) != 3 {
+		t.Errorf("upstream of the KSampler = %v, want the loader, the prompt and the latent", up)
+	}
+	if down := ids(graph.Downstream(ks)); len(down) != 2 {
+		t.Errorf("downstream of the KSampler = %v, want the decoder and the save", down)
+	}
+	if !graph.IsUpstream(nodes["SaveImage"], nodes["CheckpointLoaderSimple"]) || graph.IsUpstream(ks, nodes["SaveImage"]) {
+		t.Error("IsUpstream does not follow the links")
+	}
+}
@@ -0,0 +1,153 @@
+package comfy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/er1cw00/comfy.go/base/logger"
+)
+
+// UpdateExecutionOrder computes NodesInExecutionOrder from the links of the graph, so that every node comes
+// after the nodes linked to its inputs, and renumbers the Order of the nodes to match.  Nodes that do not
+// depend on each other keep their previous relative order.  When the links contain a cycle, the nodes in
+// and after the cycle are appended in their previous order and an error wrapping ErrGraphCycle is returned.
+func (t *Graph) UpdateExecutionOrder() error {
+	indegree := make(map[int]int, len(t.Nodes))
+	children := make(map[int][]*GraphNode)
+	for _, n := range t.Nodes {
+		indegree[n.ID] = 0
+	}
+	for _, l := range t.Links {
+		origin := t.GetNodeById(l.OriginID)
+		target := t.GetNodeById(l.TargetID)
+		if origin == nil || target == nil {
+			continue
+		}
+		indegree[target.ID]++
+		children[origin.ID] = append(children[origin.ID], target)
+	}
+
+	less := func(a, b *GraphNode) bool {
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.ID < b.ID
+	}
+
+	ready := make([]*GraphNode, 0)
+	for _, n := range t.Nodes {
+		if indegree[n.ID] == 0 {
+			ready = append(ready, n)
+		}
+	}
+	retv := make([]*GraphNode, 0, len(t.Nodes))
+	for len(ready) != 0 {
+		sort.SliceStable(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		n := ready[0]
+		ready = ready[1:]
+		retv = append(retv, n)
+		for _, c := range children[n.ID] {
+			indegree[c.ID]--
+			if indegree[c.ID] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+
+	var err error
+	if len(retv) != len(t.Nodes) {
+		remaining := make([]*GraphNode, 0, len(t.Nodes)-len(retv))
+		ids := make([]int, 0, len(t.Nodes)-len(retv))
+		for _, n := range t.Nodes {
+			if indegree[n.ID] != 0 {
+				remaining = append(remaining, n)
+				ids = append(ids, n.ID)
+			}
+		}
+		sort.SliceStable(remaining, func(i, j int) bool { return less(remaining[i], remaining[j]) })
+		sort.Ints(ids)
+		retv = append(retv, remaining...)
+		err = fmt.Errorf("%w: nodes %v cannot be ordered", ErrGraphCycle, ids)
+	}
+
+	for i, n := range retv {
+		n.Order = i
+	}
+	t.NodesInExecutionOrder = retv
+	return err
+}
+
+// updateExecutionOrder updates the execution order after the graph was changed
+func (t *Graph) updateExecutionOrder() {
+	if err := t.UpdateExecutionOrder(); err != nil {
+		logger.Warnf("update execution order fail, err: %v", err)
+	}
+}
+
+// Upstream returns the nodes the node depends on, directly or through other nodes, in execution order
+func (t *Graph) Upstream(node *GraphNode) []*GraphNode {
+	found := make(map[int]bool)
+	pending := []*GraphNode{node}
+	for len(pending) != 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, in := range n.Inputs {
+			l := t.GetLinkById(in.Link)
+			if l == nil {
+				continue
+			}
+			if parent := t.GetNodeById(l.OriginID); parent != nil && !found[parent.ID] {
+				found[parent.ID] = true
+				pending = append(pending, parent)
+			}
+		}
+	}
+	return t.nodesInExecutionOrder(found)
+}
+
+// Downstream returns the nodes that depend on the node, directly or through other nodes, in execution order
+func (t *Graph) Downstream(node *GraphNode) []*GraphNode {
+	found := make(map[int]bool)
+	pending := []*GraphNode{node}
+	for len(pending) != 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, out := range n.Outputs {
+			if out.Links == nil {
+				continue
+			}
+			for _, id := range *out.Links {
+				l := t.GetLinkById(id)
+				if l == nil {
+					continue
+				}
+				if child := t.GetNodeById(l.TargetID); child != nil && !found[child.ID] {
+					found[child.ID] = true
+					pending = append(pending, child)
+				}
+			}
+		}
+	}
+	return t.nodesInExecutionOrder(found)
+}
+
+// IsUpstream returns true if other is upstream of node, that is if node depends on other directly or
+// through other nodes
+func (t *Graph) IsUpstream(node *GraphNode, other *GraphNode) bool {
+	for _, n := range t.Upstream(node) {
+		if n == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Graph) nodesInExecutionOrder(ids map[int]bool) []*GraphNode {
+	retv := make([]*GraphNode, 0, len(ids))
+	for _, n := range t.NodesInExecutionOrder {
+		if ids[n.ID] {
+			retv = append(retv, n)
+		}
+	}
+	return retv
+}
@@ -0,0 +1,230 @@
+package comfy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Versions of the workflow JSON schema, as found in Graph.Version.  Workflows are written with the
+// schema they were read with, ConvertSchema converts a graph from one schema to the other.
+const (
+	SchemaVersionLegacy float32 = 0.4
+	SchemaVersion1      float32 = 1
+)
+
+// GraphState holds the last ids used in a schema 1.0 workflow
+type GraphState struct {
+	LastGroupID   int `json:"lastGroupId"`
+	LastNodeID    int `json:"lastNodeId"`
+	LastLinkID    int `json:"lastLinkId"`
+	LastRerouteID int `json:"lastRerouteId"`
+}
+
+// Reroute is a native reroute of the frontend, a point that links pass through.  Unlike "Reroute"
+// nodes they are not nodes, and do not change which nodes are linked.
+type Reroute struct {
+	ID       int                    `json:"id"`
+	ParentID int                    `json:"parentId,omitempty"`
+	Pos      []float64              `json:"pos"`
+	LinkIDs  []int                  `json:"linkIds"`
+	Floating map[string]interface{} `json:"floating,omitempty"`
+}
+
+// linkExtension is how legacy workflows save the Reroute a link passes through, in extra.linkExtensions
+type linkExtension struct {
+	ID       int `json:"id"`
+	ParentID int `json:"parentId"`
+}
+
+// IsSchema1 returns true if the graph uses the schema 1.0, with links as objects and the last ids in State
+func (t *Graph) IsSchema1() bool {
+	return t.Version >= SchemaVersion1
+}
+
+func (t *Graph) MarshalJSON() ([]byte, error) {
+	// Create an alias type to avoid recursive call to MarshalJSON
+	type Alias Graph
+	if !t.IsSchema1() {
+		return json.Marshal((*Alias)(t))
+	}
+
+	tmp := struct {
+		*Alias
+		// hide the legacy last ids, they are in the state
+		LastNodeID    int          `json:"last_node_id,omitempty"`
+		LastLinkID    int          `json:"last_link_id,omitempty"`
+		State         *GraphState  `json:"state"`
+		Links         []linkObject `json:"links"`
+		FloatingLinks []linkObject `json:"floatingLinks,omitempty"`
+	}{
+		Alias:         (*Alias)(t),
+		State:         t.currentState(),
+		Links:         make([]linkObject, 0, len(t.Links)),
+		FloatingLinks: make([]linkObject, 0, len(t.FloatingLinks)),
+	}
+	for _, l := range t.Links {
+		tmp.Links = append(tmp.Links, l.toObject(t))
+	}
+	for _, l := range t.FloatingLinks {
+		tmp.FloatingLinks = append(tmp.FloatingLinks, l.toObject(t))
+	}
+	return json.Marshal(tmp)
+}
+
+// resolveNodeIDs gives the nodes whose id is not a number, such as a UUID, the ids following the last node
+// id and points the links to them.  The nodes keep the id they were read with, which is written back, and
+// the last node id is left as it is so that the workflow is written as it was read.
+func (t *Graph) resolveNodeIDs() error {
+	last := t.LastNodeID
+	if t.State != nil {
+		last = max(last, t.State.LastNodeID)
+	}
+	for _, n := range t.Nodes {
+		last = max(last, n.ID)
+	}
+
+	ids := make(map[string]int)
+	for _, n := range t.Nodes {
+		if n.idString == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(n.idString); err == nil {
+			continue
+		}
+		if _, ok := ids[n.idString]; ok {
+			return fmt.Errorf("duplicate node id %q", n.idString)
+		}
+		last++
+		n.ID = last
+		ids[n.idString] = n.ID
+	}
+
+	resolve := func(l *Link, ref *string, id *int) error {
+		if *ref == "" {
+			return nil
+		}
+		n, ok := ids[*ref]
+		if !ok {
+			return fmt.Errorf("link %d connects unknown node %q", l.ID, *ref)
+		}
+		*id = n
+		*ref = ""
+		return nil
+	}
+	for _, l := range append(append([]*Link{}, t.Links...), t.FloatingLinks...) {
+		if err := resolve(l, &l.originRef, &l.OriginID); err != nil {
+			return err
+		}
+		if err := resolve(l, &l.targetRef, &l.TargetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeRef returns the id of the node in the form it was read with, for the links connecting it
+func (t *Graph) nodeRef(id int) nodeRef {
+	if n := t.GetNodeById(id); n != nil && n.idString != "" {
+		return nodeRef{id: id, ref: n.idString}
+	}
+	return nodeRef{id: id}
+}
+
+// currentState returns the state of the graph with the last ids in use
+func (t *Graph) currentState() *GraphState {
+	retv := &GraphState{}
+	if t.State != nil {
+		*retv = *t.State
+	}
+	retv.LastNodeID = max(retv.LastNodeID, t.LastNodeID)
+	retv.LastLinkID = max(retv.LastLinkID, t.LastLinkID)
+	for _, g := range t.Groups {
+		retv.LastGroupID = max(retv.LastGroupID, g.ID)
+	}
+	for _, r := range t.Reroutes {
+		retv.LastRerouteID = max(retv.LastRerouteID, r.ID)
+	}
+	return retv
+}
+
+// ConvertSchema converts the graph to the schema version SchemaVersionLegacy or SchemaVersion1, which
+// it will be written with.  Native reroutes, which legacy workflows keep in Extra, are moved between
+// Extra and Reroutes.  Floating links, which legacy workflows do not have, are dropped.
+func (t *Graph) ConvertSchema(version float32) error {
+	switch version {
+	case SchemaVersion1:
+		if t.IsSchema1() {
+			return nil
+		}
+		if t.ID == "" {
+			t.ID = uuid.NewString()
+		}
+		if raw, ok := t.Extra["reroutes"]; ok {
+			if err := remarshal(raw, &t.Reroutes); err != nil {
+				return fmt.Errorf("invalid reroutes: %w", err)
+			}
+			delete(t.Extra, "reroutes")
+		}
+		if raw, ok := t.Extra["linkExtensions"]; ok {
+			extensions := make([]linkExtension, 0)
+			if err := remarshal(raw, &extensions); err != nil {
+				return fmt.Errorf("invalid link extensions: %w", err)
+			}
+			for _, e := range extensions {
+				if l := t.GetLinkById(e.ID); l != nil {
+					l.ParentID = e.ParentID
+				}
+			}
+			delete(t.Extra, "linkExtensions")
+		}
+		// groups have ids in schema 1.0
+		state := t.currentState()
+		for _, g := range t.Groups {
+			if g.ID == 0 {
+				state.LastGroupID++
+				g.ID = state.LastGroupID
+			}
+		}
+		t.State = state
+		t.Version = SchemaVersion1
+	case SchemaVersionLegacy:
+		if !t.IsSchema1() {
+			return nil
+		}
+		state := t.currentState()
+		t.LastNodeID = state.LastNodeID
+		t.LastLinkID = state.LastLinkID
+		extensions := make([]linkExtension, 0)
+		for _, l := range t.Links {
+			if l.ParentID != 0 {
+				extensions = append(extensions, linkExtension{ID: l.ID, ParentID: l.ParentID})
+			}
+		}
+		if len(t.Reroutes) != 0 || len(extensions) != 0 {
+			if t.Extra == nil {
+				t.Extra = make(map[string]interface{})
+			}
+			t.Extra["reroutes"] = t.Reroutes
+			t.Extra["linkExtensions"] = extensions
+		}
+		t.Reroutes = nil
+		t.FloatingLinks = nil
+		t.State = nil
+		t.Version = SchemaVersionLegacy
+	default:
+		return fmt.Errorf("unsupported workflow schema version %v", version)
+	}
+	return nil
+}
+
+// remarshal converts a value decoded into interfaces, such as a field of Extra, to the type of out
+func remarshal(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,205 @@
+package comfy
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+func TestConvertSchema(t *testing.T) {
+	nodeObjects := testNodeObjects(t, comfytest.DefaultObjectInfo)
+	graph := loadTestGraph(t, nodeObjects, "txt2img.json")
+	graph.Extra["reroutes"] = []interface{}{map[string]interface{}{"id": 1, "pos": []float64{1000, 100}, "linkIds": []int{7}}}
+	graph.Extra["linkExtensions"] = []interface{}{map[string]interface{}{"id": 7, "parentId": 1}}
+	want, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := graph.ConvertSchema(SchemaVersion1); err != nil {
+		t.Fatal(err)
+	}
+	if !graph.IsSchema1() || graph.ID == "" || graph.State == nil {
+		t.Fatal("the graph is not converted to schema 1.0")
+	}
+	if s := graph.State; s.LastNodeID != 9 || s.LastLinkID != 9 || s.LastGroupID != 1 || s.LastRerouteID != 1 {
+		t.Errorf("unexpected state %+v", s)
+	}
+	if len(graph.Reroutes) != 1 || graph.GetLinkById(7).ParentID != 1 || len(graph.Extra) != 0 {
+		t.Error("the reroutes are not moved out of extra")
+	}
+
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Links      []map[string]interface{} `json:"links"`
+		LastNodeID *int                     `json:"last_node_id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("links are not written as objects: %v", err)
+	}
+	if len(raw.Links) != 9 || raw.LastNodeID != nil {
+		t.Errorf("written with %d links and last node id %v", len(raw.Links), raw.LastNodeID)
+	}
+
+	loaded, _, err := NewGraphFromJsonString(string(data), nodeObjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsSchema1() || loaded.GetLinkById(7).ParentID != 1 || len(loaded.Reroutes) != 1 {
+		t.Error("the written workflow is not read back as schema 1.0")
+	}
+	got, err := loaded.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Nodes, want.Nodes) {
+		t.Errorf("the converted graph builds the prompt %v, want %v", got.Nodes, want.Nodes)
+	}
+
+	if err := loaded.ConvertSchema(SchemaVersionLegacy); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsSchema1() || loaded.State != nil || loaded.LastNodeID != 9 || loaded.LastLinkID != 9 {
+		t.Error("the graph is not converted back to the legacy schema")
+	}
+	if loaded.Reroutes != nil || loaded.Extra["reroutes"] == nil || loaded.Extra["linkExtensions"] == nil {
+		t.Error("the reroutes are not moved back to extra")
+	}
+	if err := loaded.ConvertSchema(0.5); err == nil {
+		t.Error("an unknown schema version is accepted")
+	}
+}
+
+func TestLinkUnmarshal(t *testing.T) {
+	want := Link{ID: 3, OriginID: 4, OriginSlot: 1, TargetID: 6, TargetSlot: 0, Type: "CLIP"}
+	for _, data := range []string{
+		`[3, 4, 1, 6, 0, "CLIP"]`,
+		`["3", "4", 1, "6", 0, "CLIP"]`,
+		`{"id": 3, "origin_id": 4, "origin_slot": 1, "target_id": 6, "target_slot": 0, "type": "CLIP"}`,
+		`{"id": "3", "origin_id": "4", "origin_slot": 1, "target_id": "6", "target_slot": 0, "type": "CLIP", "parentId": null}`,
+	} {
+		var l Link
+		if err := json.Unmarshal([]byte(data), &l); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if l != want {
+			t.Errorf("%s is read as %+v", data, l)
+		}
+	}
+
+	// the nodes with ids that are not numbers are resolved by the graph
+	for _, data := range []string{
+		`["3", "3f8a2b1c-0d4e-4f5a-9b6c-7d8e9f0a1b2c", 1, 6, 0, "CLIP"]`,
+		`{"id": 3, "origin_id": "3f8a2b1c-0d4e-4f5a-9b6c-7d8e9f0a1b2c", "origin_slot": 1, "target_id": 6, "target_slot": 0, "type": "CLIP"}`,
+	} {
+		var l Link
+		if err := json.Unmarshal([]byte(data), &l); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if l.originRef != "3f8a2b1c-0d4e-4f5a-9b6c-7d8e9f0a1b2c" || l.TargetID != 6 || l.targetRef != "" {
+			t.Errorf("%s is read as %+v", data, l)
+		}
+	}
+
+	for _, data := range []string{
+		`[3, 4, 1, 6, 0]`,
+		`["3f8a2b1c-0d4e-4f5a-9b6c-7d8e9f0a1b2c", 4, 1, 6, 0, "CLIP"]`,
+		`{"id": 3, "origin_id": 4, "origin_slot": "3f8a2b1c-0d4e-4f5a-9b6c-7d8e9f0a1b2c", "target_id": 6, "target_slot": 0}`,
+		`[3, true, 1, 6, 0, "CLIP"]`,
+	} {
+		var l Link
+		if err := json.Unmarshal([]byte(data), &l); err == nil {
+			t.Errorf("%s is read as %+v", data, l)
+		}
+	}
+}
+
+func TestStringNodeIDs(t *testing.T) {
+	nodeObjects := testNodeObjects(t, comfytest.DefaultObjectInfo)
+	graph := loadTestGraph(t, nodeObjects, "txt2img_string_ids.json")
+
+	// numeric ids are read as their number, the UUIDs are given the next free ids
+	if ks := graph.GetNodeById(3); ks == nil || ks.Type != "KSampler" {
+		t.Fatal("the node with id \"3\" is not node 3")
+	}
+	decode, save := graph.GetNodesWithType("VAEDecode")[0], graph.GetNodesWithType("SaveImage")[0]
+	if decode.ID != 10 || save.ID != 11 {
+		t.Errorf("the UUID nodes have ids %d and %d, want 10 and 11", decode.ID, save.ID)
+	}
+
+	want, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inputValue(want, 10, "samples"); got != "[3 0]" {
+		t.Errorf("samples of the VAEDecode = %s, want [3 0]", got)
+	}
+	if got := inputValue(want, 11, "images"); got != "[10 0]" {
+		t.Errorf("images of the SaveImage = %s, want [10 0]", got)
+	}
+
+	// the ids are written back as they were read
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written, read struct {
+		Nodes []struct {
+			ID interface{} `json:"id"`
+		} `json:"nodes"`
+		Links []map[string]interface{} `json:"links"`
+	}
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile("testdata/txt2img_string_ids.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(original, &read); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written.Nodes, read.Nodes) {
+		t.Errorf("node ids written as %v, read as %v", written.Nodes, read.Nodes)
+	}
+	for i, l := range written.Links {
+		if l["origin_id"] != read.Links[i]["origin_id"] || l["target_id"] != read.Links[i]["target_id"] {
+			t.Errorf("link %v connects %v to %v, read as %v to %v", l["id"], l["origin_id"], l["target_id"],
+				read.Links[i]["origin_id"], read.Links[i]["target_id"])
+		}
+	}
+
+	loaded, _, err := NewGraphFromJsonString(string(data), nodeObjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Nodes, want.Nodes) {
+		t.Errorf("the written graph builds the prompt %v, want %v", got.Nodes, want.Nodes)
+	}
+
+	// the nodes added later on do not reuse the ids of the UUID nodes
+	added, err := loaded.AddNode("PreviewImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID != 12 {
+		t.Errorf("a node added to the graph has id %d, want 12", added.ID)
+	}
+
+	if _, _, err := NewGraphFromJsonString(strings.Replace(string(original), `"target_id":"e2f3a4b5`, `"target_id":"f2f3a4b5`, 1), nodeObjects); err == nil {
+		t.Error("a link to an unknown node is accepted")
+	}
+}
@@ -0,0 +1,123 @@
+package comfy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// SeedSource returns a random seed between lo and hi, both included.  It is used for the seeds whose
+// control_after_generate is "randomize", a source based on a seeded rand.Rand makes the seeds reproducible.
+type SeedSource func(lo int64, hi int64) int64
+
+// maxRandomSeed limits random seeds to the range the frontend uses
+const maxRandomSeed = 1125899906842624
+
+func defaultSeedSource(lo int64, hi int64) int64 {
+	return lo + rand.Int63n(hi-lo+1)
+}
+
+// ApplySeedControl changes the seeds of the graph according to their control_after_generate value, as the
+// frontend does after a prompt was queued: "increment" and "decrement" change the seed by its step,
+// "randomize" sets a random seed from the source and "fixed" keeps the seed.  Seeds stay within the range
+// of their property.  The seeds of PrimitiveNodes are changed as well.  A nil source uses math/rand.
+func (t *Graph) ApplySeedControl(source SeedSource) error {
+	if source == nil {
+		source = defaultSeedSource
+	}
+	return t.applySeedControl(source, make(map[*Graph]bool))
+}
+
+func (t *Graph) applySeedControl(source SeedSource, visited map[*Graph]bool) error {
+	// the graphs of subgraphs are shared by the nodes using them, their seeds are changed once
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+
+	for _, n := range t.Nodes {
+		if n.IsComposite() {
+			// the properties of a composite node are those of its inner nodes
+			if err := n.InnerGraph.applySeedControl(source, visited); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := n.applySeedControl(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *GraphNode) applySeedControl(source SeedSource) error {
+	if n.Type == "PrimitiveNode" {
+		// a primitive of an INT has the control widget after its value
+		values := n.WidgetValuesArray()
+		if len(values) < 2 {
+			return nil
+		}
+		mode, _ := values[1].(string)
+		if p, ok := n.Properties["value"]; ok {
+			if ip, ok := p.ToIntProperty(); ok {
+				return ip.applySeedControl(mode, source)
+			}
+		}
+		return nil
+	}
+
+	control, ok := n.Properties["control_after_generate"]
+	if !ok {
+		return nil
+	}
+	mode := fmt.Sprintf("%v", control.GetValue())
+	for _, name := range []string{"seed", "noise_seed"} {
+		if p, ok := n.Properties[name]; ok {
+			if ip, ok := p.ToIntProperty(); ok {
+				return ip.applySeedControl(mode, source)
+			}
+		}
+	}
+	return nil
+}
+
+// applySeedControl changes the value of the property for the control_after_generate mode
+func (p *IntProperty) applySeedControl(mode string, source SeedSource) error {
+	if mode != "increment" && mode != "decrement" && mode != "randomize" {
+		return nil
+	}
+	step := int64(1)
+	if p.hasStep && p.Step > 0 {
+		step = p.Step
+	}
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if p.hasRange {
+		lo, hi = p.Min, p.Max
+	}
+	current, err := intValue(p.GetValue())
+	if err != nil {
+		return p.valueError(p.GetValue(), err)
+	}
+
+	var v int64
+	switch mode {
+	case "increment":
+		v = hi
+		if current <= hi-step {
+			v = current + step
+		}
+	case "decrement":
+		v = lo
+		if current >= lo+step {
+			v = current - step
+		}
+	case "randomize":
+		rlo := max(lo, -maxRandomSeed)
+		rhi := min(hi, maxRandomSeed)
+		v = source(rlo, rhi)
+		if step > 1 {
+			v = rlo + (v-rlo)/step*step
+		}
+	}
+	return p.SetValue(max(lo, min(hi, v)))
+}
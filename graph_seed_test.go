@@ -0,0 +1,103 @@
+package comfy
+
+import (
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+func TestApplySeedControl(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	ks := graph.GetNodeById(3)
+	seed, control := ks.GetPropertyWithName("seed"), ks.GetPropertyWithName("control_after_generate")
+	source := func(lo int64, hi int64) int64 {
+		if lo != 0 || hi != maxRandomSeed {
+			t.Errorf("random seed asked between %d and %d", lo, hi)
+		}
+		return 42
+	}
+
+	for _, tc := range []struct {
+		mode string
+		seed int64
+		want int64
+	}{
+		{"randomize", 5, 42},
+		{"increment", 5, 6},
+		{"decrement", 5, 4},
+		{"fixed", 5, 5},
+		// the seed stays within the range of the property
+		{"decrement", 0, 0},
+	} {
+		if err := control.SetValue(tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := seed.SetValue(tc.seed); err != nil {
+			t.Fatal(err)
+		}
+		if err := graph.ApplySeedControl(source); err != nil {
+			t.Fatal(err)
+		}
+		if v := seed.GetValue(); v != tc.want {
+			t.Errorf("%s of %d = %v, want %d", tc.mode, tc.seed, v, tc.want)
+		}
+	}
+}
+
+func TestApplySeedControlRange(t *testing.T) {
+	// a seed with a small range and a step, bound to the seed widget of the KSampler
+	var input interface{} = []interface{}{"INT", map[string]interface{}{"min": 0.0, "max": 100.0, "step": 10.0}}
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	seed, ok := (*NewPropertyFromInput("seed", false, &input, 0)).ToIntProperty()
+	if !ok {
+		t.Fatal("no INT property")
+	}
+	seed.SetTargetWidget(graph.GetNodeById(3), 0)
+
+	for _, tc := range []struct {
+		mode string
+		seed int64
+		want int64
+	}{
+		{"increment", 90, 100},
+		{"increment", 100, 100},
+		{"decrement", 10, 0},
+		{"decrement", 0, 0},
+		// random seeds are on a step
+		{"randomize", 0, 70},
+	} {
+		if err := seed.SetValue(tc.seed); err != nil {
+			t.Fatal(err)
+		}
+		if err := seed.applySeedControl(tc.mode, func(lo int64, hi int64) int64 { return 77 }); err != nil {
+			t.Fatal(err)
+		}
+		if v := seed.GetValue(); v != tc.want {
+			t.Errorf("%s of %d = %v, want %d", tc.mode, tc.seed, v, tc.want)
+		}
+	}
+}
+
+func TestApplySeedControlPrimitive(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "primitive.json")
+	ks, primitive := graph.GetNodeById(3), graph.GetNodeById(10)
+
+	if err := graph.ApplySeedControl(nil); err != nil {
+		t.Fatal(err)
+	}
+	// the primitive increments its value, the KSampler seed is "fixed"
+	if v := primitive.GetPropertyWithName("value").GetValue(); v != int64(8) {
+		t.Errorf("value of the primitive = %v, want 8", v)
+	}
+	if v := ks.GetPropertyWithName("seed").GetValue(); v != int64(8) {
+		t.Errorf("seed of the KSampler = %v, want the value of the primitive", v)
+	}
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inputValue(p, 3, "seed"); got != "8" {
+		t.Errorf("seed sent as %s, want 8", got)
+	}
+}
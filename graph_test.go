@@ -0,0 +1,208 @@
+package comfy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// testNodeObjects returns the node objects of the object info, without a server
+func testNodeObjects(t *testing.T, objectInfo string) *NodeObjects {
+	t.Helper()
+	retv := &NodeObjects{}
+	if err := json.Unmarshal([]byte(objectInfo), &retv.Objects); err != nil {
+		t.Fatal(err)
+	}
+	retv.PopulateInputProperties()
+	return retv
+}
+
+// inputValue formats the value of a prompt input, links are formatted as [origin slot]
+func inputValue(p Prompt, id int, name string) string {
+	pn, ok := p.Nodes[id]
+	if !ok {
+		return "<no node>"
+	}
+	v, ok := pn.Inputs[name]
+	if !ok {
+		return "<no input>"
+	}
+	return fmt.Sprint(v)
+}
+
+func TestGraphToPrompt(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ClientID != "client" || len(p.Nodes) != 7 || p.ExtraData.PngInfo.Workflow != graph {
+		t.Fatalf("unexpected prompt %+v", p)
+	}
+	if ct := p.Nodes[3].ClassType; ct != "KSampler" {
+		t.Errorf("class type of node 3 = %s", ct)
+	}
+	for name, want := range map[string]string{
+		"seed":         "1.56680208700286e+14",
+		"steps":        "20",
+		"sampler_name": "euler",
+		"model":        "[4 0]",
+		"positive":     "[6 0]",
+		"latent_image": "[5 0]",
+	} {
+		if got := inputValue(p, 3, name); got != want {
+			t.Errorf("input %s = %s, want %s", name, got, want)
+		}
+	}
+	if got := inputValue(p, 3, "control_after_generate"); got != "<no input>" {
+		t.Errorf("the seed control widget is sent as %s", got)
+	}
+}
+
+func TestGraphToPromptBypass(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	if err := graph.GetNodeById(3).SetMode(NodeModeBypass); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Nodes[3]; ok {
+		t.Error("the bypassed node is in the prompt")
+	}
+	// the LATENT input of the bypassed KSampler is passed to its LATENT output
+	if got := inputValue(p, 8, "samples"); got != "[5 0]" {
+		t.Errorf("samples of the VAEDecode = %s, want [5 0]", got)
+	}
+	if err := graph.GetNodeById(3).SetMode(7); err == nil {
+		t.Error("an invalid mode is accepted")
+	}
+}
+
+func TestGraphToPromptMuted(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	if err := graph.SetGroupMode(graph.GetGroupWithTitle("API"), NodeModeNever); err != nil {
+		t.Fatal(err)
+	}
+	if !graph.GetNodeById(6).IsMuted() || !graph.GetNodeById(7).IsMuted() || graph.GetNodeById(3).IsMuted() {
+		t.Fatal("only the nodes of the group are muted")
+	}
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{6, 7} {
+		if _, ok := p.Nodes[id]; ok {
+			t.Errorf("the muted node %d is in the prompt", id)
+		}
+	}
+	if got := inputValue(p, 3, "positive"); got != "<no input>" {
+		t.Errorf("the input linked to a muted node is sent as %s", got)
+	}
+}
+
+func TestGraphToPromptPrimitive(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "primitive.json")
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Nodes[10]; ok {
+		t.Error("the PrimitiveNode is in the prompt")
+	}
+	if got := inputValue(p, 3, "seed"); got != "7" {
+		t.Errorf("seed sent as %s, want the value of the primitive", got)
+	}
+
+	if err := graph.GetNodeById(10).GetPropertyWithName("value").SetValue(9); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = graph.GraphToPrompt("client"); err != nil {
+		t.Fatal(err)
+	}
+	if got := inputValue(p, 3, "seed"); got != "9" {
+		t.Errorf("seed sent as %s after setting the primitive, want 9", got)
+	}
+}
+
+func TestGraphToPromptPrimitiveThroughReroutes(t *testing.T) {
+	data, err := os.ReadFile("testdata/primitive.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workflow map[string]interface{}
+	if err := json.Unmarshal(data, &workflow); err != nil {
+		t.Fatal(err)
+	}
+	// the primitive reaches the seed through two Reroutes: 10 -> 11 -> 12 -> 3
+	reroute := func(id int, in int, out int) map[string]interface{} {
+		return map[string]interface{}{
+			"id": id, "type": "Reroute", "mode": 0, "order": 0, "pos": []int{0, 0}, "size": []int{75, 26},
+			"inputs":     []interface{}{map[string]interface{}{"name": "", "type": "*", "link": in}},
+			"outputs":    []interface{}{map[string]interface{}{"name": "", "type": "INT", "links": []int{out}}},
+			"properties": map[string]interface{}{"showOutputText": false, "horizontal": false},
+		}
+	}
+	workflow["nodes"] = append(workflow["nodes"].([]interface{}), reroute(11, 10, 11), reroute(12, 11, 12))
+	links := workflow["links"].([]interface{})
+	links[len(links)-1] = []interface{}{10, 10, 0, 11, 0, "INT"}
+	workflow["links"] = append(links, []interface{}{11, 11, 0, 12, 0, "INT"}, []interface{}{12, 12, 0, 3, 4, "INT"})
+	workflow["last_node_id"], workflow["last_link_id"] = 12, 12
+	for _, n := range workflow["nodes"].([]interface{}) {
+		if node := n.(map[string]interface{}); node["id"] == 3.0 {
+			node["inputs"].([]interface{})[4].(map[string]interface{})["link"] = 12
+		}
+	}
+	data, _ = json.Marshal(workflow)
+
+	graph, _, err := NewGraphFromJsonString(string(data), testNodeObjects(t, comfytest.DefaultObjectInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{10, 11, 12} {
+		if _, ok := p.Nodes[id]; ok {
+			t.Errorf("the virtual node %d is in the prompt", id)
+		}
+	}
+	if got := inputValue(p, 3, "seed"); got != "7" {
+		t.Errorf("seed sent as %s, want the value of the primitive", got)
+	}
+}
+
+func TestGraphToPromptComposite(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "composite.json")
+
+	p, err := graph.GraphToPrompt("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Nodes) != 3 || len(p.InnerNodes) != 4 {
+		t.Fatalf("prompt has %d nodes and %d inner nodes, want 3 and 4", len(p.Nodes), len(p.InnerNodes))
+	}
+	for id, want := range map[string]string{"2:0": "CLIPTextEncode", "2:1": "CLIPTextEncode", "4:1": "KSampler", "4:2": "VAEDecode"} {
+		if pn, ok := p.InnerNodes[id]; !ok || pn.ClassType != want {
+			t.Errorf("inner node %s is missing or not a %s", id, want)
+		}
+	}
+	if got := fmt.Sprint(p.InnerNodes["2:0"].Inputs["text"]); got != "a cat" {
+		t.Errorf("text of the group node's first node = %s", got)
+	}
+	if got := fmt.Sprint(p.InnerNodes["4:1"].Inputs["positive"]); got != "[2:0 0]" {
+		t.Errorf("positive of the subgraph's KSampler = %s, want [2:0 0]", got)
+	}
+	if got := inputValue(p, 5, "images"); got != "[4:2 0]" {
+		t.Errorf("images of the SaveImage = %s, want [4:2 0]", got)
+	}
+}
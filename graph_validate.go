@@ -0,0 +1,440 @@
+package comfy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// GraphProblemType identifies the kind of problem found by Graph.Validate.  Where ComfyUI reports
+// the same problem when validating a prompt, the same name is used.
+type GraphProblemType string
+
+const (
+	ProblemUnknownNodeType      GraphProblemType = "unknown_node_type"
+	ProblemBrokenLink           GraphProblemType = "broken_link"
+	ProblemReturnTypeMismatch   GraphProblemType = "return_type_mismatch"
+	ProblemRequiredInputMissing GraphProblemType = "required_input_missing"
+	ProblemInvalidInputType     GraphProblemType = "invalid_input_type"
+	ProblemValueSmallerThanMin  GraphProblemType = "value_smaller_than_min"
+	ProblemValueBiggerThanMax   GraphProblemType = "value_bigger_than_max"
+	ProblemValueNotInList       GraphProblemType = "value_not_in_list"
+	ProblemCycle                GraphProblemType = "cycle"
+)
+
+// GraphProblem is a single problem found by Graph.Validate
+type GraphProblem struct {
+	Type      GraphProblemType
+	NodeID    int    // 0 for problems with links that are not attached to a node
+	NodeType  string // empty when the node does not exist
+	InputName string // empty when the problem is not related to a specific input
+	LinkID    int    // non-zero for problems with a link
+	Value     interface{}
+	Message   string
+}
+
+func (p GraphProblem) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(p.Type))
+	if p.NodeID != 0 {
+		fmt.Fprintf(&sb, " node %d", p.NodeID)
+		if p.NodeType != "" {
+			fmt.Fprintf(&sb, " (%s)", p.NodeType)
+		}
+	}
+	if p.InputName != "" {
+		fmt.Fprintf(&sb, " input %s", p.InputName)
+	}
+	if p.LinkID != 0 {
+		fmt.Fprintf(&sb, " link %d", p.LinkID)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(p.Message)
+	return sb.String()
+}
+
+// Validate checks the graph against the node_objects before it is queued, and returns the problems that
+// ComfyUI would reject the prompt for: links between slots of different types, missing required inputs,
+// numbers out of range, combo values not in the list of values and cycles.  Muted, bypassed and frontend
+// only nodes are not validated, the values of PrimitiveNodes are validated in place of the widgets they
+// set.  The graph's own NodeObjects are used when node_objects is nil.
+func (t *Graph) Validate(node_objects *NodeObjects) []GraphProblem {
+	if node_objects == nil {
+		node_objects = t.nodeObjects
+	}
+	retv := make([]GraphProblem, 0)
+
+	nodes := make([]*GraphNode, len(t.Nodes))
+	copy(nodes, t.Nodes)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	retv = append(retv, t.validateLinks()...)
+
+	for _, n := range nodes {
+		if n.IsVirtual() || n.Type == "Note" || n.IsMuted() || n.IsBypassed() {
+			continue
+		}
+		if n.IsComposite() {
+			// the problems of the inner nodes are reported for the group node or subgraph
+			for _, problem := range n.InnerGraph.Validate(node_objects) {
+				problem.Message = fmt.Sprintf("inner node %d (%s) of node %d (%s): %s", problem.NodeID, problem.NodeType, n.ID, n.Type, problem.Message)
+				problem.NodeID = n.ID
+				problem.NodeType = n.Type
+				retv = append(retv, problem)
+			}
+			continue
+		}
+		var nobject *NodeObject
+		if node_objects != nil {
+			nobject = node_objects.GetNodeObjectByName(n.Type)
+		}
+		if nobject == nil {
+			retv = append(retv, GraphProblem{
+				Type:     ProblemUnknownNodeType,
+				NodeID:   n.ID,
+				NodeType: n.Type,
+				Message:  fmt.Sprintf("node type %s is not available on the server", n.Type),
+			})
+			continue
+		}
+		retv = append(retv, t.validateNodeInputs(n, nobject)...)
+	}
+
+	retv = append(retv, t.findCycles(nodes)...)
+	return retv
+}
+
+// validateLinks checks that every link connects existing slots of matching types
+func (t *Graph) validateLinks() []GraphProblem {
+	retv := make([]GraphProblem, 0)
+	links := make([]*Link, len(t.Links))
+	copy(links, t.Links)
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+
+	for _, l := range links {
+		if l.OriginID == subgraphInputNodeID || l.TargetID == subgraphOutputNodeID {
+			// links to the node containing the graph are checked by the node
+			continue
+		}
+		origin := t.GetNodeById(l.OriginID)
+		target := t.GetNodeById(l.TargetID)
+		switch {
+		case origin == nil || l.OriginSlot < 0 || l.OriginSlot >= len(origin.Outputs):
+			retv = append(retv, GraphProblem{
+				Type:    ProblemBrokenLink,
+				NodeID:  l.TargetID,
+				LinkID:  l.ID,
+				Message: fmt.Sprintf("link %d starts at missing output %d of node %d", l.ID, l.OriginSlot, l.OriginID),
+			})
+			continue
+		case target == nil || l.TargetSlot < 0 || l.TargetSlot >= len(target.Inputs):
+			retv = append(retv, GraphProblem{
+				Type:    ProblemBrokenLink,
+				NodeID:  l.OriginID,
+				LinkID:  l.ID,
+				Message: fmt.Sprintf("link %d ends at missing input %d of node %d", l.ID, l.TargetSlot, l.TargetID),
+			})
+			continue
+		}
+
+		output := origin.Outputs[l.OriginSlot]
+		input := target.Inputs[l.TargetSlot]
+		if input.Link != l.ID {
+			retv = append(retv, GraphProblem{
+				Type:      ProblemBrokenLink,
+				NodeID:    target.ID,
+				NodeType:  target.Type,
+				InputName: input.Name,
+				LinkID:    l.ID,
+				Message:   fmt.Sprintf("input is linked to %d instead of link %d", input.Link, l.ID),
+			})
+		}
+		if !slotTypesMatch(output.Type, input.Type) || !slotTypesMatch(l.Type, input.Type) || !slotTypesMatch(output.Type, l.Type) {
+			retv = append(retv, GraphProblem{
+				Type:      ProblemReturnTypeMismatch,
+				NodeID:    target.ID,
+				NodeType:  target.Type,
+				InputName: input.Name,
+				LinkID:    l.ID,
+				Message: fmt.Sprintf("output %s (%s) of node %d is linked as %s to input of type %s",
+					output.Name, output.Type, origin.ID, l.Type, input.Type),
+			})
+		}
+	}
+	return retv
+}
+
+// inputIsLinked returns true if the named input of the node receives a value from another node.
+// Links through reroutes and bypassed nodes are followed to the node providing the value, inputs of
+// inner nodes linked to the node containing the graph are assumed to receive a value.
+func (t *Graph) inputIsLinked(n *GraphNode, name string) bool {
+	index := n.GetInputIndex(name)
+	if index < 0 {
+		return false
+	}
+	l, err := t.resolveInputLink(n, index)
+	if err != nil || l == nil {
+		return false
+	}
+	if l.OriginID == subgraphInputNodeID {
+		return true
+	}
+	origin := t.GetNodeById(l.OriginID)
+	return origin != nil && !origin.IsMuted()
+}
+
+// primitiveValue returns the value of the PrimitiveNode linked to the named input of the node, directly or
+// through Reroutes.  false is returned when the input is not linked to a primitive.
+func (t *Graph) primitiveValue(n *GraphNode, name string) (interface{}, bool) {
+	index := n.GetInputIndex(name)
+	if index < 0 {
+		return nil, false
+	}
+	visited := make(map[int]bool)
+	l := n.GetInputLink(index)
+	for l != nil {
+		origin := t.GetNodeById(l.OriginID)
+		if origin == nil || visited[origin.ID] {
+			return nil, false
+		}
+		visited[origin.ID] = true
+		switch origin.Type {
+		case "PrimitiveNode":
+			values := origin.WidgetValuesArray()
+			if len(values) == 0 {
+				return nil, false
+			}
+			return values[0], true
+		case "Reroute":
+			l = origin.GetInputLink(0)
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (t *Graph) validateNodeInputs(n *GraphNode, nobject *NodeObject) []GraphProblem {
+	retv := make([]GraphProblem, 0)
+	if nobject.Input == nil {
+		return retv
+	}
+
+	names := append(append([]string{}, nobject.Input.OrderedRequired...), nobject.Input.OrderedOptional...)
+	for _, name := range names {
+		p, ok := nobject.InputPropertiesByID[name]
+		if !ok {
+			continue
+		}
+		prop := *p
+		if t.inputIsLinked(n, name) {
+			continue
+		}
+
+		if !prop.Settable() {
+			if !prop.Optional() {
+				retv = append(retv, GraphProblem{
+					Type:      ProblemRequiredInputMissing,
+					NodeID:    n.ID,
+					NodeType:  n.Type,
+					InputName: name,
+					Message:   fmt.Sprintf("required input %s (%s) is not linked", name, prop.TypeString()),
+				})
+			}
+			continue
+		}
+
+		var value interface{}
+		if v, ok := t.primitiveValue(n, name); ok {
+			// GraphToPrompt replaces the value of the widget with the one of the primitive
+			value = v
+		} else if np := n.GetPropertyWithName(name); np != nil {
+			value = np.GetValue()
+		}
+		if value == nil {
+			if !prop.Optional() {
+				retv = append(retv, GraphProblem{
+					Type:      ProblemRequiredInputMissing,
+					NodeID:    n.ID,
+					NodeType:  n.Type,
+					InputName: name,
+					Message:   fmt.Sprintf("required input %s has no value", name),
+				})
+			}
+			continue
+		}
+
+		if problem := validateValue(prop, value); problem != nil {
+			problem.NodeID = n.ID
+			problem.NodeType = n.Type
+			problem.InputName = name
+			retv = append(retv, *problem)
+		}
+	}
+	return retv
+}
+
+func numberValue(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+// validateValue checks a widget value against the constraints of the property it is set on
+func validateValue(prop Property, value interface{}) *GraphProblem {
+	invalid := func(expected string) *GraphProblem {
+		return &GraphProblem{
+			Type:    ProblemInvalidInputType,
+			Value:   value,
+			Message: fmt.Sprintf("%v (%T) is not a valid %s", value, value, expected),
+		}
+	}
+	outOfRange := func(min bool, limit interface{}) *GraphProblem {
+		if min {
+			return &GraphProblem{Type: ProblemValueSmallerThanMin, Value: value, Message: fmt.Sprintf("%v is smaller than the minimum %v", value, limit)}
+		}
+		return &GraphProblem{Type: ProblemValueBiggerThanMax, Value: value, Message: fmt.Sprintf("%v is bigger than the maximum %v", value, limit)}
+	}
+
+	switch prop.TypeString() {
+	case "INT":
+		ip, _ := prop.ToIntProperty()
+		f, ok := numberValue(value)
+		if !ok || f != math.Trunc(f) {
+			return invalid("INT")
+		}
+		if ip.HasRange() {
+			// compare as floats, the maximum of a seed does not fit in an int64
+			if f < float64(ip.Min) {
+				return outOfRange(true, ip.Min)
+			}
+			if f > float64(ip.Max) {
+				return outOfRange(false, ip.Max)
+			}
+		}
+	case "FLOAT":
+		fp, _ := prop.ToFloatProperty()
+		f, ok := numberValue(value)
+		if !ok || math.IsNaN(f) {
+			return invalid("FLOAT")
+		}
+		if fp.HasRange() {
+			if f < fp.Min {
+				return outOfRange(true, fp.Min)
+			}
+			if f > fp.Max {
+				return outOfRange(false, fp.Max)
+			}
+		}
+	case "STRING":
+		if _, ok := value.(string); !ok {
+			return invalid("STRING")
+		}
+	case "BOOLEAN":
+		if _, ok := value.(bool); !ok {
+			return invalid("BOOLEAN")
+		}
+	case "COMBO":
+		cp, _ := prop.ToComboProperty()
+		// the values of a remote combo are not known
+		if cp.Remote != "" {
+			return nil
+		}
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok && cp.MultiSelect {
+			values = list
+		}
+		for _, value := range values {
+			if !comboHasValue(cp, value) {
+				return &GraphProblem{
+					Type:    ProblemValueNotInList,
+					Value:   value,
+					Message: fmt.Sprintf("%v is not one of the %d values of the list", value, len(cp.Values)),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func comboHasValue(cp *ComboProperty, value interface{}) bool {
+	s := fmt.Sprintf("%v", value)
+	for _, v := range cp.Values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findCycles returns a problem for every cycle of links between the nodes
+func (t *Graph) findCycles(nodes []*GraphNode) []GraphProblem {
+	retv := make([]GraphProblem, 0)
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[int]int)
+	path := make([]int, 0)
+
+	var visit func(n *GraphNode)
+	visit = func(n *GraphNode) {
+		state[n.ID] = visiting
+		path = append(path, n.ID)
+		for i := range n.Inputs {
+			parent := n.GetNodeForInput(i)
+			if parent == nil {
+				continue
+			}
+			switch state[parent.ID] {
+			case unvisited:
+				visit(parent)
+			case visiting:
+				// the cycle is the part of the path from the parent to this node
+				start := 0
+				for j, id := range path {
+					if id == parent.ID {
+						start = j
+						break
+					}
+				}
+				cycle := make([]string, 0, len(path)-start+1)
+				for _, id := range path[start:] {
+					cycle = append(cycle, fmt.Sprintf("%d", id))
+				}
+				cycle = append(cycle, fmt.Sprintf("%d", parent.ID))
+				retv = append(retv, GraphProblem{
+					Type:      ProblemCycle,
+					NodeID:    n.ID,
+					NodeType:  n.Type,
+					InputName: n.Inputs[i].Name,
+					LinkID:    n.Inputs[i].Link,
+					Message:   fmt.Sprintf("nodes are linked in a cycle: %s", strings.Join(cycle, " <- ")),
+				})
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.ID] = visited
+	}
+
+	for _, n := range nodes {
+		if state[n.ID] == unvisited {
+			visit(n)
+		}
+	}
+	return retv
+}
@@ -0,0 +1,125 @@
+package comfy
+
+import (
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+// KSampler widget values: seed, control_after_generate, steps, cfg, sampler_name, scheduler, denoise
+const (
+	testStepsWidget   = 2
+	testSamplerWidget = 4
+)
+
+// expectProblem fails the test unless the problems are a single problem of the type on the input of the node
+func expectProblem(t *testing.T, problems []GraphProblem, ptype GraphProblemType, nodeID int, inputName string) {
+	t.Helper()
+	if len(problems) != 1 {
+		t.Fatalf("problems %v, want a single %s", problems, ptype)
+	}
+	p := problems[0]
+	if p.Type != ptype || p.NodeID != nodeID || p.InputName != inputName {
+		t.Errorf("problem %v, want %s on input %s of node %d", p, ptype, inputName, nodeID)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	graph, _ := buildTestGraph(t)
+	if problems := graph.Validate(nil); len(problems) != 0 {
+		t.Errorf("the built graph has problems %v", problems)
+	}
+}
+
+func TestValidateLinkTypeMismatch(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	// the latent input now receives the MODEL of the checkpoint loader
+	l := graph.GetLinkById(ks.Inputs[3].Link)
+	l.OriginID, l.OriginSlot, l.Type = nodes["CheckpointLoaderSimple"].ID, 0, "MODEL"
+
+	expectProblem(t, graph.Validate(nil), ProblemReturnTypeMismatch, ks.ID, "latent_image")
+}
+
+func TestValidateRequiredInputMissing(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	if err := graph.Disconnect(graph.GetLinkById(ks.Inputs[0].Link)); err != nil {
+		t.Fatal(err)
+	}
+
+	expectProblem(t, graph.Validate(nil), ProblemRequiredInputMissing, ks.ID, "model")
+}
+
+func TestValidateValueOutOfRange(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	ks.WidgetValuesArray()[testStepsWidget] = 100000.0
+	expectProblem(t, graph.Validate(nil), ProblemValueBiggerThanMax, ks.ID, "steps")
+
+	ks.WidgetValuesArray()[testStepsWidget] = 0.0
+	expectProblem(t, graph.Validate(nil), ProblemValueSmallerThanMin, ks.ID, "steps")
+
+	ks.WidgetValuesArray()[testStepsWidget] = 2.5
+	expectProblem(t, graph.Validate(nil), ProblemInvalidInputType, ks.ID, "steps")
+}
+
+func TestValidateValueNotInList(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	ks.WidgetValuesArray()[testSamplerWidget] = "not_a_sampler"
+
+	problems := graph.Validate(nil)
+	expectProblem(t, problems, ProblemValueNotInList, ks.ID, "sampler_name")
+	if problems[0].Value != "not_a_sampler" {
+		t.Errorf("problem value %v, want not_a_sampler", problems[0].Value)
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	graph, nodes := buildTestGraph(t)
+	ks := nodes["KSampler"]
+	second, err := graph.AddNode("KSampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{"model", "positive", "negative"} {
+		l := graph.GetLinkById(ks.Inputs[ks.GetInputIndex(in)].Link)
+		if _, err := graph.ConnectSlots(graph.GetNodeById(l.OriginID), l.OriginSlot, second, second.GetInputIndex(in)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	back, err := graph.Connect(ks, "LATENT", second, "latent_image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the latent of the first sampler now comes from the second sampler, which samples the first
+	l := graph.GetLinkById(ks.Inputs[3].Link)
+	l.OriginID = second.ID
+
+	problems := graph.Validate(nil)
+	if len(problems) != 1 || problems[0].Type != ProblemCycle {
+		t.Fatalf("problems %v, want a single cycle", problems)
+	}
+	if id := problems[0].LinkID; id != l.ID && id != back.ID {
+		t.Errorf("the cycle is reported on link %d, want %d or %d", id, l.ID, back.ID)
+	}
+}
+
+func TestValidatePrimitiveValue(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "primitive.json")
+	ks, primitive := graph.GetNodeById(3), graph.GetNodeById(10)
+
+	// the seed of the KSampler is replaced by the value of the primitive
+	ks.WidgetValuesArray()[0] = -5.0
+	if problems := graph.Validate(nil); len(problems) != 0 {
+		t.Errorf("the value of the widget set by the primitive is validated: %v", problems)
+	}
+
+	primitive.WidgetValuesArray()[0] = -1.0
+	problems := graph.Validate(nil)
+	expectProblem(t, problems, ProblemValueSmallerThanMin, ks.ID, "seed")
+	if problems[0].Value != -1.0 {
+		t.Errorf("problem value %v, want the value of the primitive", problems[0].Value)
+	}
+}
@@ -1,9 +1,12 @@
 package comfy
 
 type Group struct {
-	Title    string `json:"title"`
-	Bounding []int  `json:"bounding"`
-	Color    string `json:"color"`
+	ID       int                    `json:"id,omitempty"` // set in schema 1.0 workflows
+	Title    string                 `json:"title"`
+	Bounding []float64              `json:"bounding"`
+	Color    string                 `json:"color"`
+	FontSize float64                `json:"font_size,omitempty"`
+	Flags    map[string]interface{} `json:"flags,omitempty"`
 }
 
 func (r *Group) IntersectsOrContains(node *GraphNode) bool {
@@ -12,10 +15,10 @@ func (r *Group) IntersectsOrContains(node *GraphNode) bool {
 	}
 
 	// the geometry is stored differently for nodes and groups
-	rx := float64(r.Bounding[0])
-	ry := float64(r.Bounding[1])
-	rw := float64(r.Bounding[2])
-	rh := float64(r.Bounding[3])
+	rx := r.Bounding[0]
+	ry := r.Bounding[1]
+	rw := r.Bounding[2]
+	rh := r.Bounding[3]
 
 	pos, ok := node.Position.([]interface{})
 	if !ok {
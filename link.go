@@ -1,8 +1,11 @@
 package comfy
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 )
 
 type Link struct {
@@ -12,9 +15,114 @@ type Link struct {
 	TargetID   int
 	TargetSlot int
 	Type       string
+	ParentID   int // the id of the last Reroute the link passes through, 0 for none
+	// the ids of the nodes when they are not numbers, such as UUIDs, until the graph resolves them
+	originRef string
+	targetRef string
+}
+
+// linkObject is the form of a link used by subgraphs and schema 1.0 workflows
+type linkObject struct {
+	ID         linkID      `json:"id"`
+	OriginID   nodeRef     `json:"origin_id"`
+	OriginSlot int         `json:"origin_slot"`
+	TargetID   nodeRef     `json:"target_id"`
+	TargetSlot int         `json:"target_slot"`
+	Type       interface{} `json:"type"`
+	ParentID   linkID      `json:"parentId,omitempty"`
+}
+
+// linkID is the id of a link or of a reroute, which the schema allows to be a number or a string
+type linkID int
+
+func (id *linkID) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	i, err := linkIDValue(v)
+	if err != nil {
+		return err
+	}
+	*id = linkID(i)
+	return nil
+}
+
+// linkIDValue returns the id of a decoded link field, a null id is 0.  Link ids are numbers, which
+// may be written as strings.
+func linkIDValue(v interface{}) (int, error) {
+	switch val := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int(val), nil
+	case string:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("link id %q is not a number", val)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("invalid link id %v", v)
+}
+
+// nodeRef is the id of a node connected by a link.  Schema 1.0 workflows allow node ids to be strings,
+// ids that are not numbers, such as UUIDs, are kept in ref.
+type nodeRef struct {
+	id  int
+	ref string
+}
+
+// nodeRefValue returns the id of the node referenced by a decoded link field
+func nodeRefValue(v interface{}) (nodeRef, error) {
+	if s, ok := v.(string); ok && s != "" {
+		if _, err := strconv.Atoi(s); err != nil {
+			return nodeRef{ref: s}, nil
+		}
+	}
+	id, err := linkIDValue(v)
+	if err != nil {
+		return nodeRef{}, fmt.Errorf("invalid node id %v", v)
+	}
+	return nodeRef{id: id}, nil
+}
+
+func (r *nodeRef) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	ref, err := nodeRefValue(v)
+	if err != nil {
+		return err
+	}
+	*r = ref
+	return nil
+}
+
+func (r nodeRef) MarshalJSON() ([]byte, error) {
+	if r.ref != "" {
+		return json.Marshal(r.ref)
+	}
+	return json.Marshal(r.id)
 }
 
 func (l *Link) UnmarshalJSON(b []byte) error {
+	if trimmed := bytes.TrimSpace(b); len(trimmed) != 0 && trimmed[0] == '{' {
+		var obj linkObject
+		if err := json.Unmarshal(trimmed, &obj); err != nil {
+			return err
+		}
+		l.ID = int(obj.ID)
+		l.OriginID, l.originRef = obj.OriginID.id, obj.OriginID.ref
+		l.OriginSlot = obj.OriginSlot
+		l.TargetID, l.targetRef = obj.TargetID.id, obj.TargetID.ref
+		l.TargetSlot = obj.TargetSlot
+		l.Type, _ = obj.Type.(string)
+		l.ParentID = int(obj.ParentID)
+		return nil
+	}
+
 	var tmp []interface{}
 	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
@@ -22,11 +130,26 @@ func (l *Link) UnmarshalJSON(b []byte) error {
 	if len(tmp) != 6 {
 		return errors.New("wrong number of fields in JSON array")
 	}
-	l.ID = int(tmp[0].(float64))
-	l.OriginID = int(tmp[1].(float64))
-	l.OriginSlot = int(tmp[2].(float64))
-	l.TargetID = int(tmp[3].(float64))
-	l.TargetSlot = int(tmp[4].(float64))
+	var err error
+	if l.ID, err = linkIDValue(tmp[0]); err != nil {
+		return err
+	}
+	origin, err := nodeRefValue(tmp[1])
+	if err != nil {
+		return err
+	}
+	if l.OriginSlot, err = linkIDValue(tmp[2]); err != nil {
+		return err
+	}
+	target, err := nodeRefValue(tmp[3])
+	if err != nil {
+		return err
+	}
+	if l.TargetSlot, err = linkIDValue(tmp[4]); err != nil {
+		return err
+	}
+	l.OriginID, l.originRef = origin.id, origin.ref
+	l.TargetID, l.targetRef = target.id, target.ref
 	l.Type, _ = tmp[5].(string)
 	return nil
 }
@@ -42,3 +165,17 @@ func (l *Link) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal(tmp)
 }
+
+// toObject returns the link in the form used by subgraphs and schema 1.0 workflows, the nodes of the
+// graph are referenced with the ids they were read with
+func (l *Link) toObject(graph *Graph) linkObject {
+	return linkObject{
+		ID:         linkID(l.ID),
+		OriginID:   graph.nodeRef(l.OriginID),
+		OriginSlot: l.OriginSlot,
+		TargetID:   graph.nodeRef(l.TargetID),
+		TargetSlot: l.TargetSlot,
+		Type:       l.Type,
+		ParentID:   linkID(l.ParentID),
+	}
+}
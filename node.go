@@ -1,9 +1,22 @@
 package comfy
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
 	"github.com/er1cw00/comfy.go/base/logger"
 )
 
+// Node modes, as set in the frontend's node menu
+const (
+	NodeModeAlways    = 0 // the node is executed
+	NodeModeOnEvent   = 1
+	NodeModeNever     = 2 // the node is muted and not sent to ComfyUI
+	NodeModeOnTrigger = 3
+	NodeModeBypass    = 4 // the node is not sent to ComfyUI, its inputs are passed to its outputs
+)
+
 // GraphNode represents the encapsulation of an individual functionality within a Graph
 type GraphNode struct {
 	ID                 int                     `json:"id"`
@@ -30,6 +43,50 @@ type GraphNode struct {
 	DisplayName  string              `json:"-"`
 	Description  string              `json:"-"`
 	IsOutput     bool                `json:"-"`
+	InnerGraph   *Graph              `json:"-"` // the inner nodes of a group node or subgraph
+	idString     string              // the id as read when it was a string, written back as it is
+}
+
+// UnmarshalJSON reads the id of the node, which schema 1.0 workflows allow to be a string.  Numeric strings
+// are read as their number, the nodes with other ids, such as UUIDs, are given a number by their graph.
+func (n *GraphNode) UnmarshalJSON(b []byte) error {
+	type Alias GraphNode
+	tmp := struct {
+		ID interface{} `json:"id"`
+		*Alias
+	}{
+		Alias: (*Alias)(n),
+	}
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		return err
+	}
+	switch id := tmp.ID.(type) {
+	case nil:
+	case float64:
+		n.ID = int(id)
+	case string:
+		n.idString = id
+		if i, err := strconv.Atoi(id); err == nil {
+			n.ID = i
+		}
+	default:
+		return fmt.Errorf("invalid node id %v", tmp.ID)
+	}
+	return nil
+}
+
+func (n *GraphNode) MarshalJSON() ([]byte, error) {
+	type Alias GraphNode
+	if n.idString == "" {
+		return json.Marshal((*Alias)(n))
+	}
+	return json.Marshal(struct {
+		ID string `json:"id"`
+		*Alias
+	}{
+		ID:    n.idString,
+		Alias: (*Alias)(n),
+	})
 }
 
 func (n *GraphNode) WidgetValuesArray() []interface{} {
@@ -85,14 +142,29 @@ func (n *GraphNode) IsVirtual() bool {
 	return false
 }
 
-// GetLinks returns a slice of Link Ids
+// GetLinks returns a slice of the Link Ids of the node's first output.  Links to Reroute nodes are
+// replaced by the links of the Reroute, for chains of Reroutes of any depth.
 func (n *GraphNode) GetLinks() []int {
+	return n.getLinks(make(map[int]bool))
+}
+
+func (n *GraphNode) getLinks(visited map[int]bool) []int {
 	retv := make([]int, 0)
+	if visited[n.ID] || len(n.Outputs) == 0 || n.Outputs[0].Links == nil {
+		return retv
+	}
+	visited[n.ID] = true
 	for _, l := range *n.Outputs[0].Links {
-		linkInfo := n.Graph.LinksByID[l]
+		linkInfo := n.Graph.GetLinkById(l)
+		if linkInfo == nil {
+			continue
+		}
 		tn := n.Graph.GetNodeById(linkInfo.TargetID)
-		if tn.Type == "Rerout" {
-			retv = append(retv, tn.GetLinks()...)
+		if tn == nil {
+			continue
+		}
+		if tn.Type == "Reroute" {
+			retv = append(retv, tn.getLinks(visited)...)
 		} else {
 			retv = append(retv, l)
 		}
@@ -192,41 +264,59 @@ func (n *GraphNode) affixPropertyToInputSlot(name string, p Property) {
 	}
 }
 
-func (n *GraphNode) ApplyToGraph() {
+// SetMode sets the mode of the node to one of the NodeMode constants
+func (n *GraphNode) SetMode(mode int) error {
+	if mode < NodeModeAlways || mode > NodeModeBypass {
+		return fmt.Errorf("invalid node mode %d", mode)
+	}
+	n.Mode = mode
+	return nil
+}
+
+// IsMuted returns true if the node will not be executed and its outputs are not available
+func (n *GraphNode) IsMuted() bool {
+	return n.Mode == NodeModeNever
+}
+
+// IsBypassed returns true if the node will not be executed and its inputs are passed to its outputs
+func (n *GraphNode) IsBypassed() bool {
+	return n.Mode == NodeModeBypass
+}
+
+// ApplyToGraph copies the value of a PrimitiveNode to the widget of every node it is linked to,
+// directly or through Reroutes, like the frontend does before queueing a prompt.
+func (n *GraphNode) ApplyToGraph() error {
 	// only PrimitiveNode need apply
 	if n.Type != "PrimitiveNode" {
-		return
+		return nil
 	}
 
-	if n.Outputs[0].Links == nil || len(*n.Outputs[0].Links) == 0 {
-		return
+	values := n.WidgetValuesArray()
+	if len(values) == 0 {
+		return nil
 	}
 
-	links := n.GetLinks()
 	// For each output link copy our value over the original widget value
-	for _, l := range links {
-		linkinfo := n.Graph.LinksByID[l]
+	for _, l := range n.GetLinks() {
+		linkinfo := n.Graph.GetLinkById(l)
 		node := n.Graph.GetNodeById(linkinfo.TargetID)
-		input := node.Inputs[linkinfo.TargetSlot]
-		widgetName := input.Widget.Name
-		if widgetName != nil {
-			// Nodes need a distinct Widget class
-			// widget.value = this.widgets[0].value;
-			// fmt.Print()
+		if linkinfo.TargetSlot >= len(node.Inputs) {
+			return fmt.Errorf("%w: primitive %d is linked to missing input %d of node %d", ErrVirtualNodeDeadEnd, n.ID, linkinfo.TargetSlot, node.ID)
 		}
-
-		/*
-			widgetName := input.Widget.
-			const widgetName = input.widget.name;
-			if (widgetName) {
-				const widget = node.widgets.find((w) => w.name === widgetName);
-				if (widget) {
-					widget.value = this.widgets[0].value;
-					if (widget.callback) {
-						widget.callback(widget.value, app.canvas, node, app.canvas.graph_mouse, {});
-					}
-				}
+		input := node.Inputs[linkinfo.TargetSlot]
+		prop := input.Property
+		if input.Widget != nil && input.Widget.Name != nil {
+			if p := node.GetPropertyWithName(*input.Widget.Name); p != nil {
+				prop = p
 			}
-		*/
+		}
+		if prop == nil {
+			return fmt.Errorf("%w: primitive %d is linked to input %s of node %d (%s), which is not a widget",
+				ErrVirtualNodeDeadEnd, n.ID, input.Name, node.ID, node.Type)
+		}
+		if err := prop.SetValue(values[0]); err != nil {
+			return fmt.Errorf("primitive %d cannot set %s of node %d (%s): %w", n.ID, prop.Name(), node.ID, node.Type, err)
+		}
 	}
+	return nil
 }
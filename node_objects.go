@@ -160,8 +160,8 @@ func (n *NodeObjects) PopulateInputProperties() {
 				continue
 			}
 
-			// handle seed and noise_seed int controls
-			if ((*nprop).Name() == "seed" || (*nprop).Name() == "noise_seed") && (*nprop).TypeString() == "INT" {
+			// handle seed and noise_seed int controls, inputs without a widget have no control
+			if ((*nprop).Name() == "seed" || (*nprop).Name() == "noise_seed") && (*nprop).TypeString() == "INT" && (*nprop).Settable() {
 				ns_prop := NewPropertyFromInput("control_after_generate", (*nprop).Optional(), &car, index)
 				index++
 				(*ns_prop).SetSerializable(false)
@@ -183,8 +183,8 @@ func (n *NodeObjects) PopulateInputProperties() {
 					continue
 				}
 
-				// handle seed and noise_seed int controls
-				if (*nprop).Name() == "seed" || (*nprop).Name() == "noise_seed" && (*nprop).TypeString() == "INT" {
+				// handle seed and noise_seed int controls, inputs without a widget have no control
+				if ((*nprop).Name() == "seed" || (*nprop).Name() == "noise_seed") && (*nprop).TypeString() == "INT" && (*nprop).Settable() {
 					ns_prop := NewPropertyFromInput("control_after_generate", (*nprop).Optional(), &car, index)
 					index++
 					o.InputProperties = append(o.InputProperties, ns_prop)
@@ -1,11 +1,86 @@
 package comfy
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/er1cw00/comfy.go/base/logger"
+)
+
 // Prompt is the data that is enqueued to an instance of ComfyUI
 type Prompt struct {
-	ClientID  string             `json:"client_id"`
-	Nodes     map[int]PromptNode `json:"prompt"`
-	ExtraData PromptExtraData    `json:"extra_data"`
-	PID       string             `json:"pid"`
+	ClientID  string              `json:"client_id"`
+	Nodes     map[int]*PromptNode `json:"prompt"`
+	ExtraData PromptExtraData     `json:"extra_data"`
+	PID       string              `json:"pid"`
+	// InnerNodes are the nodes of group nodes and subgraphs, by their namespaced id such as "12:3"
+	InnerNodes map[string]*PromptNode `json:"-"`
+	// DynamicPrompts are the dynamic prompts expanded by GraphToPrompt, whose text is in the inputs
+	DynamicPrompts []DynamicPrompt `json:"-"`
+	nodeObjects    *NodeObjects
+}
+
+// promptJSON is the form of a prompt POSTed to /prompt, with the ids of the nodes as strings
+type promptJSON struct {
+	ClientID  string                 `json:"client_id"`
+	Nodes     map[string]*PromptNode `json:"prompt"`
+	ExtraData PromptExtraData        `json:"extra_data"`
+	PID       string                 `json:"pid"`
+}
+
+func (p Prompt) MarshalJSON() ([]byte, error) {
+	tmp := promptJSON{
+		ClientID:  p.ClientID,
+		Nodes:     make(map[string]*PromptNode, len(p.Nodes)+len(p.InnerNodes)),
+		ExtraData: p.ExtraData,
+		PID:       p.PID,
+	}
+	for id, n := range p.Nodes {
+		tmp.Nodes[strconv.Itoa(id)] = n
+	}
+	for id, n := range p.InnerNodes {
+		tmp.Nodes[id] = n
+	}
+	return json.Marshal(tmp)
+}
+
+func (p *Prompt) UnmarshalJSON(b []byte) error {
+	var tmp promptJSON
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		return err
+	}
+	p.ClientID = tmp.ClientID
+	p.ExtraData = tmp.ExtraData
+	p.PID = tmp.PID
+	return p.setNodes(tmp.Nodes)
+}
+
+// setNodes sets the nodes of the prompt from nodes by their id in the JSON, namespaced ids of inner
+// nodes are kept in InnerNodes
+func (p *Prompt) setNodes(nodes map[string]*PromptNode) error {
+	p.Nodes = make(map[int]*PromptNode)
+	p.InnerNodes = nil
+	for k, n := range nodes {
+		if id, err := strconv.Atoi(k); err == nil {
+			n.ID = id
+			p.Nodes[id] = n
+			continue
+		}
+		if !strings.Contains(k, ":") {
+			return fmt.Errorf("invalid node id %q", k)
+		}
+		if p.InnerNodes == nil {
+			p.InnerNodes = make(map[string]*PromptNode)
+		}
+		p.InnerNodes[k] = n
+	}
+	return nil
 }
 
 type PromptNode struct {
@@ -16,6 +91,18 @@ type PromptNode struct {
 	//					     [1] is float64 (int) of slot index
 	Inputs    map[string]interface{} `json:"inputs"`
 	ClassType string                 `json:"class_type"`
+	Meta      *PromptNodeMeta        `json:"_meta,omitempty"`
+	ID        int                    `json:"-"`
+	// Properties are only available for prompts loaded with NewPromptFromJsonReader, and set
+	// the values in Inputs
+	Properties  map[string]Property `json:"-"`
+	DisplayName string              `json:"-"`
+	IsOutput    bool                `json:"-"`
+}
+
+// PromptNodeMeta is the frontend information saved with a node in the API format
+type PromptNodeMeta struct {
+	Title string `json:"title"`
 }
 
 type PromptExtraData struct {
@@ -24,7 +111,369 @@ type PromptExtraData struct {
 
 // PromptWorkflow is the original Graph that was used to create the Prompt.
 // It is added to generated PNG files such that the information needed to
-// recreate the image is available.
+// recreate the image is available.  It is left out when there is no Graph.
 type PromptWorkflow struct {
-	Workflow *Graph `json:"workflow"`
+	Workflow *Graph `json:"workflow,omitempty"`
+}
+
+// NewPromptFromJsonReader creates a prompt from "API format" JSON read from an io.Reader, as saved by
+// the frontend's "Save (API Format)".  The JSON may also be a complete prompt as POSTed to /prompt.
+// The properties of the prompt's nodes are created from the node_objects, when they are nil the properties
+// are created by QueueAPIPrompt from those of the client.
+func NewPromptFromJsonReader(r io.Reader, node_objects *NodeObjects) (*Prompt, *[]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+
+	prompt := &Prompt{}
+	if _, ok := raw["prompt"]; ok {
+		err = json.Unmarshal(data, prompt)
+	} else {
+		nodes := make(map[string]*PromptNode)
+		if err = json.Unmarshal(data, &nodes); err == nil {
+			err = prompt.setNodes(nodes)
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid API format prompt: %w", err)
+	}
+
+	missing := prompt.CreateNodeProperties(node_objects)
+	if missing != nil && len(*missing) != 0 {
+		err = errors.New("missing node types")
+	}
+	return prompt, missing, err
+}
+
+func NewPromptFromJsonFile(path string, node_objects *NodeObjects) (*Prompt, *[]string, error) {
+	freader, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer freader.Close()
+
+	return NewPromptFromJsonReader(freader, node_objects)
+}
+
+func NewPromptFromJsonString(data string, node_objects *NodeObjects) (*Prompt, *[]string, error) {
+	return NewPromptFromJsonReader(strings.NewReader(data), node_objects)
+}
+
+// CreateNodeProperties binds properties created from the node_objects to the inputs of the prompt's nodes
+//
+// Returns:
+//   - A pointer to an array of strings containing any missing nodes in the node_objects
+func (p *Prompt) CreateNodeProperties(node_objects *NodeObjects) *[]string {
+	var retv *[]string = nil
+	if node_objects == nil {
+		return retv
+	}
+	p.nodeObjects = node_objects
+	missing := func(pn *PromptNode, id string) {
+		logger.Errorf("Could not get node object for node type(%s), id(%s)", pn.ClassType, id)
+		if retv == nil {
+			r := make([]string, 0)
+			retv = &r
+		}
+		if !containsString(retv, pn.ClassType) {
+			r := append(*retv, pn.ClassType)
+			retv = &r
+		}
+	}
+	for id, pn := range p.Nodes {
+		pn.ID = id
+		if !pn.createProperties(node_objects) {
+			missing(pn, strconv.Itoa(id))
+		}
+	}
+	for id, pn := range p.InnerNodes {
+		if !pn.createProperties(node_objects) {
+			missing(pn, id)
+		}
+	}
+	return retv
+}
+
+// createProperties binds the properties of the node's type to its inputs, returns false if the
+// node_objects do not have the node's type
+func (pn *PromptNode) createProperties(node_objects *NodeObjects) bool {
+	if pn.Inputs == nil {
+		pn.Inputs = make(map[string]interface{})
+	}
+	pn.Properties = make(map[string]Property)
+
+	nobject := node_objects.GetNodeObjectByName(pn.ClassType)
+	if nobject == nil {
+		return false
+	}
+	pn.DisplayName = nobject.DisplayName
+	pn.IsOutput = nobject.OutputNode
+
+	// the properties target a node whose widget values are the inputs of the prompt node,
+	// so that setting a property sets the input
+	holder := &GraphNode{
+		ID:           pn.ID,
+		Type:         pn.ClassType,
+		WidgetValues: pn.Inputs,
+		Properties:   pn.Properties,
+	}
+	pindex := 0
+	props := nobject.GetSettableProperties()
+	(&Graph{}).ProcessSettableProperties(holder, &props, &pindex)
+
+	// the frontend does not serialize the seed control widget
+	delete(pn.Properties, "control_after_generate")
+
+	addUploadProperties(pn.Properties, props)
+	return true
+}
+
+// GetNodeById returns the node with the given id, or nil
+func (p *Prompt) GetNodeById(id int) *PromptNode {
+	val, ok := p.Nodes[id]
+	if ok {
+		return val
+	}
+	return nil
+}
+
+// SortedNodeIDs returns the ids of the prompt's nodes in ascending order
+func (p *Prompt) SortedNodeIDs() []int {
+	retv := make([]int, 0, len(p.Nodes))
+	for id := range p.Nodes {
+		retv = append(retv, id)
+	}
+	sort.Ints(retv)
+	return retv
+}
+
+// GetNodesWithTitle returns the nodes with the given title, falling back to the display name
+// for nodes without a title
+func (p *Prompt) GetNodesWithTitle(title string) []*PromptNode {
+	retv := make([]*PromptNode, 0)
+	for _, id := range p.SortedNodeIDs() {
+		if p.Nodes[id].Title() == title {
+			retv = append(retv, p.Nodes[id])
+		}
+	}
+	return retv
+}
+
+// GetFirstNodeWithTitle returns the node with the lowest id with the given title, or nil
+func (p *Prompt) GetFirstNodeWithTitle(title string) *PromptNode {
+	nodes := p.GetNodesWithTitle(title)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// GetNodesWithType returns the nodes with the given class type
+func (p *Prompt) GetNodesWithType(nodeType string) []*PromptNode {
+	retv := make([]*PromptNode, 0)
+	for _, id := range p.SortedNodeIDs() {
+		if p.Nodes[id].ClassType == nodeType {
+			retv = append(retv, p.Nodes[id])
+		}
+	}
+	return retv
+}
+
+// Title returns the title of the node, or its display name when it has none
+func (n *PromptNode) Title() string {
+	if n.Meta != nil && n.Meta.Title != "" {
+		return n.Meta.Title
+	}
+	return n.DisplayName
+}
+
+func (n *PromptNode) GetPropertyWithName(name string) Property {
+	retv, ok := n.Properties[name]
+	if ok {
+		return retv
+	}
+
+	// check n.Properties for an aliased property
+	for _, p := range n.Properties {
+		if p.GetAlias() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetInputLink returns the node id and output slot the named input is linked to
+func (n *PromptNode) GetInputLink(name string) (int, int, bool) {
+	v, ok := n.Inputs[name].([]interface{})
+	if !ok || len(v) != 2 {
+		return 0, 0, false
+	}
+	var id int
+	switch origin := v[0].(type) {
+	case string:
+		i, err := strconv.Atoi(origin)
+		if err != nil {
+			return 0, 0, false
+		}
+		id = i
+	case float64:
+		id = int(origin)
+	case int:
+		id = origin
+	default:
+		return 0, 0, false
+	}
+	switch slot := v[1].(type) {
+	case float64:
+		return id, int(slot), true
+	case int:
+		return id, slot, true
+	}
+	return 0, 0, false
+}
+
+// SetInputLink links the named input to the output slot of the node with the given id
+func (n *PromptNode) SetInputLink(name string, originID int, slot int) {
+	n.Inputs[name] = []interface{}{strconv.Itoa(originID), slot}
+}
+
+// ToGraph converts the prompt to a workflow graph with a generated layout.  Nodes keep their ids,
+// inputs that are linked to other nodes become links and all other inputs become widget values.
+func (p *Prompt) ToGraph() (*Graph, error) {
+	if p.nodeObjects == nil {
+		return nil, ErrNotNodeObjects
+	}
+	if len(p.InnerNodes) != 0 {
+		return nil, errors.New("prompts with the inner nodes of group nodes or subgraphs cannot be converted to a graph")
+	}
+	graph := NewGraph(p.nodeObjects)
+	ids := p.SortedNodeIDs()
+
+	// create the nodes
+	for _, id := range ids {
+		pn := p.Nodes[id]
+		n, err := graph.addNodeWithID(pn.ClassType, id)
+		if err != nil {
+			return nil, err
+		}
+		if pn.Meta != nil && pn.Meta.Title != "" && pn.Meta.Title != n.DisplayName {
+			n.Title = pn.Meta.Title
+		}
+		// keep the seed of the prompt
+		if prop, ok := n.Properties["control_after_generate"]; ok {
+			prop.SetValue("fixed")
+		}
+		for name, v := range pn.Inputs {
+			if _, _, linked := pn.GetInputLink(name); linked {
+				continue
+			}
+			prop, ok := n.Properties[name]
+			if !ok || !prop.Settable() {
+				continue
+			}
+			if err := prop.SetValue(v); err != nil {
+				return nil, fmt.Errorf("node %d (%s) input %s: %w", id, pn.ClassType, name, err)
+			}
+		}
+	}
+
+	// link the nodes
+	for _, id := range ids {
+		pn := p.Nodes[id]
+		n := graph.GetNodeById(id)
+		names := make([]string, 0, len(pn.Inputs))
+		for name := range pn.Inputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			originID, slot, linked := pn.GetInputLink(name)
+			if !linked {
+				continue
+			}
+			origin := graph.GetNodeById(originID)
+			if origin == nil {
+				return nil, fmt.Errorf("node %d (%s) input %s is linked to missing node %d", id, pn.ClassType, name, originID)
+			}
+			index := n.GetInputIndex(name)
+			if index < 0 {
+				index = n.convertWidgetToInput(name)
+			}
+			if index < 0 {
+				return nil, fmt.Errorf("node %d (%s) has no input %s", id, pn.ClassType, name)
+			}
+			if _, err := graph.ConnectSlots(origin, slot, n, index); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := graph.UpdateExecutionOrder(); err != nil {
+		return nil, err
+	}
+	graph.layoutNodes()
+	return graph, nil
+}
+
+// layoutNodes places the nodes in columns by their distance from the nodes without inputs
+func (t *Graph) layoutNodes() {
+	depth := make(map[int]int)
+	var depthOf func(n *GraphNode, visiting map[int]bool) int
+	depthOf = func(n *GraphNode, visiting map[int]bool) int {
+		if d, ok := depth[n.ID]; ok {
+			return d
+		}
+		if visiting[n.ID] {
+			return 0
+		}
+		visiting[n.ID] = true
+		d := 0
+		for i := range n.Inputs {
+			if parent := n.GetNodeForInput(i); parent != nil {
+				if pd := depthOf(parent, visiting) + 1; pd > d {
+					d = pd
+				}
+			}
+		}
+		delete(visiting, n.ID)
+		depth[n.ID] = d
+		return d
+	}
+	for _, n := range t.Nodes {
+		depthOf(n, make(map[int]bool))
+	}
+
+	nodes := make([]*GraphNode, len(t.Nodes))
+	copy(nodes, t.Nodes)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if depth[nodes[i].ID] != depth[nodes[j].ID] {
+			return depth[nodes[i].ID] < depth[nodes[j].ID]
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	y := make(map[int]float64)
+	for _, n := range nodes {
+		d := depth[n.ID]
+		if _, ok := y[d]; !ok {
+			y[d] = 100
+		}
+		n.Position = []interface{}{float64(100 + d*400), y[d]}
+		y[d] += n.Size.Height + 60
+	}
+}
+
+// parseNodeID returns the id of the workflow node for a node id sent by ComfyUI.  The inner nodes of
+// group nodes and subgraphs, such as "12:3", are reported as the node containing them.
+func parseNodeID(s string) (int, error) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.Atoi(s)
 }
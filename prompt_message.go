@@ -8,6 +8,7 @@ type PromptMessage struct {
 // our cast of characters:
 // queued
 // started
+// cached
 // executing
 // progress
 // data
@@ -28,9 +29,19 @@ func (p *PromptMessage) ToPromptMessageStarted() *PromptMessageStarted {
 	return p.Message.(*PromptMessageStarted)
 }
 
+type PromptMessageCached struct {
+	PromptID string `json:"prompt_id"`
+	Nodes    []int
+}
+
+func (p *PromptMessage) ToPromptMessageCached() *PromptMessageCached {
+	return p.Message.(*PromptMessageCached)
+}
+
 type PromptMessageExecuting struct {
-	NodeID int
-	Title  string
+	PromptID string `json:"prompt_id"`
+	NodeID   int
+	Title    string
 }
 
 func (p *PromptMessage) ToPromptMessageExecuting() *PromptMessageExecuting {
@@ -38,8 +49,10 @@ func (p *PromptMessage) ToPromptMessageExecuting() *PromptMessageExecuting {
 }
 
 type PromptMessageProgress struct {
-	Max   int
-	Value int
+	PromptID string `json:"prompt_id"`
+	NodeID   int
+	Max      int
+	Value    int
 }
 
 func (p *PromptMessage) ToPromptMessageProgress() *PromptMessageProgress {
@@ -47,8 +60,9 @@ func (p *PromptMessage) ToPromptMessageProgress() *PromptMessageProgress {
 }
 
 type PromptMessageData struct {
-	NodeID int
-	Data   map[string][]DataOutput
+	PromptID string `json:"prompt_id"`
+	NodeID   int
+	Data     map[string][]DataOutput
 }
 
 func (p *PromptMessage) ToPromptMessageData() *PromptMessageData {
@@ -56,9 +70,10 @@ func (p *PromptMessage) ToPromptMessageData() *PromptMessageData {
 }
 
 type PromptMessageStopped struct {
-	PromptID  string `json:"prompt_id"`
-	Exception *PromptMessageStoppedException
-	Stop      bool
+	PromptID    string `json:"prompt_id"`
+	Exception   *PromptMessageStoppedException
+	Interrupted bool
+	Stop        bool
 }
 
 type PromptMessageStoppedException struct {
@@ -68,6 +83,9 @@ type PromptMessageStoppedException struct {
 	ExceptionMessage string
 	ExceptionType    string
 	Traceback        []string
+	Executed         []int                  // nodes that finished executing before the exception
+	CurrentInputs    map[string]interface{} // formatted input values of the failing node
+	CurrentOutputs   interface{}
 }
 
 func (p *PromptMessage) ToPromptMessageStopped() *PromptMessageStopped {
@@ -1,8 +1,7 @@
 package comfy
 
 import (
-	"errors"
-	"fmt"
+	"encoding/json"
 	"math"
 	"reflect"
 	"strconv"
@@ -39,6 +38,10 @@ type Property interface {
 	TargetIndex() int
 	SetAlias(string)
 	GetAlias() string
+	Tooltip() string
+	Advanced() bool
+	Lazy() bool
+	ForceInput() bool
 
 	UpdateParent(parent Property)
 	ToIntProperty() (*IntProperty, bool)
@@ -49,7 +52,8 @@ type Property interface {
 	ToCascadeProperty() (*CascadingProperty, bool)
 	ToImageUploadProperty() (*ImageUploadProperty, bool)
 	ToUnknownProperty() (*UnknownProperty, bool)
-	valueFromString(value string) interface{}
+	convertValue(v interface{}) (interface{}, error)
+	setInputOptions(options map[string]interface{})
 
 	SetDirectValue(v *interface{})
 }
@@ -66,6 +70,10 @@ type BaseProperty struct {
 	index              int
 	direct_value       *interface{}
 	alias              string
+	tooltip            string
+	advanced           bool
+	lazy               bool
+	force_input        bool
 }
 
 func (b *BaseProperty) SetDirectValue(v *interface{}) {
@@ -124,29 +132,13 @@ func (b *BaseProperty) GetValue() interface{} {
 	return nil
 }
 
-// SetValue calls the protocol implementation for valueFromString to get
-// the actual value that will be set.  valueFromString should perform
-// conversion to its native type and constrain it when needed
+// SetValue calls the protocol implementation for convertValue to get the actual value that
+// will be set.  convertValue checks the Go type of the value and constrains it when needed,
+// errors are returned as a *PropertyValueError naming the node and the property
 func (b *BaseProperty) SetValue(v interface{}) error {
-	// convert the value to a string first
-	var vs string
-
-	// if v is of type float64 or float32 and the property is an int, convert it to an int
-	if b.parent.TypeString() == "INT" {
-		if f, ok := v.(float64); ok {
-			vs = fmt.Sprintf("%d", int64(f))
-		} else if f, ok := v.(float32); ok {
-			vs = fmt.Sprintf("%d", int64(f))
-		} else {
-			vs = fmt.Sprintf("%v", v)
-		}
-	} else {
-		vs = fmt.Sprintf("%v", v)
-	}
-
-	val := b.parent.valueFromString(vs)
-	if val == nil {
-		return errors.New("could not get converted type")
+	val, err := b.parent.convertValue(v)
+	if err != nil {
+		return b.valueError(v, err)
 	}
 
 	if b.direct_value != nil {
@@ -161,7 +153,7 @@ func (b *BaseProperty) SetValue(v interface{}) error {
 			b.target_node.WidgetValuesMap()[b.name] = val
 		}
 	} else {
-		return errors.New("property has no target node")
+		return b.valueError(v, ErrPropertyNotBound)
 	}
 
 	// if there are secondaries, set those too
@@ -176,6 +168,21 @@ func (b *BaseProperty) SetValue(v interface{}) error {
 	return nil
 }
 
+// valueError completes the error returned by convertValue with the node and the property
+func (b *BaseProperty) valueError(v interface{}, err error) error {
+	pe, ok := err.(*PropertyValueError)
+	if !ok {
+		pe = &PropertyValueError{Err: err}
+	}
+	pe.Property = b.name
+	pe.Value = v
+	if b.target_node != nil {
+		pe.NodeID = b.target_node.ID
+		pe.NodeType = b.target_node.Type
+	}
+	return pe
+}
+
 func (b *BaseProperty) Index() int {
 	return b.index
 }
@@ -245,6 +252,34 @@ func (b *BaseProperty) GetAlias() string {
 	return b.alias
 }
 
+// Tooltip returns the description of the input given by the node
+func (b *BaseProperty) Tooltip() string {
+	return b.tooltip
+}
+
+// Advanced returns true if the frontend hides the input with the advanced inputs
+func (b *BaseProperty) Advanced() bool {
+	return b.advanced
+}
+
+// Lazy returns true if the node evaluates the input only when it needs it
+func (b *BaseProperty) Lazy() bool {
+	return b.lazy
+}
+
+// ForceInput returns true if the input has no widget and can only be linked, such properties are not settable
+func (b *BaseProperty) ForceInput() bool {
+	return b.force_input
+}
+
+// setInputOptions sets the options of the options dict of the input that apply to every type
+func (b *BaseProperty) setInputOptions(options map[string]interface{}) {
+	b.tooltip, _ = options["tooltip"].(string)
+	b.advanced, _ = options["advanced"].(bool)
+	b.lazy, _ = options["lazy"].(bool)
+	b.force_input, _ = options["forceInput"].(bool)
+}
+
 type BoolProperty struct {
 	BaseProperty
 	Default  bool
@@ -260,16 +295,16 @@ func newBoolProperty(input_name string, optional bool, data interface{}, index i
 	c.parent = c
 
 	if d, ok := data.(map[string]interface{}); ok {
-		if val, ok := d["label_off"]; ok {
-			c.LabelOn = val.(string)
+		if val, ok := d["label_on"].(string); ok {
+			c.LabelOn = val
 		}
 
-		if val, ok := d["label_off"]; ok {
-			c.LabelOn = val.(string)
+		if val, ok := d["label_off"].(string); ok {
+			c.LabelOff = val
 		}
 
-		if val, ok := d["label_off"]; ok {
-			c.Default = val.(bool)
+		if val, ok := d["default"].(bool); ok {
+			c.Default = val
 		}
 	}
 
@@ -283,17 +318,129 @@ func (p *BoolProperty) Optional() bool {
 	return p.optional
 }
 func (p *BoolProperty) Settable() bool {
-	return true
+	return !p.force_input
 }
 func (p *BoolProperty) Name() string {
 	return p.name
 }
-func (p *BoolProperty) valueFromString(value string) interface{} {
-	v, err := strconv.ParseBool(value)
-	if err != nil {
-		return nil
+func (p *BoolProperty) convertValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case string:
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b, nil
+		}
+		return nil, newValueError(ErrInvalidValueType, "%q is not a bool", val)
+	}
+	return nil, newValueError(ErrInvalidValueType, "%T is not a bool", v)
+}
+
+// SetBool sets the value of the property
+func (p *BoolProperty) SetBool(v bool) error {
+	return p.SetValue(v)
+}
+
+// float64ToInt64 converts JSON numbers to int64, saturating values that are out of range,
+// such as the 0xffffffffffffffff max of seeds
+func float64ToInt64(v float64) int64 {
+	if v >= math.MaxInt64 {
+		return math.MaxInt64
 	}
-	return v
+	if v <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(v)
+}
+
+// RangePolicy decides what setting an INT or FLOAT property to a value outside of its range, or that
+// is not a multiple of its step, does.  Values are rejected unless the policy of the property, or of
+// its graph, see Graph.SetRangePolicy, is RangePolicyClamp.
+type RangePolicy int
+
+const (
+	RangePolicyReject RangePolicy = iota // an error is returned
+	RangePolicyClamp                     // the value is clamped to the range and rounded to the step, with a warning
+)
+
+// intValue converts the Go number types, and numbers written as strings, to int64.  Floats must be whole
+// numbers, JSON numbers are floats.
+func intValue(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), nil
+	case int8:
+		return int64(val), nil
+	case int16:
+		return int64(val), nil
+	case int32:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case uint:
+		return float64ToInt64(float64(val)), nil
+	case uint8:
+		return int64(val), nil
+	case uint16:
+		return int64(val), nil
+	case uint32:
+		return int64(val), nil
+	case uint64:
+		if val > math.MaxInt64 {
+			return math.MaxInt64, nil
+		}
+		return int64(val), nil
+	case float32:
+		return intValue(float64(val))
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) || val != math.Trunc(val) {
+			return 0, newValueError(ErrInvalidValueType, "%v is not a whole number", val)
+		}
+		return float64ToInt64(val), nil
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return 0, newValueError(ErrInvalidValueType, "%v is not a number", val)
+		}
+		return intValue(f)
+	case string:
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return i, nil
+		}
+		return 0, newValueError(ErrInvalidValueType, "%q is not an integer", val)
+	}
+	return 0, newValueError(ErrInvalidValueType, "%T is not an integer", v)
+}
+
+// floatValue converts the Go number types, and numbers written as strings, to float64
+func floatValue(v interface{}) (float64, error) {
+	f, ok := numberValue(v)
+	if !ok {
+		if s, isString := v.(string); isString {
+			parsed, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return 0, newValueError(ErrInvalidValueType, "%q is not a number", s)
+			}
+			f = parsed
+		} else if n, isNumber := v.(json.Number); isNumber {
+			parsed, err := n.Float64()
+			if err != nil {
+				return 0, newValueError(ErrInvalidValueType, "%v is not a number", n)
+			}
+			f = parsed
+		} else if i, err := intValue(v); err == nil {
+			f = float64(i)
+		} else {
+			return 0, newValueError(ErrInvalidValueType, "%T is not a number", v)
+		}
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, newValueError(ErrInvalidValueType, "%v is not a finite number", f)
+	}
+	return f, nil
 }
 
 type IntProperty struct {
@@ -304,6 +451,8 @@ type IntProperty struct {
 	Step     int64 // optional
 	hasStep  bool
 	hasRange bool
+	// RangePolicy decides what SetValue does with values outside of the range or not on the step
+	RangePolicy RangePolicy
 }
 
 func newIntProperty(input_name string, optional bool, data interface{}, index int) *Property {
@@ -319,21 +468,26 @@ func newIntProperty(input_name string, optional bool, data interface{}, index in
 	c.parent = Property(c)
 
 	if d, ok := data.(map[string]interface{}); ok {
+		// default?
+		if val, ok := d["default"].(float64); ok {
+			c.Default = float64ToInt64(val)
+		}
+
 		// min?
 		if val, ok := d["min"]; ok {
-			c.Min = int64(val.(float64))
+			c.Min = float64ToInt64(val.(float64))
 			c.hasRange = true
 		}
 
 		// max?
 		if val, ok := d["max"]; ok {
-			c.Max = int64(val.(float64))
+			c.Max = float64ToInt64(val.(float64))
 			c.hasRange = true
 		}
 
 		// step?
 		if val, ok := d["step"]; ok {
-			c.Step = int64(val.(float64))
+			c.Step = float64ToInt64(val.(float64))
 			c.hasStep = true
 		}
 	}
@@ -354,21 +508,52 @@ func (p *IntProperty) HasRange() bool {
 	return p.hasRange
 }
 func (p *IntProperty) Settable() bool {
-	return true
+	return !p.force_input
 }
 func (p *IntProperty) Name() string {
 	return p.name
 }
-func (p *IntProperty) valueFromString(value string) interface{} {
-	v, err := strconv.ParseInt(value, 10, 64)
+func (p *IntProperty) convertValue(v interface{}) (interface{}, error) {
+	i, err := intValue(v)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	if p.hasRange {
-		v = int64(math.Min(float64(p.Max), float64(v)))
-		v = int64(math.Max(float64(p.Min), float64(v)))
+	clamp := p.RangePolicy == RangePolicyClamp
+	requested := i
+	if p.hasRange && (i < p.Min || i > p.Max) {
+		if !clamp {
+			return nil, newValueError(ErrValueOutOfRange, "%d is not between %d and %d", i, p.Min, p.Max)
+		}
+		i = max(p.Min, min(p.Max, i))
 	}
-	return v
+	if p.hasStep && p.Step > 1 {
+		// the steps start at the minimum
+		base := int64(0)
+		if p.hasRange {
+			base = p.Min
+		}
+		if off := (i - base) % p.Step; off != 0 {
+			if !clamp {
+				return nil, newValueError(ErrValueNotOnStep, "%d is not %d plus a multiple of %d", i, base, p.Step)
+			}
+			if off < 0 {
+				off += p.Step
+			}
+			i -= off
+			if off*2 >= p.Step && (!p.hasRange || i <= p.Max-p.Step) {
+				i += p.Step
+			}
+		}
+	}
+	if i != requested {
+		logger.Warnf("value %d of %s is clamped to %d", requested, p.name, i)
+	}
+	return i, nil
+}
+
+// SetInt sets the value of the property
+func (p *IntProperty) SetInt(v int64) error {
+	return p.SetValue(v)
 }
 
 type FloatProperty struct {
@@ -377,8 +562,12 @@ type FloatProperty struct {
 	Min      float64
 	Max      float64
 	Step     float64
+	Round    float64 // the precision of the values, 0 when the values are not rounded
 	hasStep  bool
 	hasRange bool
+	hasRound bool
+	// RangePolicy decides what SetValue does with values outside of the range or not on the precision
+	RangePolicy RangePolicy
 }
 
 func newFloatProperty(input_name string, optional bool, data interface{}, index int) *Property {
@@ -394,6 +583,11 @@ func newFloatProperty(input_name string, optional bool, data interface{}, index
 	c.parent = c
 
 	if d, ok := data.(map[string]interface{}); ok {
+		// default?
+		if val, ok := d["default"].(float64); ok {
+			c.Default = val
+		}
+
 		// min?
 		if val, ok := d["min"]; ok {
 			c.Min = val.(float64)
@@ -411,6 +605,12 @@ func newFloatProperty(input_name string, optional bool, data interface{}, index
 			c.Step = val.(float64)
 			c.hasStep = true
 		}
+
+		// round? false disables rounding
+		if val, ok := d["round"]; ok {
+			c.Round, _ = val.(float64)
+			c.hasRound = true
+		}
 	}
 
 	var retv Property = c
@@ -429,27 +629,80 @@ func (p *FloatProperty) HasRange() bool {
 	return p.hasRange
 }
 func (p *FloatProperty) Settable() bool {
-	return true
+	return !p.force_input
 }
 func (p *FloatProperty) Name() string {
 	return p.name
 }
-func (p *FloatProperty) valueFromString(value string) interface{} {
-	v, err := strconv.ParseFloat(value, 64)
+
+// precision returns the granularity of the values, which is the round option when it is given, as
+// the frontend rounds values to it, or the step.  0 when values have any precision.
+func (p *FloatProperty) precision() float64 {
+	if p.hasRound {
+		return p.Round
+	}
+	if p.hasStep {
+		return p.Step
+	}
+	return 0
+}
+
+func (p *FloatProperty) convertValue(v interface{}) (interface{}, error) {
+	f, err := floatValue(v)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	clamp := p.RangePolicy == RangePolicyClamp
+	requested := f
+	if p.hasRange && (f < p.Min || f > p.Max) {
+		if !clamp {
+			return nil, newValueError(ErrValueOutOfRange, "%v is not between %v and %v", f, p.Min, p.Max)
+		}
+		f = math.Max(p.Min, math.Min(p.Max, f))
 	}
-	if p.hasRange {
-		v = math.Min(v, p.Max)
-		v = math.Max(v, p.Min)
+	if precision := p.precision(); precision > 0 {
+		base := 0.0
+		if p.hasRange {
+			base = p.Min
+		}
+		steps := (f - base) / precision
+		if math.Abs(steps-math.Round(steps)) > 1e-6 {
+			if !clamp {
+				return nil, newValueError(ErrValueNotOnStep, "%v is not %v plus a multiple of %v", f, base, precision)
+			}
+			f = roundToPrecision(base+math.Round(steps)*precision, precision)
+			if p.hasRange && f > p.Max {
+				f = roundToPrecision(f-precision, precision)
+			}
+		}
+	}
+	if f != requested {
+		logger.Warnf("value %v of %s is clamped to %v", requested, p.name, f)
+	}
+	return f, nil
+}
+
+// roundToPrecision removes the floating point noise of a multiple of the precision, e.g. 7.300000000000001
+func roundToPrecision(f float64, precision float64) float64 {
+	decimals := 0
+	if s := strconv.FormatFloat(precision, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
 	}
-	return v
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(f*scale) / scale
+}
+
+// SetFloat sets the value of the property
+func (p *FloatProperty) SetFloat(v float64) error {
+	return p.SetValue(v)
 }
 
 type StringProperty struct {
 	BaseProperty
 	Default   string
 	Multiline bool
+	// DynamicPrompts is true when GraphToPrompt expands the value, see ExpandDynamicPrompt
+	DynamicPrompts bool
 }
 
 func newStringProperty(input_name string, optional bool, data interface{}, index int) *Property {
@@ -470,6 +723,11 @@ func newStringProperty(input_name string, optional bool, data interface{}, index
 		if val, ok := d["multiline"]; ok {
 			c.Multiline = val.(bool)
 		}
+
+		// dynamic prompts?
+		if val, ok := d["dynamicPrompts"].(bool); ok {
+			c.DynamicPrompts = val
+		}
 	}
 
 	var retv Property = c
@@ -482,13 +740,21 @@ func (p *StringProperty) Optional() bool {
 	return p.optional
 }
 func (p *StringProperty) Settable() bool {
-	return true
+	return !p.force_input
 }
 func (p *StringProperty) Name() string {
 	return p.name
 }
-func (p *StringProperty) valueFromString(value string) interface{} {
-	return value
+func (p *StringProperty) convertValue(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return nil, newValueError(ErrInvalidValueType, "%T is not a string", v)
+}
+
+// SetString sets the value of the property
+func (p *StringProperty) SetString(v string) error {
+	return p.SetValue(v)
 }
 
 func isCascadingProperty(input []interface{}) bool {
@@ -600,9 +866,9 @@ func (p *CascadingProperty) Name() string {
 	return p.name
 }
 
-func (p *CascadingProperty) valueFromString(value string) interface{} {
+func (p *CascadingProperty) convertValue(v interface{}) (interface{}, error) {
 	// we can't set a cascading property directly
-	return nil
+	return nil, newValueError(ErrPropertyNotSettable, "cascading properties cannot be set directly")
 }
 
 func (p *CascadingProperty) GroupNames() []string {
@@ -626,14 +892,42 @@ type ComboProperty struct {
 	BaseProperty
 	Values []string
 	IsBool bool
+	// MultiSelect is true when the value is a list of the values
+	MultiSelect bool
+	// Remote is the route the frontend loads the values from, any string can be set when it is not empty
+	Remote string
+	// Upload is "image", "video" or "audio" when the frontend adds a button that uploads a file to the combo
+	Upload string
+	// Default is the default value when the node gives one
+	Default string
 }
 
-func newComboProperty(input_name string, optional bool, input []interface{}, index int) *Property {
+func newComboProperty(input_name string, optional bool, input []interface{}, data interface{}, index int) *Property {
 	c := &ComboProperty{
 		BaseProperty: BaseProperty{name: input_name, optional: optional, serializable: true, index: index, target_value_index: -1},
 	}
 	c.parent = c
 
+	if d, ok := data.(map[string]interface{}); ok {
+		if val, ok := d["default"].(string); ok {
+			c.Default = val
+		}
+		// multi_select is a bool, or a dict of options
+		if val, ok := d["multi_select"]; ok {
+			if b, ok := val.(bool); !ok || b {
+				c.MultiSelect = true
+			}
+		}
+		if val, ok := d["remote"].(map[string]interface{}); ok {
+			c.Remote, _ = val["route"].(string)
+		}
+		for _, kind := range []string{"image", "video", "audio"} {
+			if val, ok := d[kind+"_upload"].(bool); ok && val {
+				c.Upload = kind
+			}
+		}
+	}
+
 	c.Values = make([]string, 0)
 	for _, v := range input {
 		if s, ok := v.(string); ok {
@@ -664,30 +958,78 @@ func (p *ComboProperty) Optional() bool {
 }
 
 func (p *ComboProperty) Settable() bool {
-	return true
+	return !p.force_input
 }
 
 func (p *ComboProperty) Name() string {
 	return p.name
 }
 
-func (p *ComboProperty) valueFromString(value string) interface{} {
+func (p *ComboProperty) convertValue(v interface{}) (interface{}, error) {
 	if p.IsBool {
-		tl := strings.ToLower(value)
-		if tl == "true" {
-			return true
-		} else {
-			return false
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if s, ok := v.(string); ok {
+			switch strings.ToLower(s) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+		}
+		return nil, newValueError(ErrInvalidValueType, "%v (%T) is not a bool", v, v)
+	}
+
+	if p.MultiSelect {
+		var values []interface{}
+		switch val := v.(type) {
+		case []string:
+			for _, s := range val {
+				values = append(values, s)
+			}
+		case []interface{}:
+			values = val
+		case string:
+			values = []interface{}{val}
+		default:
+			return nil, newValueError(ErrInvalidValueType, "%T is not a list of strings", v)
+		}
+		retv := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			s, err := p.convertString(value)
+			if err != nil {
+				return nil, err
+			}
+			retv = append(retv, s)
 		}
+		return retv, nil
 	}
+	return p.convertString(v)
+}
 
+// convertString checks that v is one of the values of the combo
+func (p *ComboProperty) convertString(v interface{}) (interface{}, error) {
+	value, ok := v.(string)
+	if !ok {
+		return nil, newValueError(ErrInvalidValueType, "%T is not a string", v)
+	}
+	// the values of a remote combo are not known
+	if p.Remote != "" {
+		return value, nil
+	}
 	// ensure we have this string in our values
 	for _, v := range p.Values {
 		if value == v {
-			return value
+			return value, nil
 		}
 	}
-	return nil
+	return nil, newValueError(ErrValueNotInList, "%q is not one of the %d values of the list", value, len(p.Values))
+}
+
+// SetString sets the value of the property to one of its values
+func (p *ComboProperty) SetString(v string) error {
+	return p.SetValue(v)
 }
 
 // Append will add the new value to the combo if it's not already available, and then sets
@@ -712,6 +1054,8 @@ func (p *ComboProperty) Append(newValue string) {
 	p.SetValue(newValue)
 }
 
+// ImageUploadProperty is the upload button of a combo, whose Upload tells if it uploads an image, a video
+// or an audio file
 type ImageUploadProperty struct {
 	BaseProperty
 	TargetProperty *ComboProperty
@@ -727,6 +1071,34 @@ func newImageUploadProperty(input_name string, target *ComboProperty, index int)
 	var retv Property = c
 	return &retv
 }
+
+// addUploadProperties adds an ImageUploadProperty to the properties of a node for each combo of props
+// with an upload flag, and returns true if one was added
+func addUploadProperties(properties map[string]Property, props []Property) bool {
+	retv := false
+	for _, p := range props {
+		cp, ok := p.(*ComboProperty)
+		if !ok || cp.Upload == "" {
+			continue
+		}
+		target, ok := properties[cp.Name()].(*ComboProperty)
+		if !ok {
+			logger.Errorf("Cannot find %q property", cp.Name())
+			continue
+		}
+		name := "choose file to upload"
+		if cp.Upload != "image" {
+			name = "choose " + cp.Upload + " to upload"
+		}
+		np := newImageUploadProperty(name, target, len(properties))
+		// set the alias to "file"
+		(*np).SetAlias("file")
+		properties[name] = *np
+		retv = true
+	}
+	return retv
+}
+
 func (p *ImageUploadProperty) TypeString() string {
 	return "IMAGEUPLOAD"
 }
@@ -744,8 +1116,8 @@ func (p *ImageUploadProperty) SetFilename(filename string) {
 		p.TargetProperty.Append(filename)
 	}
 }
-func (p *ImageUploadProperty) valueFromString(value string) interface{} {
-	return nil
+func (p *ImageUploadProperty) convertValue(v interface{}) (interface{}, error) {
+	return nil, newValueError(ErrPropertyNotSettable, "use SetFilename to set the image")
 }
 
 type UnknownProperty struct {
@@ -775,8 +1147,8 @@ func (p *UnknownProperty) Settable() bool {
 func (p *UnknownProperty) Name() string {
 	return p.name
 }
-func (p *UnknownProperty) valueFromString(value string) interface{} {
-	return nil
+func (p *UnknownProperty) convertValue(v interface{}) (interface{}, error) {
+	return nil, newValueError(ErrPropertyNotSettable, "inputs of type %s cannot be set", p.TypeName)
 }
 
 func NewPropertyFromInput(input_name string, optional bool, input *interface{}, index int) *Property {
@@ -790,33 +1162,47 @@ func NewPropertyFromInput(input_name string, optional bool, input *interface{},
 			return nil
 		}
 
+		// the options dict is optional, e.g. ["INT"]
+		var options interface{}
+		if len(slice) > 1 {
+			options = slice[1]
+		}
+
+		var retv *Property
 		// the first item is either an array of strings (a combo), or the property type
 		if ptype, ok := slice[0].([]interface{}); ok {
 			if !isCascadingProperty(ptype) {
-				return newComboProperty(input_name, optional, ptype, index)
+				retv = newComboProperty(input_name, optional, ptype, options, index)
 			} else {
-				return newCascadeProperty(input_name, optional, ptype, index)
+				retv = newCascadeProperty(input_name, optional, ptype, index)
 			}
-		} else {
-			if stype, ok := slice[0].(string); ok {
-				switch stype {
-				case "STRING":
-					return newStringProperty(input_name, optional, slice[1], index)
-				case "INT":
-					return newIntProperty(input_name, optional, slice[1], index)
-				case "FLOAT":
-					return newFloatProperty(input_name, optional, slice[1], index)
-				case "BOOLEAN":
-					return newBoolProperty(input_name, optional, stype, index)
-				case "IMAGE":
-					return newUnknownProperty(input_name, optional, stype, index)
-				case "MASK:":
-					return newUnknownProperty(input_name, optional, stype, index)
-				default:
-					return newUnknownProperty(input_name, optional, stype, index)
+		} else if stype, ok := slice[0].(string); ok {
+			switch stype {
+			case "STRING":
+				retv = newStringProperty(input_name, optional, options, index)
+			case "INT":
+				retv = newIntProperty(input_name, optional, options, index)
+			case "FLOAT":
+				retv = newFloatProperty(input_name, optional, options, index)
+			case "BOOLEAN":
+				retv = newBoolProperty(input_name, optional, options, index)
+			case "COMBO":
+				// the newer form of combos, ["COMBO", {"options": [...]}]
+				values := make([]interface{}, 0)
+				if d, ok := options.(map[string]interface{}); ok {
+					if val, ok := d["options"].([]interface{}); ok {
+						values = val
+					}
 				}
+				retv = newComboProperty(input_name, optional, values, options, index)
+			default:
+				retv = newUnknownProperty(input_name, optional, stype, index)
 			}
 		}
+		if d, ok := options.(map[string]interface{}); ok && retv != nil {
+			(*retv).setInputOptions(d)
+		}
+		return retv
 	} else if s, ok := dereferenced.(string); ok {
 		// Edge case for an "Any" input property
 		if s == "*" {
@@ -0,0 +1,205 @@
+package comfy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/er1cw00/comfy.go/comfytest"
+)
+
+func TestSetValue(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	ks, latent := graph.GetNodeById(3), graph.GetNodeById(5)
+
+	for _, tc := range []struct {
+		node  *GraphNode
+		name  string
+		value interface{}
+		err   error
+	}{
+		{ks, "steps", 30, nil},
+		{ks, "steps", 30.0, nil},
+		{ks, "steps", 2.5, ErrInvalidValueType},
+		{ks, "steps", "30", nil},
+		{ks, "steps", " 30", ErrInvalidValueType},
+		{ks, "steps", "2.5", ErrInvalidValueType},
+		{ks, "steps", "thirty", ErrInvalidValueType},
+		{ks, "cfg", "7.5", nil},
+		{ks, "cfg", "7.5x", ErrInvalidValueType},
+		{ks, "steps", 0, ErrValueOutOfRange},
+		{ks, "steps", 10001, ErrValueOutOfRange},
+		{ks, "cfg", 101.0, ErrValueOutOfRange},
+		{ks, "sampler_name", "dpmpp_2m", nil},
+		{ks, "sampler_name", "not_a_sampler", ErrValueNotInList},
+		{latent, "width", 768, nil},
+		{latent, "width", 770, ErrValueNotOnStep},
+	} {
+		err := tc.node.GetPropertyWithName(tc.name).SetValue(tc.value)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("setting %s to %v (%T) = %v, want %v", tc.name, tc.value, tc.value, err, tc.err)
+			continue
+		}
+		var pe *PropertyValueError
+		if err != nil && (!errors.As(err, &pe) || pe.NodeID != tc.node.ID || pe.Property != tc.name) {
+			t.Errorf("error %v does not name node %d and property %s", err, tc.node.ID, tc.name)
+		}
+	}
+	// rejected values leave the widget as it was
+	if v := ks.GetPropertyWithName("steps").GetValue(); v != int64(30) {
+		t.Errorf("steps = %v, want 30", v)
+	}
+	if v := latent.GetPropertyWithName("width").GetValue(); v != int64(768) {
+		t.Errorf("width = %v, want 768", v)
+	}
+}
+
+func TestSetValueClamp(t *testing.T) {
+	graph := loadTestGraph(t, testNodeObjects(t, comfytest.DefaultObjectInfo), "txt2img.json")
+	graph.SetRangePolicy(RangePolicyClamp)
+	ks, latent := graph.GetNodeById(3), graph.GetNodeById(5)
+
+	for _, tc := range []struct {
+		prop  Property
+		value interface{}
+		want  interface{}
+	}{
+		{ks.GetPropertyWithName("steps"), 0, int64(1)},
+		{ks.GetPropertyWithName("steps"), 20000, int64(10000)},
+		{ks.GetPropertyWithName("cfg"), -1.0, 0.0},
+		{latent.GetPropertyWithName("width"), 770, int64(768)},
+		{latent.GetPropertyWithName("width"), 773, int64(776)},
+		{latent.GetPropertyWithName("width"), 16385, int64(16384)},
+	} {
+		if err := tc.prop.SetValue(tc.value); err != nil {
+			t.Errorf("setting %s to %v: %v", tc.prop.Name(), tc.value, err)
+			continue
+		}
+		if v := tc.prop.GetValue(); v != tc.want {
+			t.Errorf("%s set to %v is %v, want %v", tc.prop.Name(), tc.value, v, tc.want)
+		}
+	}
+
+	// the policy is kept by clones and applies to the nodes added later on
+	clone := graph.Clone()
+	if err := clone.GetNodeById(3).GetPropertyWithName("steps").SetValue(0); err != nil {
+		t.Errorf("the clone rejects a value out of range: %v", err)
+	}
+	added, err := graph.AddNode("KSampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := added.GetPropertyWithName("steps").SetValue(0); err != nil {
+		t.Errorf("an added node rejects a value out of range: %v", err)
+	}
+
+	graph.SetRangePolicy(RangePolicyReject)
+	if err := ks.GetPropertyWithName("steps").SetValue(0); !errors.Is(err, ErrValueOutOfRange) {
+		t.Errorf("err = %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestSetValueNotBound(t *testing.T) {
+	// the input as decoded from the object info, with JSON numbers
+	var input interface{} = []interface{}{"INT", map[string]interface{}{"default": 1.0, "min": 0.0, "max": 10.0}}
+	prop := NewPropertyFromInput("steps", false, &input, 0)
+	if prop == nil {
+		t.Fatal("no property for an INT input")
+	}
+	if err := (*prop).SetValue(5); !errors.Is(err, ErrPropertyNotBound) {
+		t.Errorf("err = %v, want %v", err, ErrPropertyNotBound)
+	}
+	if err := (*prop).SetValue(50); !errors.Is(err, ErrValueOutOfRange) {
+		t.Errorf("err = %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestSetValueBoolString(t *testing.T) {
+	var input interface{} = []interface{}{"BOOLEAN", map[string]interface{}{"default": true}}
+	prop := *NewPropertyFromInput("enabled", false, &input, 0)
+	// values are converted before they are set, so converted values fail to be set on no node
+	for _, v := range []interface{}{false, "true", "false"} {
+		if err := prop.SetValue(v); !errors.Is(err, ErrPropertyNotBound) {
+			t.Errorf("setting %v (%T) = %v, want %v", v, v, err, ErrPropertyNotBound)
+		}
+	}
+	for _, v := range []interface{}{"yes", "", 1} {
+		if err := prop.SetValue(v); !errors.Is(err, ErrInvalidValueType) {
+			t.Errorf("setting %v (%T) = %v, want %v", v, v, err, ErrInvalidValueType)
+		}
+	}
+}
+
+// testV3ObjectInfo describes a node with the input specs of V3 nodes
+const testV3ObjectInfo = `{
+	"LoadMedia": {
+		"input": {
+			"required": {
+				"image": ["COMBO", {"options": ["a.png", "b.png"], "image_upload": true, "tooltip": "the image"}],
+				"video": ["COMBO", {"options": ["a.mp4"], "video_upload": true}],
+				"audio": ["COMBO", {"options": ["a.wav"], "audio_upload": true}],
+				"tags": ["COMBO", {"options": ["x", "y"], "multi_select": {"placeholder": "tags"}}],
+				"model": ["COMBO", {"options": [], "remote": {"route": "/internal/models"}}],
+				"count": ["INT", {"default": 1, "forceInput": true}]
+			}
+		},
+		"output": ["IMAGE"],
+		"output_is_list": [false],
+		"output_name": ["IMAGE"],
+		"name": "LoadMedia",
+		"display_name": "Load Media",
+		"description": "",
+		"category": "loaders",
+		"output_node": false
+	}
+}`
+
+func TestV3InputSpecs(t *testing.T) {
+	node_objects := testNodeObjects(t, testV3ObjectInfo)
+	nobject := node_objects.GetNodeObjectByName("LoadMedia")
+	combo := func(name string) *ComboProperty {
+		p, ok := nobject.InputPropertiesByID[name]
+		if !ok {
+			t.Fatalf("no property %s", name)
+		}
+		cp, ok := (*p).ToComboProperty()
+		if !ok {
+			t.Fatalf("%s is a %s, want a COMBO", name, (*p).TypeString())
+		}
+		return cp
+	}
+
+	image := combo("image")
+	if !reflect.DeepEqual(image.Values, []string{"a.png", "b.png"}) || image.Upload != "image" || image.Tooltip() != "the image" {
+		t.Errorf("image values %v, upload %q, tooltip %q", image.Values, image.Upload, image.Tooltip())
+	}
+	if u := combo("video").Upload; u != "video" {
+		t.Errorf("video upload %q, want video", u)
+	}
+	if u := combo("audio").Upload; u != "audio" {
+		t.Errorf("audio upload %q, want audio", u)
+	}
+	if !combo("tags").MultiSelect {
+		t.Error("tags is not a multi select")
+	}
+	if r := combo("model").Remote; r != "/internal/models" {
+		t.Errorf("model remote %q, want /internal/models", r)
+	}
+	count := *nobject.InputPropertiesByID["count"]
+	if !count.ForceInput() || count.Settable() {
+		t.Errorf("count force input %v, settable %v, want an input without a widget", count.ForceInput(), count.Settable())
+	}
+
+	// every upload button has a widget value
+	n, err := NewGraph(node_objects).AddNode("LoadMedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"a.png", "a.mp4", "a.wav", []interface{}{}, "", "image", "video", "audio"}
+	if !reflect.DeepEqual(n.WidgetValuesArray(), want) {
+		t.Errorf("widget values %v, want %v", n.WidgetValuesArray(), want)
+	}
+	if n.GetInputWithName("count") == nil {
+		t.Error("count has no input slot")
+	}
+}
@@ -1,8 +1,150 @@
 package comfy
 
+import "sync"
+
+// QueueItem is a prompt that was queued with the ComfyClient.  Messages for the prompt are
+// delivered on the Messages channel, which is closed once the prompt has stopped or the
+// QueueItem was released.
 type QueueItem struct {
 	PromptID   string                 `json:"prompt_id"`
 	Number     int                    `json:"number"`
 	NodeErrors map[string]interface{} `json:"node_errors"`
 	Workflow   *Graph                 `json:"-"`
+	// DynamicPrompts are the dynamic prompts of the workflow expanded in the queued prompt
+	DynamicPrompts []DynamicPrompt    `json:"-"`
+	Messages       chan PromptMessage `json:"-"`
+	client         *ComfyClient
+	queue          *messageQueue
+	sent           []byte // the JSON POSTed to /prompt
+}
+
+// the size of the Messages channel, messages that are not read yet wait in the messageQueue
+const queueItemMessageBuffer = 64
+
+// Release stops delivering the messages of the prompt and closes the Messages channel, dropping the
+// messages that were not read.  It is used when the messages are no longer wanted, the prompt is not
+// cancelled on the server, see ComfyClient.CancelPrompt.
+func (qi *QueueItem) Release() {
+	if qi.client != nil {
+		qi.client.removeQueuedItem(qi.PromptID)
+	}
+	if qi.queue != nil {
+		qi.queue.discard()
+	}
+}
+
+// messageQueue delivers the messages of a prompt to a channel without blocking the sender, so that a
+// consumer that reads slowly, or not at all, does not hold up the websocket reader and the messages of
+// other prompts.  Messages wait in an unbounded queue, where a progress message replaces the previous
+// progress message of the same node that was not delivered yet.
+type messageQueue struct {
+	mutex     sync.Mutex
+	pending   []PromptMessage
+	closed    bool          // no more messages are posted, out is closed once pending is delivered
+	discarded bool          // pending is dropped and out is closed
+	wake      chan struct{} // tells the pump that pending changed
+	done      chan struct{} // closed when discarded
+	out       chan PromptMessage
+}
+
+func newMessageQueue(out chan PromptMessage) *messageQueue {
+	q := &messageQueue{
+		pending: make([]PromptMessage, 0),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		out:     out,
+	}
+	go q.pump()
+	return q
+}
+
+// post queues the message for delivery, messages posted after close are ignored
+func (q *messageQueue) post(m PromptMessage) {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return
+	}
+	n := len(q.pending)
+	if n != 0 && m.Type == "progress" && q.pending[n-1].Type == "progress" &&
+		q.pending[n-1].Message.(*PromptMessageProgress).NodeID == m.Message.(*PromptMessageProgress).NodeID {
+		q.pending[n-1] = m
+	} else {
+		q.pending = append(q.pending, m)
+	}
+	q.mutex.Unlock()
+	q.signal()
+}
+
+// close closes the channel once the queued messages are delivered
+func (q *messageQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.signal()
+}
+
+// discard drops the queued messages and closes the channel
+func (q *messageQueue) discard() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.discarded {
+		return
+	}
+	q.closed = true
+	q.discarded = true
+	q.pending = nil
+	close(q.done)
+}
+
+func (q *messageQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump is the only sender on out, and closes it
+func (q *messageQueue) pump() {
+	defer close(q.out)
+	for {
+		q.mutex.Lock()
+		if len(q.pending) == 0 {
+			closed := q.closed
+			q.mutex.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-q.wake:
+			case <-q.done:
+				return
+			}
+			continue
+		}
+		m := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mutex.Unlock()
+
+		select {
+		case q.out <- m:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// nodeTitle returns the title (or display name when the title is absent) of the node in the queued workflow
+func (qi *QueueItem) nodeTitle(nodeID int) string {
+	if qi.Workflow == nil {
+		return ""
+	}
+	node := qi.Workflow.GetNodeById(nodeID)
+	if node == nil {
+		return ""
+	}
+	if node.Title != "" {
+		return node.Title
+	}
+	return node.DisplayName
 }
@@ -0,0 +1,63 @@
+package comfy
+
+import (
+	"testing"
+	"time"
+)
+
+func progressMessage(nodeID int, value int) PromptMessage {
+	return PromptMessage{Type: "progress", Message: &PromptMessageProgress{NodeID: nodeID, Value: value, Max: 10}}
+}
+
+func TestMessageQueue(t *testing.T) {
+	out := make(chan PromptMessage)
+	q := newMessageQueue(out)
+
+	// nothing reads out yet, posting does not block
+	posted := make(chan struct{})
+	go func() {
+		q.post(PromptMessage{Type: "started"})
+		for i := 1; i <= 1000; i++ {
+			q.post(progressMessage(3, i))
+		}
+		q.post(progressMessage(8, 1))
+		q.post(PromptMessage{Type: "stopped"})
+		q.close()
+		q.post(PromptMessage{Type: "started"})
+		close(posted)
+	}()
+	select {
+	case <-posted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("posting blocked on an unread channel")
+	}
+
+	messages := drain(t, out)
+	if n := len(messages); n < 4 || n > 1002 || messages[0].Type != "started" || messages[n-1].Type != "stopped" {
+		t.Fatalf("received %d messages, from %s to %s", n, messages[0].Type, messages[n-1].Type)
+	}
+	// progress is merged while undelivered, the last value of each node is always delivered
+	last := messages[len(messages)-3].Message.(*PromptMessageProgress)
+	if last.NodeID != 3 || last.Value != 1000 {
+		t.Errorf("last progress of node 3 is %d", last.Value)
+	}
+	if p := messages[len(messages)-2].Message.(*PromptMessageProgress); p.NodeID != 8 {
+		t.Errorf("the progress of node 8 is merged into the one of node %d", p.NodeID)
+	}
+}
+
+func TestMessageQueueDiscard(t *testing.T) {
+	out := make(chan PromptMessage)
+	q := newMessageQueue(out)
+	for i := 0; i < 10; i++ {
+		q.post(PromptMessage{Type: "executing"})
+	}
+	q.discard()
+	q.discard()
+	q.post(PromptMessage{Type: "executing"})
+
+	// at most the message the pump was sending is delivered
+	if n := len(drain(t, out)); n > 1 {
+		t.Errorf("received %d messages after discard", n)
+	}
+}
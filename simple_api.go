@@ -1,5 +1,7 @@
 package comfy
 
+import "fmt"
+
 type SimpleAPI struct {
 	Properties  map[string]Property
 	OutputNodes []*GraphNode
@@ -53,3 +55,21 @@ func (t *Graph) GetSimpleAPI(title *string) *SimpleAPI {
 
 	return retv
 }
+
+// OutputNames returns the output nodes by name.  A node is named by its title, or by its type and id,
+// e.g. "SaveImage_9", when it has no title or when its title is the title of another output node.
+func (s *SimpleAPI) OutputNames() map[string]*GraphNode {
+	titles := make(map[string]int)
+	for _, n := range s.OutputNodes {
+		titles[n.Title]++
+	}
+	retv := make(map[string]*GraphNode, len(s.OutputNodes))
+	for _, n := range s.OutputNodes {
+		name := n.Title
+		if name == "" || titles[name] > 1 {
+			name = fmt.Sprintf("%s_%d", n.Type, n.ID)
+		}
+		retv[name] = n
+	}
+	return retv
+}
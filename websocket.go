@@ -1,6 +1,7 @@
 package comfy
 
 import (
+	"context"
 	"math"
 	"sync"
 	"time"
@@ -22,8 +23,11 @@ type WebSocketClient struct {
 	isConnected bool
 	retryCount  int
 	mutex       sync.Mutex // For thread-safe access to the WebSocket connection
+	connMutex   sync.Mutex // guards conn while the client is being stopped
 	callback    WebSocketCallback
 	maxDelay    time.Duration // The maximum delay, e.g., 1 minute
+	cancel      context.CancelFunc
+	finished    chan struct{} // closed when the reconnect loop exits
 }
 
 func (c *WebSocketClient) Ping() error {
@@ -40,37 +44,80 @@ func (c *WebSocketClient) Start() {
 		return
 	}
 	c.isRunning = true
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.finished = make(chan struct{})
 	forceLog := true
 	loop := func() {
 		logger.Debug("websocket client loop enter >>")
-		for c.isRunning {
-			if err := c.connect(); err != nil {
+		defer close(c.finished)
+		for ctx.Err() == nil {
+			if err := c.connect(ctx); err != nil {
 				if c.isConnected || forceLog {
 					logger.Errorf("websocket connecting failed,err: %v", err)
 				}
 				forceLog = false
 				c.isConnected = false
 				delay := c.getReconnectDelay()
-				time.Sleep(delay)
+				select {
+				case <-ctx.Done():
+				case <-time.After(delay):
+				}
 				continue
 			}
 			if !c.isConnected {
 				logger.Info("comfy websocket connected >>")
 			}
 			c.isConnected = true
+			c.retryCount = 0
 			c.callback.OnWebsocketConnected()
 			c.handleMessages()
+			c.isConnected = false
+			forceLog = true
+			c.callback.OnWebsocketDisconnected()
 		}
+		c.isConnected = false
 		logger.Debug("websocket client loop exit <<")
 	}
 	go loop()
 }
 
-func (c *WebSocketClient) connect() error {
-	conn, _, err := websocket.DefaultDialer.Dial(c.url, nil)
+// Stop ends the reconnect loop and closes the connection.  It waits for the loop to exit
+// or for ctx to be done, whichever happens first.
+func (c *WebSocketClient) Stop(ctx context.Context) error {
+	if !c.isRunning {
+		return nil
+	}
+	c.isRunning = false
+	c.cancel()
+
+	// closing the connection unblocks the reader in handleMessages
+	c.connMutex.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.connMutex.Unlock()
+
+	select {
+	case <-c.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *WebSocketClient) connect(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, nil)
 	if err != nil {
 		return err
 	}
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if ctx.Err() != nil {
+		// stopped while dialing
+		conn.Close()
+		return ctx.Err()
+	}
 	c.conn = conn
 	return nil
 }
@@ -86,6 +133,10 @@ func (c *WebSocketClient) handleMessages() {
 			logger.Warnf("Read error: %v", err)
 			break
 		}
+		// wait for any QueuePrompt in flight to register its item before dispatching,
+		// so messages about a newly queued prompt are not dropped
+		c.LockRead()
+		c.UnlockRead()
 		if c.callback != nil {
 			c.callback.OnMessage(string(message))
 		}
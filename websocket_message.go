@@ -2,7 +2,6 @@ package comfy
 
 import (
 	"encoding/json"
-	"strconv"
 
 	"github.com/er1cw00/comfy.go/base/logger"
 )
@@ -109,7 +108,7 @@ func (mde *MessageDataExecuting) UnmarshalJSON(b []byte) error {
 	mde.BatchClose = temp.BatchClose
 	// Convert string to int
 	if temp.Node != nil {
-		i, err := strconv.Atoi(*temp.Node)
+		i, err := parseNodeID(*temp.Node)
 		if err != nil {
 			return err
 		}
@@ -126,12 +125,42 @@ func (mde *MessageDataExecuting) UnmarshalJSON(b []byte) error {
 */
 
 type MessageDataProgress struct {
-	Value int `json:"value"`
-	Max   int `json:"max"`
+	Value    int    `json:"value"`
+	Max      int    `json:"max"`
+	PromptID string `json:"prompt_id"`
+	Node     *int   `json:"node"`
+}
+
+func (mdp *MessageDataProgress) UnmarshalJSON(b []byte) error {
+	var temp struct {
+		Value    int     `json:"value"`
+		Max      int     `json:"max"`
+		PromptID string  `json:"prompt_id"`
+		Node     *string `json:"node"`
+	}
+	if err := json.Unmarshal(b, &temp); err != nil {
+		return err
+	}
+
+	mdp.Value = temp.Value
+	mdp.Max = temp.Max
+	mdp.PromptID = temp.PromptID
+	// Convert string to int
+	if temp.Node != nil {
+		i, err := parseNodeID(*temp.Node)
+		if err != nil {
+			return err
+		}
+		mdp.Node = &i
+	} else {
+		mdp.Node = nil
+	}
+
+	return nil
 }
 
 /*
-{"type": "progress", "data": {"value": 1, "max": 20}}
+{"type": "progress", "data": {"value": 1, "max": 20, "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "node": "3"}}
 */
 
 type MessageDataExecuted struct {
@@ -214,7 +243,7 @@ func (mde *MessageDataExecuted) UnmarshalJSON(b []byte) error {
 	mde.PromptID = temp.PromptID
 
 	// Convert string to int
-	i, err := strconv.Atoi(temp.Node)
+	i, err := parseNodeID(temp.Node)
 	if err != nil {
 		return err
 	}
@@ -251,5 +280,9 @@ type MessageExecutionError struct {
 	ExceptionType    string                 `json:"exception_type"`
 	Traceback        []string               `json:"traceback"`
 	CurrentInputs    map[string]interface{} `json:"current_inputs"`
-	CurrentOutputs   map[int]interface{}    `json:"current_outputs"`
+	CurrentOutputs   interface{}            `json:"current_outputs"` // a map of outputs, or a list of node ids in newer servers
 }
+
+/*
+{"type": "execution_error", "data": {"prompt_id": "dc7093d7-980a-4fe6-bf0c-f6fef932c74b", "node_id": "3", "node_type": "KSampler", "executed": ["4", "5"], "exception_message": "...", "exception_type": "RuntimeError", "traceback": ["  File ...\n"], "current_inputs": {"seed": ["42"]}, "current_outputs": ["4", "5", "3"]}}
+*/